@@ -4,6 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/TxtAnime/txt-anime/pkgs/backoff"
+	"github.com/TxtAnime/txt-anime/pkgs/comicpage"
+	"github.com/TxtAnime/txt-anime/pkgs/llmjson"
+	"github.com/TxtAnime/txt-anime/pkgs/storyboard"
+	"golang.org/x/image/font/opentype"
 )
 
 // Config 应用配置
@@ -15,6 +23,9 @@ type Config struct {
 	Storage     StorageConfig    `json:"storage"`
 	TTSProvider string           `json:"tts_provider"` // "qiniu" 或 "tencent"
 	TencentTTS  TencentTTSConfig `json:"tencent_tts"`
+	Processor   ProcessorConfig  `json:"processor"`
+	Webhook     WebhookConfig    `json:"webhook"`
+	Comic       ComicConfig      `json:"comic"`
 }
 
 // ServerConfig 服务器配置
@@ -27,6 +38,9 @@ type MongoDBConfig struct {
 	URI        string `json:"uri"`
 	Database   string `json:"database"`
 	Collection string `json:"collection"`
+
+	// ProjectCollection 按章节拆分的项目所在的集合，默认为 "projects"
+	ProjectCollection string `json:"project_collection"`
 }
 
 // AIConfig AI 服务配置
@@ -35,6 +49,43 @@ type AIConfig struct {
 	APIKey     string `json:"api_key"`
 	TextModel  string `json:"text_model"`
 	ImageModel string `json:"image_model"`
+
+	// MaxChunkTokens 剧本生成时单次调用的小说 token 上限，超过时分段生成；0 使用 novel2script 的默认值
+	MaxChunkTokens int `json:"max_chunk_tokens"`
+
+	// ResponseFormat 要求文本模型输出 JSON 的方式："prompt"（默认，只靠提示词）、"json_object" 或 "json_schema"
+	// 服务商不支持时自动降级
+	ResponseFormat string `json:"response_format"`
+
+	// DisableReferenceSheets 不生成角色设定图，场景图片只用提示词生成
+	// 默认在生成场景图片前为每个角色生成设定图，并在服务商支持图片编辑接口时作为参考图发送
+	DisableReferenceSheets bool `json:"disable_reference_sheets"`
+
+	// 图片生成请求的并发、限流与重试，同一 base_url 的所有任务（包括重新生成）共享
+	ImageConcurrency       int     `json:"image_concurrency"`         // 同时进行的图片生成请求数，默认 2
+	ImageRatePerMinute     float64 `json:"image_rate_per_minute"`     // 每分钟最多发起的图片生成请求数（令牌桶），0 表示不限制
+	ImageBurst             int     `json:"image_burst"`               // 令牌桶容量，默认等于 image_concurrency
+	ImageMaxAttempts       int     `json:"image_max_attempts"`        // 单张图片最多请求次数（含首次），默认 4，1 表示不重试
+	ImageBackoffSeconds    int     `json:"image_backoff_seconds"`     // 首次重试前的等待秒数，之后翻倍并加入随机抖动，默认 2
+	ImageMaxBackoffSeconds int     `json:"image_max_backoff_seconds"` // 重试等待秒数上限，默认 60
+}
+
+// responseFormat 返回解析后的 JSON 输出方式，配置已在 LoadConfig 中校验
+func (c AIConfig) responseFormat() llmjson.Format {
+	format, _ := llmjson.ParseFormat(c.ResponseFormat)
+	return format
+}
+
+// imageLimiter 返回图片服务商共享的限流器
+func (c AIConfig) imageLimiter() *storyboard.Limiter {
+	return storyboard.ProviderLimiter(c.BaseURL, storyboard.Limits{
+		Concurrency:   c.ImageConcurrency,
+		RatePerMinute: c.ImageRatePerMinute,
+		Burst:         c.ImageBurst,
+		MaxAttempts:   c.ImageMaxAttempts,
+		Backoff:       time.Duration(c.ImageBackoffSeconds) * time.Second,
+		MaxBackoff:    time.Duration(c.ImageMaxBackoffSeconds) * time.Second,
+	})
 }
 
 // QiniuConfig 七牛云配置
@@ -47,7 +98,9 @@ type QiniuConfig struct {
 
 // StorageConfig 存储配置
 type StorageConfig struct {
-	OutputDir string `json:"output_dir"`
+	OutputDir string `json:"output_dir"` // 任务工作目录；backend 为 local 时同时也是产物存储目录
+	Backend   string `json:"backend"`    // 产物存储后端："local"（默认）或 "qiniu"
+	KeyPrefix string `json:"key_prefix"` // 七牛云存储的 key 前缀，如 "tasks/"
 }
 
 // TencentTTSConfig 腾讯云TTS配置
@@ -57,6 +110,225 @@ type TencentTTSConfig struct {
 	Region    string `json:"region"`
 }
 
+// ProcessorConfig 任务处理器配置
+type ProcessorConfig struct {
+	MaxRetries          int `json:"max_retries"`           // 失败后最多重试次数，-1 表示不重试
+	RetryBackoffSeconds int `json:"retry_backoff_seconds"` // 首次重试前的等待秒数，之后指数递增
+	MaxBackoffSeconds   int `json:"max_backoff_seconds"`   // 重试等待秒数上限
+
+	Workers      int    `json:"workers"`       // 并发处理任务的 worker 数量
+	LeaseSeconds int    `json:"lease_seconds"` // 任务租约时长，worker 崩溃后租约过期的任务会被重新认领
+	WorkerID     string `json:"worker_id"`     // 本实例的 worker ID，为空时使用主机名加随机后缀；租约持有者为 worker ID 加每次认领的随机后缀
+}
+
+// WebhookConfig 任务终态回调配置
+type WebhookConfig struct {
+	MaxAttempts       int    `json:"max_attempts"`        // 最多投递次数（含首次）
+	BackoffSeconds    int    `json:"backoff_seconds"`     // 首次重试前的等待秒数，之后指数递增
+	MaxBackoffSeconds int    `json:"max_backoff_seconds"` // 重试等待秒数上限
+	TimeoutSeconds    int    `json:"timeout_seconds"`     // 单次请求超时秒数
+	PublicBaseURL     string `json:"public_base_url"`     // 对外访问地址，用于把相对路径的产物 URL 补全为完整地址
+
+	// AllowedHosts 允许解析到内网地址的回调主机名或 IP（不区分大小写，不含端口）
+	// 其他回调地址只能解析到公网地址，防止通过回调访问内网服务（SSRF）
+	AllowedHosts []string `json:"allowed_hosts"`
+}
+
+// ComicConfig 漫画页排版配置
+type ComicConfig struct {
+	Disable     bool     `json:"disable"`      // 不排版漫画页
+	FontPath    string   `json:"font_path"`    // 中文字体文件（.ttf、.otf 或 .ttc），为空时查找系统中的中文字体
+	Layouts     []string `json:"layouts"`      // 依次使用的版式名，默认为 comicpage.DefaultLayouts
+	LayoutsFile string   `json:"layouts_file"` // 自定义版式文件，格式同 pkgs/comicpage/layouts.json，与内置版式同名时覆盖内置版式
+	PageWidth   int      `json:"page_width"`   // 页面宽度，默认 1600
+	PageHeight  int      `json:"page_height"`  // 页面高度，默认 2400
+
+	StripWidth     int `json:"strip_width"`      // 条漫宽度，默认 800；导出时可以单独指定
+	StripMaxHeight int `json:"strip_max_height"` // 条漫每段的最大高度，默认 1280；导出时可以单独指定
+}
+
+// layouts 返回依次使用的版式
+func (c ComicConfig) layouts() ([]comicpage.Layout, error) {
+	var custom []comicpage.Layout
+	if c.LayoutsFile != "" {
+		data, err := os.ReadFile(c.LayoutsFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取版式文件失败: %w", err)
+		}
+		if custom, err = comicpage.ParseLayouts(data); err != nil {
+			return nil, err
+		}
+	}
+
+	names := c.Layouts
+	if len(names) == 0 {
+		names = comicpage.DefaultLayouts
+	}
+	layouts := make([]comicpage.Layout, 0, len(names))
+	for _, name := range names {
+		l, ok := findComicLayout(custom, name)
+		if !ok {
+			l, ok = comicpage.LookupLayout(name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("未知的版式: %s", name)
+		}
+		layouts = append(layouts, l)
+	}
+	return layouts, nil
+}
+
+// findComicLayout 在 list 中按名称查找版式
+func findComicLayout(list []comicpage.Layout, name string) (comicpage.Layout, bool) {
+	for _, l := range list {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return comicpage.Layout{}, false
+}
+
+// font 加载漫画页和条漫使用的中文字体：配置了 font_path 时加载该文件，否则查找系统中的中文字体
+// 字体不存在或不包含汉字时返回错误（否则气泡中的中文会显示为方框）
+func (c ComicConfig) font() (*opentype.Font, error) {
+	if c.FontPath == "" {
+		f, _, err := comicpage.FindFont()
+		if err != nil {
+			return nil, fmt.Errorf("%w，请安装中文字体或配置 comic.font_path", err)
+		}
+		return f, nil
+	}
+	f, err := comicpage.LoadFont(c.FontPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载字体 %s 失败: %w", c.FontPath, err)
+	}
+	if !comicpage.HasCJK(f) {
+		return nil, fmt.Errorf("字体 %s 不包含中文字形", c.FontPath)
+	}
+	return f, nil
+}
+
+// pageConfig 构建漫画页排版配置
+func (c ComicConfig) pageConfig(font *opentype.Font) (comicpage.Config, error) {
+	layouts, err := c.layouts()
+	if err != nil {
+		return comicpage.Config{}, err
+	}
+	return comicpage.Config{Width: c.PageWidth, Height: c.PageHeight, Font: font, Layouts: layouts}, nil
+}
+
+// stripConfig 构建条漫排版配置，width 和 maxHeight 为 0 时使用配置文件中的值
+func (c ComicConfig) stripConfig(font *opentype.Font, width, maxHeight int) comicpage.StripConfig {
+	if width == 0 {
+		width = c.StripWidth
+	}
+	if maxHeight == 0 {
+		maxHeight = c.StripMaxHeight
+	}
+	return comicpage.StripConfig{Width: width, MaxHeight: maxHeight, Font: font}
+}
+
+// timeout 返回单次回调请求超时时间
+func (c WebhookConfig) timeout() time.Duration {
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// retryBackoff 计算第 attempt 次投递失败后的等待时间，按指数退避并随机抖动（见 backoff.Jittered）
+func (c WebhookConfig) retryBackoff(attempt int) time.Duration {
+	return backoff.Jittered(time.Duration(c.BackoffSeconds)*time.Second, time.Duration(c.MaxBackoffSeconds)*time.Second, attempt)
+}
+
+// hostAllowed 回调主机是否在 allowed_hosts 中
+func (c WebhookConfig) hostAllowed(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	for _, allowed := range c.AllowedHosts {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "."), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// leaseDuration 返回任务租约时长
+func (c ProcessorConfig) leaseDuration() time.Duration {
+	return time.Duration(c.LeaseSeconds) * time.Second
+}
+
+// retryBackoff 计算第 retry 次重试前的等待时间
+func (c ProcessorConfig) retryBackoff(retry int) time.Duration {
+	backoff := time.Duration(c.RetryBackoffSeconds) * time.Second
+	maxBackoff := time.Duration(c.MaxBackoffSeconds) * time.Second
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// maxRetries 返回允许的最大重试次数
+func (c ProcessorConfig) maxRetries() int {
+	if c.MaxRetries < 0 {
+		return 0
+	}
+	return c.MaxRetries
+}
+
+// ttsProvider 返回使用的 TTS 提供商，未配置时默认使用七牛云
+func (c *Config) ttsProvider() string {
+	if c.TTSProvider == "" {
+		return "qiniu"
+	}
+	return c.TTSProvider
+}
+
+// setDefaults 填充未配置项的默认值
+func (c *Config) setDefaults() {
+	if c.MongoDB.ProjectCollection == "" {
+		c.MongoDB.ProjectCollection = "projects"
+	}
+	if c.AI.ImageConcurrency <= 0 {
+		c.AI.ImageConcurrency = 2
+	}
+	if c.AI.ImageMaxAttempts <= 0 {
+		c.AI.ImageMaxAttempts = 4
+	}
+	if c.AI.ImageBackoffSeconds <= 0 {
+		c.AI.ImageBackoffSeconds = 2
+	}
+	if c.AI.ImageMaxBackoffSeconds <= 0 {
+		c.AI.ImageMaxBackoffSeconds = 60
+	}
+	if c.Processor.MaxRetries == 0 {
+		c.Processor.MaxRetries = 3
+	}
+	if c.Processor.RetryBackoffSeconds <= 0 {
+		c.Processor.RetryBackoffSeconds = 30
+	}
+	if c.Processor.MaxBackoffSeconds <= 0 {
+		c.Processor.MaxBackoffSeconds = 600
+	}
+	if c.Processor.Workers <= 0 {
+		c.Processor.Workers = 1
+	}
+	if c.Processor.LeaseSeconds <= 0 {
+		c.Processor.LeaseSeconds = 60
+	}
+	if c.Webhook.MaxAttempts <= 0 {
+		c.Webhook.MaxAttempts = 5
+	}
+	if c.Webhook.BackoffSeconds <= 0 {
+		c.Webhook.BackoffSeconds = 5
+	}
+	if c.Webhook.MaxBackoffSeconds <= 0 {
+		c.Webhook.MaxBackoffSeconds = 300
+	}
+	if c.Webhook.TimeoutSeconds <= 0 {
+		c.Webhook.TimeoutSeconds = 10
+	}
+}
+
 // LoadConfig 加载配置文件
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -68,6 +340,22 @@ func LoadConfig(path string) (*Config, error) {
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %w", err)
 	}
+	config.setDefaults()
+
+	if _, err := llmjson.ParseFormat(config.AI.ResponseFormat); err != nil {
+		return nil, fmt.Errorf("配置 ai.response_format 无效: %w", err)
+	}
+	if !config.Comic.Disable {
+		if _, err := config.Comic.layouts(); err != nil {
+			return nil, fmt.Errorf("配置 comic 无效: %w", err)
+		}
+		if _, err := config.Comic.font(); err != nil {
+			return nil, fmt.Errorf("配置 comic 无效: %w", err)
+		}
+	}
+	if err := validateStripSize(config.Comic.StripWidth, config.Comic.StripMaxHeight); err != nil {
+		return nil, fmt.Errorf("配置 comic 无效: %w", err)
+	}
 
 	return &config, nil
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestComicFontRequiresCJK(t *testing.T) {
+	// Go 字体不包含汉字，中文会显示为方框
+	path := writeTempFile(t, "goregular.ttf", string(goregular.TTF))
+	if _, err := (ComicConfig{FontPath: path}).font(); err == nil || !strings.Contains(err.Error(), "不包含中文字形") {
+		t.Errorf("font() = %v, want missing CJK glyphs error", err)
+	}
+
+	if _, err := (ComicConfig{FontPath: path + ".missing"}).font(); err == nil {
+		t.Error("font() with a missing file should fail")
+	}
+}
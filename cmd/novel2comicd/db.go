@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -14,6 +19,7 @@ import (
 type DB struct {
 	client     *mongo.Client
 	collection *mongo.Collection
+	projects   *mongo.Collection
 }
 
 // NewDB 创建数据库连接
@@ -31,14 +37,43 @@ func NewDB(cfg MongoDBConfig) (*DB, error) {
 		return nil, fmt.Errorf("ping MongoDB 失败: %w", err)
 	}
 
-	collection := client.Database(cfg.Database).Collection(cfg.Collection)
+	database := client.Database(cfg.Database)
 
 	return &DB{
 		client:     client,
-		collection: collection,
+		collection: database.Collection(cfg.Collection),
+		projects:   database.Collection(cfg.ProjectCollection),
 	}, nil
 }
 
+// EnsureIndexes 创建任务查询所需的索引（已存在时不会重复创建）
+func (db *DB) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// 任务列表默认按创建时间排序，并支持按创建时间范围过滤
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+		// 按状态过滤的任务列表，以及 worker 认领任务（按状态过滤、按创建时间排序）
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		// 按更新时间排序
+		{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+		// 按名称排序
+		{Keys: bson.D{{Key: "name", Value: 1}}},
+		// 项目的子任务，以及子任务生成剧本后释放下一个子任务
+		{Keys: bson.D{{Key: "project_id", Value: 1}, {Key: "sequence", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "wait_for", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// 认领到期的待投递回调
+		{Keys: bson.D{{Key: "webhook_pending.next_attempt_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		// worker 认领有待执行重新生成任务的已完成任务
+		{Keys: bson.D{{Key: "regenerate_jobs.status", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("创建索引失败: %w", err)
+	}
+	return nil
+}
+
 // Close 关闭数据库连接
 func (db *DB) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -74,23 +109,86 @@ func (db *DB) GetTask(id string) (*Task, error) {
 	return &task, nil
 }
 
-// GetTasks 获取所有任务
-func (db *DB) GetTasks() ([]Task, error) {
+// TaskListQuery 任务列表查询条件
+type TaskListQuery struct {
+	Statuses      []string   // 状态过滤，为空时不过滤
+	CreatedAfter  *time.Time // 创建时间下限（含）
+	CreatedBefore *time.Time // 创建时间上限（不含）
+	Name          string     // 名称关键字，不区分大小写
+	SortField     string     // 排序字段（bson 字段名）
+	SortDesc      bool       // 是否倒序
+	Offset        int64
+	Limit         int64
+}
+
+// taskListProjection 任务列表不返回的大字段
+var taskListProjection = bson.M{
+	"novel":              0,
+	"scenes":             0,
+	"checkpoint":         0,
+	"callback_secret":    0,
+	"webhook_deliveries": 0,
+	"artifact_versions":  0,
+	"script":             0,
+}
+
+// GetTasks 分页查询任务列表，返回当前页的任务（不含小说原文和产物）以及符合条件的总数
+func (db *DB) GetTasks(query TaskListQuery) ([]Task, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := db.collection.Find(ctx, bson.M{})
+	filter := bson.M{}
+	if len(query.Statuses) > 0 {
+		statuses := append([]string{}, query.Statuses...)
+		for _, status := range query.Statuses {
+			if status == TaskStatusQueued {
+				statuses = append(statuses, taskStatusLegacyDoing)
+				break
+			}
+		}
+		filter["status"] = bson.M{"$in": statuses}
+	}
+	if query.CreatedAfter != nil || query.CreatedBefore != nil {
+		createdAt := bson.M{}
+		if query.CreatedAfter != nil {
+			createdAt["$gte"] = *query.CreatedAfter
+		}
+		if query.CreatedBefore != nil {
+			createdAt["$lt"] = *query.CreatedBefore
+		}
+		filter["created_at"] = createdAt
+	}
+	if query.Name != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(query.Name), "$options": "i"}
+	}
+
+	total, err := db.collection.CountDocuments(ctx, filter)
 	if err != nil {
-		return nil, fmt.Errorf("查询任务列表失败: %w", err)
+		return nil, 0, fmt.Errorf("统计任务数量失败: %w", err)
+	}
+
+	order := 1
+	if query.SortDesc {
+		order = -1
+	}
+	opts := options.Find().
+		SetProjection(taskListProjection).
+		SetSort(bson.D{{Key: query.SortField, Value: order}, {Key: "_id", Value: order}}).
+		SetSkip(query.Offset).
+		SetLimit(query.Limit)
+
+	cursor, err := db.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询任务列表失败: %w", err)
 	}
 	defer cursor.Close(ctx)
 
-	var tasks []Task
+	tasks := make([]Task, 0)
 	if err := cursor.All(ctx, &tasks); err != nil {
-		return nil, fmt.Errorf("解析任务列表失败: %w", err)
+		return nil, 0, fmt.Errorf("解析任务列表失败: %w", err)
 	}
 
-	return tasks, nil
+	return tasks, total, nil
 }
 
 // UpdateTask 更新任务
@@ -111,23 +209,428 @@ func (db *DB) UpdateTask(task *Task) error {
 	return nil
 }
 
-// GetDoingTasks 获取所有 doing 状态的任务
-func (db *DB) GetDoingTasks() ([]Task, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// errLeaseLost 任务租约已不属于当前 worker（已过期被其他 worker 认领，或任务已被取消、删除）
+var errLeaseLost = errors.New("任务租约已失效")
+
+// ClaimTask 原子地认领一个可执行的任务并获得租约
+// 可执行的任务包括：排队中且已到达重试时间的任务（项目子任务还要求上一个子任务已生成剧本），
+// 以及租约已过期（持有者崩溃）的 running 任务。没有可执行的任务时返回 nil, nil
+func (db *DB) ClaimTask(owner string, lease time.Duration) (*Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := db.collection.Find(ctx, bson.M{"status": "doing"})
+	now := time.Now()
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{
+				"status":   bson.M{"$in": bson.A{TaskStatusQueued, taskStatusLegacyDoing}},
+				"wait_for": bson.M{"$in": bson.A{"", nil}},
+				"$or": bson.A{
+					bson.M{"next_run_at": bson.M{"$exists": false}},
+					bson.M{"next_run_at": bson.M{"$lte": now}},
+				},
+			},
+			bson.M{
+				"status": TaskStatusRunning,
+				"$or": bson.A{
+					bson.M{"lease_expires_at": bson.M{"$exists": false}},
+					bson.M{"lease_expires_at": bson.M{"$lt": now}},
+				},
+			},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":           TaskStatusRunning,
+			"lease_owner":      owner,
+			"lease_expires_at": now.Add(lease),
+			"updated_at":       now,
+		},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var task Task
+	err := db.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&task)
 	if err != nil {
-		return nil, fmt.Errorf("查询 doing 任务失败: %w", err)
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("认领任务失败: %w", err)
 	}
-	defer cursor.Close(ctx)
+	return &task, nil
+}
 
-	var tasks []Task
-	if err := cursor.All(ctx, &tasks); err != nil {
-		return nil, fmt.Errorf("解析 doing 任务列表失败: %w", err)
+// ClaimRegeneration 原子地认领一个有待执行的产物重新生成任务的已完成任务并获得租约，没有时返回 nil, nil
+// 持有者崩溃（租约已过期）时，其未完成的重新生成任务由新的持有者重新执行
+func (db *DB) ClaimRegeneration(owner string, lease time.Duration) (*Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"status":                 TaskStatusDone,
+		"regenerate_jobs.status": bson.M{"$in": bson.A{RegenerateJobQueued, RegenerateJobRunning}},
+		"$or": bson.A{
+			bson.M{"lease_owner": bson.M{"$in": bson.A{"", nil}}},
+			bson.M{"lease_expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"lease_owner":      owner,
+			"lease_expires_at": now.Add(lease),
+		},
 	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "updated_at", Value: 1}}).
+		SetReturnDocument(options.After)
 
-	return tasks, nil
+	var task Task
+	err := db.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&task)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("认领重新生成任务失败: %w", err)
+	}
+	return &task, nil
+}
+
+// AddRegenerateJob 为已完成的任务追加一个产物重新生成任务，只保留最近 keep 个；任务已不是 done 时返回 false
+func (db *DB) AddRegenerateJob(taskID string, job RegenerateJob, keep int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": taskID, "status": TaskStatusDone},
+		bson.M{
+			"$push": bson.M{"regenerate_jobs": bson.M{
+				"$each":  []RegenerateJob{job},
+				"$slice": -keep,
+			}},
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("提交重新生成任务失败: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// SetRegenerateJob 以 owner 的身份更新重新生成任务（按 job_id 定位），写入条件见 updateTaskAs
+func (db *DB) SetRegenerateJob(taskID, owner string, job RegenerateJob) error {
+	return db.updateTaskAs(taskID, owner,
+		bson.M{"$set": bson.M{"regenerate_jobs.$[job]": job}},
+		bson.M{"job.job_id": job.JobID},
+	)
+}
+
+// RenewLease 续期任务租约（心跳），租约已不属于 owner 时返回 errLeaseLost
+func (db *DB) RenewLease(taskID, owner string, lease time.Duration) error {
+	return db.setOwnedTaskFields(taskID, owner, bson.M{
+		"lease_expires_at": time.Now().Add(lease),
+	})
+}
+
+// ReleaseLease 释放当前 worker 持有的租约（不修改任务状态）
+func (db *DB) ReleaseLease(taskID, owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": taskID, "lease_owner": owner},
+		bson.M{"$set": bson.M{"lease_owner": "", "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("释放任务租约失败: %w", err)
+	}
+	return nil
+}
+
+// CancelTask 将未结束的任务置为 cancelled，任务已处于终态时返回 false
+// 取消后正在处理该任务的 worker 对任务的写入都不再生效（见 updateTaskAs），worker 在下一次写入或心跳时发现租约失效并中止
+func (db *DB) CancelTask(taskID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":    taskID,
+			"status": bson.M{"$in": bson.A{TaskStatusQueued, TaskStatusRunning, TaskStatusAwaitingReview, taskStatusLegacyDoing}},
+		},
+		bson.M{"$set": bson.M{
+			"status":      TaskStatusCancelled,
+			"status_desc": "已取消",
+			"updated_at":  time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("取消任务失败: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		if err := db.releaseWaiters(ctx, taskID); err != nil {
+			return true, err
+		}
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// MarkTaskRetry 记录失败并安排重试，任务回到 queued 状态直到 nextRunAt
+func (db *DB) MarkTaskRetry(taskID, owner string, retryCount int, nextRunAt time.Time, lastError, stage, statusDesc string) error {
+	return db.setOwnedTaskFields(taskID, owner, bson.M{
+		"status":      TaskStatusQueued,
+		"status_desc": statusDesc,
+		"retry_count": retryCount,
+		"next_run_at": nextRunAt,
+		"last_error":  lastError,
+		"error_stage": stage,
+		"lease_owner": "",
+	})
+}
+
+// MarkTaskFailed 记录失败并将任务置为 failed 终态
+func (db *DB) MarkTaskFailed(taskID, owner, lastError, stage, statusDesc string) error {
+	err := db.setOwnedTaskFields(taskID, owner, bson.M{
+		"status":      TaskStatusFailed,
+		"status_desc": statusDesc,
+		"last_error":  lastError,
+		"error_stage": stage,
+		"lease_owner": "",
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return db.releaseWaiters(ctx, taskID)
+}
+
+// MarkTaskAwaitingReview 剧本已生成，暂停任务等待人工审核
+func (db *DB) MarkTaskAwaitingReview(taskID, owner string) error {
+	return db.setOwnedTaskFields(taskID, owner, bson.M{
+		"status":      TaskStatusAwaitingReview,
+		"status_desc": "剧本待审核",
+		"lease_owner": "",
+	})
+}
+
+// ApproveScript 审核通过剧本，任务回到 queued 继续处理，任务不在待审核状态时返回 false
+func (db *DB) ApproveScript(taskID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": taskID, "status": TaskStatusAwaitingReview},
+		bson.M{"$set": bson.M{
+			"status":          TaskStatusQueued,
+			"status_desc":     "排队中",
+			"script_approved": true,
+			"next_run_at":     now,
+			"updated_at":      now,
+		}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("审核剧本失败: %w", err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// MarkTaskDone 写入产物并将任务置为 done 终态
+func (db *DB) MarkTaskDone(taskID, owner string, scenes []Scene) error {
+	return db.setOwnedTaskFields(taskID, owner, bson.M{
+		"status":      TaskStatusDone,
+		"status_desc": "完成",
+		"scenes":      scenes,
+		"stale":       TaskStale{Images: []string{}, Audios: []string{}},
+		"last_error":  "",
+		"error_stage": "",
+		"lease_owner": "",
+	})
+}
+
+// SetScriptCheckpoint 保存生成的剧本并记录剧本阶段已完成，同时更新无法对应到角色的名字
+// 剧本生成后释放等待该任务的下一个项目子任务；owner 已不持有租约时返回 errLeaseLost
+func (db *DB) SetScriptCheckpoint(taskID, owner string, script *novel2script.Response) error {
+	err := db.setOwnedTaskFields(taskID, owner, bson.M{
+		"script":            script,
+		"unresolved_names":  script.UnresolvedNames(),
+		"checkpoint.script": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return db.releaseWaiters(ctx, taskID)
+}
+
+// SetTaskPages 保存最近一次排版的漫画页并清除漫画页的过期标记，写入条件见 updateTaskAs
+func (db *DB) SetTaskPages(taskID, owner string, pages []ComicPage) error {
+	return db.updateTaskAs(taskID, owner, bson.M{"$set": bson.M{
+		"pages":       pages,
+		"stale.pages": false,
+		"updated_at":  time.Now(),
+	}})
+}
+
+// SetTaskStrip 保存最近一次导出的条漫并清除条漫的过期标记，只在任务仍为 done 时写入
+func (db *DB) SetTaskStrip(taskID string, strip []StripSegment) error {
+	return db.updateTaskAs(taskID, "", bson.M{"$set": bson.M{
+		"strip":       strip,
+		"stale.strip": false,
+		"updated_at":  time.Now(),
+	}})
+}
+
+// MarkComicsStale 场景图片变化后，将已排版的漫画页和已导出的条漫标记为过期
+func (db *DB) MarkComicsStale(taskID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, field := range []string{"pages", "strip"} {
+		_, err := db.collection.UpdateOne(
+			ctx,
+			bson.M{"_id": taskID, field + ".0": bson.M{"$exists": true}},
+			bson.M{"$set": bson.M{"stale." + field: true}},
+		)
+		if err != nil {
+			return fmt.Errorf("标记漫画页过期失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetStorySummary 保存截至该任务的剧情梗概，供项目中的下一个子任务使用；owner 已不持有租约时返回 errLeaseLost
+func (db *DB) SetStorySummary(taskID, owner, summary string) error {
+	return db.setOwnedTaskFields(taskID, owner, bson.M{"story_summary": summary})
+}
+
+// releaseWaiters 释放等待 taskID 的项目子任务，使其可以被认领
+func (db *DB) releaseWaiters(ctx context.Context, taskID string) error {
+	_, err := db.collection.UpdateMany(
+		ctx,
+		bson.M{"wait_for": taskID},
+		bson.M{"$set": bson.M{
+			"wait_for":    "",
+			"status_desc": "排队中",
+			"updated_at":  time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("释放等待中的子任务失败: %w", err)
+	}
+	return nil
+}
+
+// SaveScript 保存编辑后的剧本和需要重新生成的产物，scenes 不为 nil 时同时替换产物列表；同时更新无法对应到角色的名字
+// 只在剧本版本仍为 revision 且任务处于待审核或已完成状态时写入，否则返回 false（剧本已被修改或任务已开始处理）
+func (db *DB) SaveScript(taskID string, revision int, script *novel2script.Response, scenes []Scene, stale TaskStale) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var revisionFilter any = revision
+	if revision == 0 {
+		// 旧任务没有 script_revision 字段
+		revisionFilter = bson.M{"$in": bson.A{0, nil}}
+	}
+	set := bson.M{
+		"script":           script,
+		"stale":            stale,
+		"unresolved_names": script.UnresolvedNames(),
+		"updated_at":       time.Now(),
+	}
+	if scenes != nil {
+		set["scenes"] = scenes
+	}
+	result, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":             taskID,
+			"script_revision": revisionFilter,
+			"status":          bson.M{"$in": bson.A{TaskStatusAwaitingReview, TaskStatusDone}},
+		},
+		bson.M{
+			"$set": set,
+			"$inc": bson.M{"script_revision": 1},
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("保存剧本失败: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// ClearStale 产物已重新生成，从需要重新生成的列表中移除，dir 为 "images" 或 "audios"
+func (db *DB) ClearStale(taskID, dir, filename string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 只匹配列表中包含该文件的任务，避免对旧任务的 null 字段执行 $pull
+	_, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": taskID, "stale." + dir: filename},
+		bson.M{"$pull": bson.M{"stale." + dir: filename}},
+	)
+	if err != nil {
+		return fmt.Errorf("更新待重新生成产物失败: %w", err)
+	}
+	return nil
+}
+
+// AddCheckpointItem 记录单个产物已完成，field 为 "images"、"audios" 或 "references"，写入条件见 updateTaskAs
+func (db *DB) AddCheckpointItem(taskID, owner, field, filename string) error {
+	err := db.updateTaskAs(taskID, owner, bson.M{
+		"$addToSet": bson.M{"checkpoint." + field: filename},
+		"$set":      bson.M{"updated_at": time.Now()},
+	})
+	if err != nil && !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("更新任务检查点失败: %w", err)
+	}
+	return err
+}
+
+// setOwnedTaskFields 在当前 worker 仍持有租约时更新任务的部分字段
+func (db *DB) setOwnedTaskFields(taskID, owner string, fields bson.M) error {
+	fields["updated_at"] = time.Now()
+	return db.updateTaskAs(taskID, owner, bson.M{"$set": fields})
+}
+
+// updateTaskAs 以 owner 的身份更新任务，arrayFilters 为 update 中 $[<id>] 的过滤条件
+// owner 不为空时要求 owner 仍持有租约，且任务仍为 running（处理中）或 done（重新生成产物中，见 ClaimRegeneration）；
+// owner 为空时用于已完成任务上的同步操作（如重新排版漫画页），要求任务仍为 done。
+// 条件不满足（任务已被取消、删除，或租约已被其他 worker 认领）时不写入并返回 errLeaseLost
+func (db *DB) updateTaskAs(taskID, owner string, update bson.M, arrayFilters ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":         taskID,
+		"lease_owner": owner,
+		"status":      bson.M{"$in": bson.A{TaskStatusRunning, TaskStatusDone}},
+	}
+	if owner == "" {
+		filter = bson.M{"_id": taskID, "status": TaskStatusDone}
+	}
+	opts := options.Update()
+	if len(arrayFilters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+	}
+	result, err := db.collection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return fmt.Errorf("更新任务失败: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return errLeaseLost
+	}
+	return nil
 }
 
 // UpdateTaskStatusDesc 更新任务的状态描述
@@ -151,6 +654,212 @@ func (db *DB) UpdateTaskStatusDesc(taskID, statusDesc string) error {
 	return nil
 }
 
+// UpdateStageProgress 更新任务当前阶段及该阶段的进度，同时刷新状态描述
+// 只在 owner 仍持有租约且任务仍为 running 时写入，否则返回 errLeaseLost；
+// 与 updateTaskAs 不同，不接受 done，任务完成后（包括重新生成产物期间）滞后的进度不会覆盖最终的进度和状态描述
+func (db *DB) UpdateStageProgress(taskID, owner, stage string, progress StageProgress, statusDesc string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": taskID, "lease_owner": owner, "status": TaskStatusRunning},
+		bson.M{"$set": bson.M{
+			"progress.stage":           stage,
+			"progress.stages." + stage: progress,
+			"status_desc":              statusDesc,
+			"updated_at":               time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("更新任务进度失败: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// SetPendingWebhook 记录任务待投递的回调，替换尚未投递完的旧回调
+func (db *DB) SetPendingWebhook(taskID string, pending PendingWebhook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": taskID},
+		bson.M{"$set": bson.M{"webhook_pending": pending}},
+	)
+	if err != nil {
+		return fmt.Errorf("记录待投递回调失败: %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhook 原子地认领一个到期的待投递回调并获得投递租约，没有时返回 nil, nil
+// 投递者崩溃（租约已过期）时，该回调由其他 worker 重新投递
+func (db *DB) ClaimWebhook(owner string, lease time.Duration) (*Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"webhook_pending.next_attempt_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"webhook_pending.lease_expires_at": bson.M{"$exists": false}},
+			bson.M{"webhook_pending.lease_expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"webhook_pending.lease_owner":      owner,
+			"webhook_pending.lease_expires_at": now.Add(lease),
+		},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "webhook_pending.next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var task Task
+	err := db.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&task)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("认领待投递回调失败: %w", err)
+	}
+	return &task, nil
+}
+
+// FinishWebhookAttempt 记录一次投递尝试，投递记录只保留最近 keep 条
+// next 为零值时投递结束，移除待投递回调；否则释放投递租约，等到 next 再次投递。
+// 待投递回调已被新的回调替换或租约已不属于 owner 时只追加投递记录
+func (db *DB) FinishWebhookAttempt(taskID, owner string, delivery WebhookDelivery, keep int, next time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	push := bson.M{"webhook_deliveries": bson.M{
+		"$each":  []WebhookDelivery{delivery},
+		"$slice": -keep,
+	}}
+	update := bson.M{"$push": push}
+	if next.IsZero() {
+		update["$unset"] = bson.M{"webhook_pending": ""}
+	} else {
+		update["$set"] = bson.M{
+			"webhook_pending.attempts":        delivery.Attempt,
+			"webhook_pending.next_attempt_at": next,
+		}
+		update["$unset"] = bson.M{
+			"webhook_pending.lease_owner":      "",
+			"webhook_pending.lease_expires_at": "",
+		}
+	}
+	result, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":                         taskID,
+			"webhook_pending.delivery_id": delivery.DeliveryID,
+			"webhook_pending.lease_owner": owner,
+		},
+		update,
+	)
+	if err == nil && result.MatchedCount == 0 {
+		_, err = db.collection.UpdateOne(ctx, bson.M{"_id": taskID}, bson.M{"$push": push})
+	}
+	if err != nil {
+		return fmt.Errorf("记录 webhook 投递失败: %w", err)
+	}
+	return nil
+}
+
+// NextArtifactVersion 为产物分配下一个版本号，任务不存在时返回 0
+func (db *DB) NextArtifactVersion(taskID, key string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	field := "artifact_versions." + key
+	var task Task
+	err := db.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": taskID},
+		bson.M{"$inc": bson.M{field + ".latest": 1}},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{field: 1}),
+	).Decode(&task)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("分配产物版本号失败: %w", err)
+	}
+	return task.ArtifactVersions[key].Latest, nil
+}
+
+// SceneURLUpdate scenes 中单个产物 URL 的更新
+// 按 scene_id（对话音频还按 line_id）定位元素而不是数组下标：并发的剧本编辑调整了场景或对话的顺序后仍写入正确的位置，
+// 场景或对话已被删除时不写入
+type SceneURLUpdate struct {
+	SceneID int
+	LineID  int    // 对话音频的 line_id，场景图片和旁白音频为 0
+	Field   string // image_url、narration_voice_url 或 voice_url
+	URL     string
+}
+
+// apply 将更新加入 set，返回对应的 arrayFilters
+func (u SceneURLUpdate) apply(set bson.M) []any {
+	if u.LineID > 0 {
+		set["scenes.$[scene].dialogues.$[line]."+u.Field] = u.URL
+		return []any{bson.M{"scene.scene_id": u.SceneID}, bson.M{"line.line_id": u.LineID}}
+	}
+	set["scenes.$[scene]."+u.Field] = u.URL
+	return []any{bson.M{"scene.scene_id": u.SceneID}}
+}
+
+// AddArtifactVersion 以 owner 的身份记录产物的一个新版本，写入条件见 updateTaskAs
+// activate 不为 nil 时同时将其设为当前版本，并按 activate 更新 scenes 中的 URL
+func (db *DB) AddArtifactVersion(taskID, owner, key string, version ArtifactVersion, activate *SceneURLUpdate) error {
+	field := "artifact_versions." + key
+	set := bson.M{"updated_at": time.Now()}
+	var arrayFilters []any
+	if activate != nil {
+		set[field+".active"] = version.Version
+		arrayFilters = activate.apply(set)
+	}
+	err := db.updateTaskAs(taskID, owner, bson.M{
+		"$push": bson.M{field + ".versions": version},
+		"$set":  set,
+	}, arrayFilters...)
+	if err != nil && !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("记录产物版本失败: %w", err)
+	}
+	return err
+}
+
+// ActivateArtifactVersion 将已有版本设为当前版本，并按 update 更新 scenes 中的 URL，版本不存在时返回 false
+func (db *DB) ActivateArtifactVersion(taskID, key string, version int, update SceneURLUpdate) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	field := "artifact_versions." + key
+	set := bson.M{
+		field + ".active": version,
+		"updated_at":      time.Now(),
+	}
+	arrayFilters := update.apply(set)
+	result, err := db.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": taskID, field + ".versions.version": version},
+		bson.M{"$set": set},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: arrayFilters}),
+	)
+	if err != nil {
+		return false, fmt.Errorf("切换产物版本失败: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
 // DeleteTask 删除任务
 func (db *DB) DeleteTask(taskID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -165,5 +874,114 @@ func (db *DB) DeleteTask(taskID string) error {
 		return fmt.Errorf("任务不存在")
 	}
 
+	return db.releaseWaiters(ctx, taskID)
+}
+
+// CreateProject 创建项目及其子任务
+// 子任务插入失败时删除项目，已插入的子任务一并删除
+func (db *DB) CreateProject(project *Project, tasks []*Task) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := db.projects.InsertOne(ctx, project); err != nil {
+		return fmt.Errorf("插入项目失败: %w", err)
+	}
+
+	docs := make([]any, len(tasks))
+	for i, task := range tasks {
+		docs[i] = task
+	}
+	if _, err := db.collection.InsertMany(ctx, docs); err != nil {
+		db.collection.DeleteMany(ctx, bson.M{"project_id": project.ID})
+		db.projects.DeleteOne(ctx, bson.M{"_id": project.ID})
+		return fmt.Errorf("插入子任务失败: %w", err)
+	}
 	return nil
 }
+
+// GetProject 获取项目，不存在时返回 nil, nil
+func (db *DB) GetProject(id string) (*Project, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var project Project
+	err := db.projects.FindOne(ctx, bson.M{"_id": id}).Decode(&project)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询项目失败: %w", err)
+	}
+	return &project, nil
+}
+
+// GetProjectTasks 按顺序获取项目的子任务（不含小说原文和产物）
+func (db *DB) GetProjectTasks(projectID string) ([]Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "sequence", Value: 1}}).
+		SetProjection(taskListProjection)
+	cursor, err := db.collection.Find(ctx, bson.M{"project_id": projectID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("查询子任务失败: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var tasks []Task
+	if err := cursor.All(ctx, &tasks); err != nil {
+		return nil, fmt.Errorf("解析子任务失败: %w", err)
+	}
+	return tasks, nil
+}
+
+// MergeProjectSettings 将子任务设计的角色和地点合并到项目，已有角色和地点保留原设定
+// 以 revision 做乐观并发控制，冲突时重新读取后重试
+func (db *DB) MergeProjectSettings(projectID string, characters character.Bible, locations location.Bible) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		project, err := db.GetProject(projectID)
+		if err != nil {
+			return err
+		}
+		if project == nil {
+			return fmt.Errorf("项目不存在: %s", projectID)
+		}
+
+		mergedCharacters := make(character.Bible, len(project.Characters)+len(characters))
+		for name, c := range characters {
+			mergedCharacters[name] = c
+		}
+		for name, c := range project.Characters {
+			mergedCharacters[name] = c
+		}
+		mergedLocations := make(location.Bible, len(project.Locations)+len(locations))
+		for id, l := range locations {
+			mergedLocations[id] = l
+		}
+		for id, l := range project.Locations {
+			mergedLocations[id] = l
+		}
+		if len(mergedCharacters) == len(project.Characters) && len(mergedLocations) == len(project.Locations) {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result, err := db.projects.UpdateOne(
+			ctx,
+			bson.M{"_id": projectID, "revision": project.Revision},
+			bson.M{
+				"$set": bson.M{"characters": mergedCharacters, "locations": mergedLocations, "updated_at": time.Now()},
+				"$inc": bson.M{"revision": 1},
+			},
+		)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("更新项目角色和地点失败: %w", err)
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("更新项目角色和地点失败: 并发修改冲突")
+}
@@ -76,7 +76,7 @@ while [ $POLL_COUNT -lt $MAX_POLLS ]; do
         echo "✅ 任务完成！(轮询 $POLL_COUNT 次，耗时 $((POLL_COUNT * POLL_INTERVAL)) 秒)"
         echo ""
         break
-    elif [ "$STATUS" = "doing" ]; then
+    elif [ "$STATUS" = "queued" ] || [ "$STATUS" = "running" ]; then
         if [ -n "$STATUS_DESC" ] && [ "$STATUS_DESC" != "" ]; then
             printf "\r   [%3d/%3d] %s... (已等待 %d 秒)" $POLL_COUNT $MAX_POLLS "$STATUS_DESC" $((POLL_COUNT * POLL_INTERVAL))
         else
@@ -86,6 +86,7 @@ while [ $POLL_COUNT -lt $MAX_POLLS ]; do
     else
         echo ""
         echo "❌ 任务状态异常: $STATUS"
+        echo "   错误信息: $(echo "$STATUS_RESPONSE" | jq -r '.lastError // ""')"
         exit 1
     fi
 done
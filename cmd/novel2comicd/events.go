@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 任务事件类型
+const (
+	EventSnapshot   = "snapshot"   // 订阅时的任务快照
+	EventStatus     = "status"     // 任务状态变化
+	EventProgress   = "progress"   // 阶段进度变化
+	EventArtifact   = "artifact"   // 单个产物可用
+	EventRegenerate = "regenerate" // 产物重新生成任务的状态变化，内容为 RegenerateJob
+	EventError      = "error"      // 处理出错
+)
+
+const (
+	// eventBufferSize 每个任务保留的最近事件数，用于断线重连时补发
+	eventBufferSize = 256
+	// subscriberBufferSize 每个订阅者的待发送事件数，写满时断开该订阅者，由客户端重连补发
+	subscriberBufferSize = 64
+	// topicIdleTimeout 没有订阅者且长时间没有新事件的任务会被清理
+	topicIdleTimeout = 10 * time.Minute
+)
+
+// TaskEvent 任务事件
+type TaskEvent struct {
+	Seq  int64  // 任务内递增的序号
+	Type string // 事件类型，见 Event* 常量
+	Data any    // 事件内容，序列化为 JSON 发送
+}
+
+// ProgressEvent 阶段进度事件内容
+type ProgressEvent struct {
+	Stage      string        `json:"stage"`
+	Progress   StageProgress `json:"progress"`
+	StatusDesc string        `json:"statusDesc"`
+}
+
+// ArtifactEvent 产物可用事件内容
+type ArtifactEvent struct {
+	Kind      string `json:"kind"` // image、audio、reference（角色设定图）、page（漫画页）或 strip（条漫）
+	SceneID   int    `json:"sceneId"`
+	Character string `json:"character,omitempty"` // 角色设定图对应的角色
+	Page      int    `json:"page,omitempty"`      // 漫画页的页码
+	Segment   int    `json:"segment,omitempty"`   // 条漫的段号
+	Filename  string `json:"filename"`
+	URL       string `json:"url"`
+}
+
+// ErrorEvent 处理出错事件内容
+type ErrorEvent struct {
+	Stage       string     `json:"stage,omitempty"`
+	Error       string     `json:"error"`
+	Final       bool       `json:"final"` // 是否已不再重试
+	RetryCount  int        `json:"retryCount,omitempty"`
+	NextRetryAt *time.Time `json:"nextRetryAt,omitempty"`
+}
+
+// EventHub 进程内的任务事件分发中心
+// 处理器发布事件，SSE 连接订阅事件；每个任务保留最近的事件用于按 Last-Event-ID 补发
+type EventHub struct {
+	epoch string // 本实例标识，拼在事件 ID 中，避免重连到其他实例时误用序号
+
+	mu     sync.Mutex
+	topics map[string]*eventTopic
+}
+
+// eventTopic 单个任务的事件流
+type eventTopic struct {
+	seq         int64
+	buffer      []TaskEvent // 最近的事件，按序号递增
+	subscribers map[chan TaskEvent]struct{}
+	updatedAt   time.Time
+}
+
+// NewEventHub 创建事件分发中心
+func NewEventHub() *EventHub {
+	h := &EventHub{
+		epoch:  uuid.New().String()[:8],
+		topics: make(map[string]*eventTopic),
+	}
+	go h.janitor()
+	return h
+}
+
+// Publish 发布任务事件，并非阻塞地分发给所有订阅者
+func (h *EventHub) Publish(taskID, eventType string, data any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(taskID)
+	t.seq++
+	t.updatedAt = time.Now()
+	ev := TaskEvent{Seq: t.seq, Type: eventType, Data: data}
+
+	t.buffer = append(t.buffer, ev)
+	if len(t.buffer) > eventBufferSize {
+		t.buffer = t.buffer[len(t.buffer)-eventBufferSize:]
+	}
+
+	for ch := range t.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// 订阅者消费过慢，断开后由客户端带 Last-Event-ID 重连补发
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe 订阅任务事件
+// lastEventID 为客户端最后收到的事件 ID，能从缓存补发时返回需要补发的事件且 resumed 为 true；
+// 否则 resumed 为 false，调用方应先发送任务快照。seq 为订阅时的最新序号，可作为快照的事件 ID
+func (h *EventHub) Subscribe(taskID, lastEventID string) (replay []TaskEvent, resumed bool, seq int64, ch <-chan TaskEvent, unsubscribe func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(taskID)
+	if last, ok := h.parseEventID(lastEventID); ok && last <= t.seq {
+		if last == t.seq {
+			resumed = true
+		} else if len(t.buffer) > 0 && t.buffer[0].Seq <= last+1 {
+			resumed = true
+			for _, ev := range t.buffer {
+				if ev.Seq > last {
+					replay = append(replay, ev)
+				}
+			}
+		}
+	}
+
+	sub := make(chan TaskEvent, subscriberBufferSize)
+	t.subscribers[sub] = struct{}{}
+
+	unsubscribe = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := t.subscribers[sub]; ok {
+			delete(t.subscribers, sub)
+			close(sub)
+		}
+	}
+	return replay, resumed, t.seq, sub, unsubscribe
+}
+
+// Close 关闭任务事件流，断开所有订阅者（任务被删除时调用）
+func (h *EventHub) Close(taskID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.topics[taskID]
+	if !ok {
+		return
+	}
+	for ch := range t.subscribers {
+		close(ch)
+	}
+	delete(h.topics, taskID)
+}
+
+// EventID 生成事件 ID，格式为 <实例标识>-<序号>
+func (h *EventHub) EventID(seq int64) string {
+	return fmt.Sprintf("%s-%d", h.epoch, seq)
+}
+
+// parseEventID 解析本实例生成的事件 ID
+func (h *EventHub) parseEventID(id string) (int64, bool) {
+	epoch, seqStr, ok := strings.Cut(id, "-")
+	if !ok || epoch != h.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, false
+	}
+	return seq, true
+}
+
+// topic 获取或创建任务事件流，调用方需持有锁
+func (h *EventHub) topic(taskID string) *eventTopic {
+	t, ok := h.topics[taskID]
+	if !ok {
+		t = &eventTopic{
+			subscribers: make(map[chan TaskEvent]struct{}),
+			updatedAt:   time.Now(),
+		}
+		h.topics[taskID] = t
+	}
+	return t
+}
+
+// janitor 定期清理没有订阅者且长时间空闲的任务事件流
+func (h *EventHub) janitor() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.mu.Lock()
+		for taskID, t := range h.topics {
+			if len(t.subscribers) == 0 && time.Since(t.updatedAt) > topicIdleTimeout {
+				delete(h.topics, taskID)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+	"github.com/TxtAnime/txt-anime/pkgs/storyboard"
 	"github.com/google/uuid"
 )
 
@@ -18,13 +25,23 @@ import (
 type Handler struct {
 	db        *DB
 	outputDir string
+	config    *Config
+	processor *TaskProcessor
+	events    *EventHub
+	webhooks  *WebhookNotifier
+	store     ArtifactStore
 }
 
 // NewHandler 创建处理器
-func NewHandler(db *DB, outputDir string) *Handler {
+func NewHandler(db *DB, config *Config, processor *TaskProcessor, events *EventHub, webhooks *WebhookNotifier, store ArtifactStore) *Handler {
 	return &Handler{
 		db:        db,
-		outputDir: outputDir,
+		store:     store,
+		outputDir: config.Storage.OutputDir,
+		config:    config,
+		processor: processor,
+		events:    events,
+		webhooks:  webhooks,
 	}
 }
 
@@ -56,6 +73,23 @@ func (h *Handler) CreateTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "name and novel are required", http.StatusBadRequest)
 		return
 	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL, h.config.Webhook); err != nil {
+			http.Error(w, fmt.Sprintf("invalid callbackUrl: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	style, ok := storyboard.LookupStyle(req.Style)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown style %q, see GET /v1/styles", req.Style), http.StatusBadRequest)
+		return
+	}
+	req.Style = style.Name
+
+	if req.Split != nil {
+		h.createProject(w, req)
+		return
+	}
 
 	// 生成任务 ID
 	taskID := uuid.New().String()
@@ -65,11 +99,16 @@ func (h *Handler) CreateTask(w http.ResponseWriter, r *http.Request) {
 		ID:         taskID,
 		Name:       req.Name,
 		Novel:      req.Novel,
-		Status:     "doing",
-		StatusDesc: "",
+		Status:     TaskStatusQueued,
+		StatusDesc: "排队中",
 		Scenes:     make([]Scene, 0), // 确保初始化为空数组而不是nil
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
+
+		ReviewScript:   req.ReviewScript,
+		Style:          req.Style,
+		CallbackURL:    req.CallbackURL,
+		CallbackSecret: req.CallbackSecret,
 	}
 
 	if err := h.db.CreateTask(task); err != nil {
@@ -114,12 +153,7 @@ func (h *Handler) GetTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 返回响应
-	resp := GetTaskResponse{
-		ID:         task.ID,
-		Name:       task.Name,
-		Status:     task.Status,
-		StatusDesc: task.StatusDesc,
-	}
+	resp := newTaskResponse(task, h.config)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
@@ -157,7 +191,14 @@ func (h *Handler) GetArtifacts(w http.ResponseWriter, r *http.Request) {
 		scenes = make([]Scene, 0) // 确保返回空数组而不是null
 	}
 	resp := GetArtifactsResponse{
-		Scenes: scenes,
+		Scenes:     scenes,
+		References: h.characterReferences(task),
+		Pages:      h.pageArtifacts(task),
+		Strip:      h.stripArtifacts(task),
+	}
+	if !task.Stale.IsEmpty() {
+		stale := task.Stale
+		resp.Stale = &stale
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -170,8 +211,14 @@ func (h *Handler) GetTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 查询所有任务
-	tasks, err := h.db.GetTasks()
+	query, err := parseTaskListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 查询任务
+	tasks, total, err := h.db.GetTasks(query)
 	if err != nil {
 		log.Printf("查询任务列表失败: %v", err)
 		http.Error(w, "Failed to get tasks", http.StatusInternalServerError)
@@ -179,21 +226,103 @@ func (h *Handler) GetTasks(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 构建响应
-	var taskList []GetTaskResponse
+	taskList := make([]GetTaskResponse, 0, len(tasks))
 	for _, task := range tasks {
-		taskList = append(taskList, GetTaskResponse{
-			ID:         task.ID,
-			Name:       task.Name,
-			Status:     task.Status,
-			StatusDesc: task.StatusDesc,
-		})
+		taskList = append(taskList, newTaskResponse(&task, h.config))
 	}
 
-	resp := GetTasksResponse{Tasks: taskList}
+	resp := GetTasksResponse{
+		Tasks:  taskList,
+		Total:  total,
+		Offset: query.Offset,
+		Limit:  query.Limit,
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// 任务列表分页参数
+const (
+	defaultTaskListLimit = 50
+	maxTaskListLimit     = 200
+)
+
+// taskSortFields 任务列表可排序的字段（查询参数名 -> bson 字段名）
+var taskSortFields = map[string]string{
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+	"name":      "name",
+	"status":    "status",
+}
+
+// parseTaskListQuery 解析任务列表的查询参数
+// status=queued,running&createdAfter=RFC3339&createdBefore=RFC3339&q=关键字&sort=-createdAt&offset=0&limit=50
+func parseTaskListQuery(values url.Values) (TaskListQuery, error) {
+	query := TaskListQuery{
+		SortField: "created_at",
+		Limit:     defaultTaskListLimit,
+	}
+
+	if raw := values.Get("status"); raw != "" {
+		for _, status := range strings.Split(raw, ",") {
+			status = strings.TrimSpace(status)
+			switch status {
+			case TaskStatusQueued, TaskStatusRunning, TaskStatusAwaitingReview, TaskStatusFailed, TaskStatusDone, TaskStatusCancelled:
+				query.Statuses = append(query.Statuses, status)
+			default:
+				return query, fmt.Errorf("invalid status: %q", status)
+			}
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"createdAfter", &query.CreatedAfter},
+		{"createdBefore", &query.CreatedBefore},
+	} {
+		raw := values.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return query, fmt.Errorf("invalid %s: must be RFC3339", param.name)
+		}
+		*param.dst = &t
+	}
+
+	query.Name = strings.TrimSpace(values.Get("q"))
+
+	if raw := values.Get("sort"); raw != "" {
+		name := strings.TrimPrefix(raw, "-")
+		field, ok := taskSortFields[name]
+		if !ok {
+			return query, fmt.Errorf("invalid sort: %q", raw)
+		}
+		query.SortField = field
+		query.SortDesc = strings.HasPrefix(raw, "-")
+	}
+
+	if raw := values.Get("offset"); raw != "" {
+		offset, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || offset < 0 {
+			return query, fmt.Errorf("invalid offset: %q", raw)
+		}
+		query.Offset = offset
+	}
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit: %q", raw)
+		}
+		query.Limit = min(limit, maxTaskListLimit)
+	}
+
+	return query, nil
+}
+
 // DeleteTask 删除任务 DELETE /v1/tasks/:id
 func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -221,7 +350,20 @@ func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 删除 artifacts 文件
+	// 先取消任务，等待处理中的 worker 退出后再清理，避免删除目录时仍有写入
+	if err := h.cancelAndWait(task); err != nil {
+		log.Printf("取消任务失败: %v", err)
+		http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+		return
+	}
+
+	// 删除存储中的产物
+	if err := h.deleteArtifacts(r.Context(), taskID); err != nil {
+		log.Printf("⚠️  删除任务产物失败: %v (taskID=%s)", err, taskID)
+		// 继续删除数据库记录，即使产物删除失败
+	}
+
+	// 删除任务工作目录
 	taskDir := filepath.Join(h.outputDir, taskID)
 	if err := os.RemoveAll(taskDir); err != nil {
 		log.Printf("⚠️  删除任务目录失败: %v (taskID=%s, path=%s)", err, taskID, taskDir)
@@ -236,6 +378,7 @@ func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
 		return
 	}
+	h.events.Close(taskID)
 
 	// 返回成功响应
 	w.Header().Set("Content-Type", "application/json")
@@ -247,6 +390,438 @@ func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	log.Printf("✅ 任务删除成功: %s (%s)", taskID, task.Name)
 }
 
+// deleteArtifacts 删除存储中该任务的所有产物
+func (h *Handler) deleteArtifacts(ctx context.Context, taskID string) error {
+	keys, err := h.store.List(ctx, taskID+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := h.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	log.Printf("✅ 已删除 %d 个任务产物 (taskID=%s)", len(keys), taskID)
+	return nil
+}
+
+// CancelTask 取消任务 POST /v1/tasks/:id/cancel
+func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 提取任务 ID
+	taskID := extractTaskID(r.URL.Path, "/v1/tasks/")
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	cancelled, err := h.db.CancelTask(taskID)
+	if err != nil {
+		log.Printf("取消任务失败: %v", err)
+		http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+		return
+	}
+
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if !cancelled {
+		http.Error(w, fmt.Sprintf("Task is already %s", task.Status), http.StatusConflict)
+		return
+	}
+
+	// 中止本实例中正在进行的处理；其他实例会在下一次心跳时发现并中止
+	h.processor.CancelTask(taskID)
+
+	resp := newTaskResponse(task, h.config)
+	h.events.Publish(taskID, EventStatus, resp)
+	h.webhooks.Notify(task)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	log.Printf("✅ 任务已取消: %s (%s)", taskID, task.Name)
+}
+
+// sseKeepAliveInterval SSE 连接的保活注释发送间隔，避免被代理因空闲断开
+const sseKeepAliveInterval = 15 * time.Second
+
+// TaskEvents 以 Server-Sent Events 推送任务事件 GET /v1/tasks/:id/events
+// 客户端重连时通过 Last-Event-ID 请求头（或 lastEventId 查询参数）补发错过的事件；
+// 无法补发时先推送一次任务快照。任务进入终态后关闭连接
+func (h *Handler) TaskEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 提取任务 ID
+	taskID := extractTaskID(r.URL.Path, "/v1/tasks/")
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	// 先订阅再读取快照，避免两者之间的事件丢失
+	replay, resumed, seq, events, unsubscribe := h.events.Subscribe(taskID, lastEventID)
+	defer unsubscribe()
+
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // 关闭 nginx 缓冲
+	w.WriteHeader(http.StatusOK)
+
+	if !resumed {
+		snapshot := newTaskResponse(task, h.config)
+		if err := h.writeEvent(w, TaskEvent{Seq: seq, Type: EventSnapshot, Data: snapshot}); err != nil {
+			return
+		}
+	}
+	for _, ev := range replay {
+		if err := h.writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	if task.IsTerminal() {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-events:
+			if !ok {
+				// 订阅被断开（消费过慢或任务被删除），客户端可重连补发
+				return
+			}
+			if err := h.writeEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+			if resp, ok := ev.Data.(GetTaskResponse); ok && ev.Type == EventStatus && isTerminalStatus(resp.Status) {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent 按 SSE 格式写出一个事件
+func (h *Handler) writeEvent(w io.Writer, ev TaskEvent) error {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		log.Printf("序列化任务事件失败: %v", err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", h.events.EventID(ev.Seq), ev.Type, data)
+	return err
+}
+
+// maxScriptSize 提交的剧本 JSON 大小上限
+const maxScriptSize = 4 << 20
+
+// GetScript 获取任务剧本 GET /v1/tasks/:id/script
+// 返回 novel2script.Response 格式的剧本，包含 scene_id 和 line_id
+func (h *Handler) GetScript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 提取任务 ID
+	taskID := extractTaskID(r.URL.Path, "/v1/tasks/")
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	scriptData, err := h.taskScript(task)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "Script not generated yet", http.StatusNotFound)
+			return
+		}
+		log.Printf("读取剧本失败: %v", err)
+		http.Error(w, "Failed to read script", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scriptData)
+}
+
+// UpdateScript 替换任务剧本 PUT /v1/tasks/:id/script
+// 在 awaiting_review 或 done 状态下允许，提交的 JSON 需符合 novel2script.Response 格式
+// done 状态下与原剧本比较，只把输入发生变化的图片和音频标记为需要重新生成
+func (h *Handler) UpdateScript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 提取任务 ID
+	taskID := extractTaskID(r.URL.Path, "/v1/tasks/")
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxScriptSize+1))
+	if err != nil {
+		log.Printf("读取请求体失败: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if len(body) > maxScriptSize {
+		http.Error(w, "Script too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	submitted, err := novel2script.ParseResponse(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid script: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	scriptData, err := h.editScript(task, func(s *novel2script.Response) error {
+		*s = *submitted
+		return nil
+	})
+	if err != nil {
+		writeEditError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scriptData)
+
+	log.Printf("✅ 任务剧本已更新: %s (%d 个场景)", taskID, len(scriptData.Script))
+}
+
+// ApproveScript 审核通过剧本 POST /v1/tasks/:id/script/approve
+// 任务回到 queued 状态，继续生成图片和音频
+func (h *Handler) ApproveScript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 提取任务 ID
+	taskID := extractTaskID(r.URL.Path, "/v1/tasks/")
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	approved, err := h.db.ApproveScript(taskID)
+	if err != nil {
+		log.Printf("审核剧本失败: %v", err)
+		http.Error(w, "Failed to approve script", http.StatusInternalServerError)
+		return
+	}
+
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	if !approved {
+		http.Error(w, fmt.Sprintf("Task is not awaiting review, task is %s", task.Status), http.StatusConflict)
+		return
+	}
+
+	resp := newTaskResponse(task, h.config)
+	h.events.Publish(taskID, EventStatus, resp)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	log.Printf("✅ 任务剧本已审核通过: %s (%s)", taskID, task.Name)
+}
+
+// scriptPath 返回任务剧本文件路径
+func (h *Handler) scriptPath(taskID string) string {
+	return filepath.Join(h.outputDir, taskID, "script.json")
+}
+
+// cancelAndWait 取消未结束的任务，并等待处理该任务的 worker 退出
+// 本实例处理的任务直接等待其退出；其他实例处理的任务等待其释放租约或租约过期
+func (h *Handler) cancelAndWait(task *Task) error {
+	if !task.IsTerminal() {
+		cancelled, err := h.db.CancelTask(task.ID)
+		if err != nil {
+			return err
+		}
+		if cancelled {
+			task.Status = TaskStatusCancelled
+			task.StatusDesc = "已取消"
+			h.webhooks.Notify(task)
+		}
+	}
+
+	leaseDuration := h.config.Processor.leaseDuration()
+	if done := h.processor.CancelTask(task.ID); done != nil {
+		select {
+		case <-done:
+		case <-time.After(leaseDuration):
+			log.Printf("⚠️  等待任务 %s 停止超时", task.ID)
+		}
+	}
+
+	deadline := time.Now().Add(leaseDuration)
+	for time.Now().Before(deadline) {
+		current, err := h.db.GetTask(task.ID)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.LeaseOwner == "" || current.LeaseExpiresAt.Before(time.Now()) {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	log.Printf("⚠️  等待任务 %s 释放租约超时", task.ID)
+	return nil
+}
+
+// ListStyles 获取画风预设列表 GET /v1/styles
+func (h *Handler) ListStyles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := ListStylesResponse{Styles: make([]StyleInfo, 0), Default: storyboard.DefaultStyle}
+	for _, s := range storyboard.Styles() {
+		resp.Styles = append(resp.Styles, StyleInfo{Name: s.Name, Label: s.Label})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// taskStyle 返回任务的画风预设名，旧任务没有记录时为默认画风
+func taskStyle(task *Task) string {
+	if task.Style == "" {
+		return storyboard.DefaultStyle
+	}
+	return task.Style
+}
+
+// newTaskResponse 构建任务状态响应
+func newTaskResponse(task *Task, config *Config) GetTaskResponse {
+	status := task.Status
+	if status == taskStatusLegacyDoing {
+		status = TaskStatusQueued
+	}
+
+	resp := GetTaskResponse{
+		ID:         task.ID,
+		Name:       task.Name,
+		Status:     status,
+		StatusDesc: task.StatusDesc,
+		RetryCount: task.RetryCount,
+		MaxRetries: config.Processor.maxRetries(),
+		LastError:  task.LastError,
+		ErrorStage: task.ErrorStage,
+
+		ReviewScript:   task.ReviewScript,
+		ScriptApproved: task.ScriptApproved,
+		ScriptRevision: task.ScriptRevision,
+
+		Style: taskStyle(task),
+
+		UnresolvedNames: task.UnresolvedNames,
+
+		CallbackURL:       task.CallbackURL,
+		WebhookDeliveries: task.WebhookDeliveries,
+
+		ProjectID: task.ProjectID,
+		Sequence:  task.Sequence,
+		Chapters:  task.Chapters,
+	}
+	if task.Progress.Stage != "" {
+		progress := task.Progress
+		resp.Progress = &progress
+	}
+	if !task.Stale.IsEmpty() {
+		stale := task.Stale
+		resp.Stale = &stale
+	}
+	if status == TaskStatusQueued && task.NextRunAt.After(time.Now()) {
+		nextRunAt := task.NextRunAt
+		resp.NextRetryAt = &nextRunAt
+	}
+	return resp
+}
+
 // extractTaskID 从 URL 路径提取任务 ID
 // 例如: /v1/tasks/abc123 -> abc123
 // 例如: /v1/tasks/abc123/artifacts -> abc123
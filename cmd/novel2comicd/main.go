@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
@@ -31,6 +32,10 @@ func main() {
 	defer db.Close()
 	log.Println("✅ MongoDB 连接成功")
 
+	if err := db.EnsureIndexes(); err != nil {
+		log.Fatalf("初始化 MongoDB 索引失败: %v", err)
+	}
+
 	// 创建输出目录
 	if err := os.MkdirAll(config.Storage.OutputDir, 0o755); err != nil {
 		log.Fatalf("创建输出目录失败: %v", err)
@@ -41,21 +46,32 @@ func main() {
 	baseURL := ""
 	log.Printf("服务器 Base URL: %s (使用相对路径)", baseURL)
 
+	// 创建产物存储
+	store, err := NewArtifactStore(config, baseURL)
+	if err != nil {
+		log.Fatalf("创建产物存储失败: %v", err)
+	}
+	log.Printf("产物存储: %T", store)
+
 	// 启动任务处理器
 	log.Println("启动后台任务处理器")
-	processor := NewTaskProcessor(db, baseURL, config)
+	events := NewEventHub()
+	webhooks := NewWebhookNotifier(db, config.Webhook)
+	processor := NewTaskProcessor(db, store, config, events, webhooks)
 	processor.Start()
 	log.Println("✅ 后台任务处理器已启动")
 
 	// 创建 HTTP 处理器
-	handler := NewHandler(db, config.Storage.OutputDir)
+	handler := NewHandler(db, config, processor, events, webhooks, store)
+	handler.StartRegenerations()
+	log.Println("✅ 产物重新生成 worker 已启动")
 
 	// CORS 中间件
 	corsHandler := func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			// 设置 CORS 头
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 			// 处理预检请求
@@ -85,8 +101,39 @@ func main() {
 		} else if len(r.URL.Path) > len("/v1/tasks/") {
 			// GET /v1/tasks/:id - 获取任务
 			// GET /v1/tasks/:id/artifacts - 获取任务产物
+			// GET /v1/tasks/:id/events - 订阅任务事件 (SSE)
+			// GET/PUT /v1/tasks/:id/script - 获取/替换剧本
+			// POST /v1/tasks/:id/script/approve - 审核通过剧本
+			// /v1/tasks/:id/script/... - 编辑剧本中的场景、对话、角色和地点
+			// POST /v1/tasks/:id/cancel - 取消任务
+			// POST /v1/tasks/:id/pages - 重新排版漫画页
+			// POST /v1/tasks/:id/strip - 导出条漫
 			// DELETE /v1/tasks/:id - 删除任务
-			if r.URL.Path[len(r.URL.Path)-10:] == "/artifacts" {
+			// /v1/tasks/:id/scenes/:n/... - 场景产物重新生成与版本管理
+			// GET /v1/tasks/:id/regenerations[/:jobId] - 查询产物重新生成任务
+			if strings.HasSuffix(r.URL.Path, "/script/approve") {
+				handler.ApproveScript(w, r)
+			} else if strings.Contains(r.URL.Path, "/script/") {
+				handler.EditScript(w, r)
+			} else if strings.Contains(r.URL.Path, "/scenes/") {
+				handler.SceneArtifacts(w, r)
+			} else if strings.Contains(r.URL.Path, "/regenerations") {
+				handler.RegenerateJobs(w, r)
+			} else if strings.HasSuffix(r.URL.Path, "/cancel") {
+				handler.CancelTask(w, r)
+			} else if strings.HasSuffix(r.URL.Path, "/pages") {
+				handler.ComposePages(w, r)
+			} else if strings.HasSuffix(r.URL.Path, "/strip") {
+				handler.ExportStrip(w, r)
+			} else if strings.HasSuffix(r.URL.Path, "/script") {
+				if r.Method == http.MethodPut {
+					handler.UpdateScript(w, r)
+				} else {
+					handler.GetScript(w, r)
+				}
+			} else if strings.HasSuffix(r.URL.Path, "/events") {
+				handler.TaskEvents(w, r)
+			} else if r.URL.Path[len(r.URL.Path)-10:] == "/artifacts" {
 				handler.GetArtifacts(w, r)
 			} else if r.Method == http.MethodDelete {
 				handler.DeleteTask(w, r)
@@ -100,6 +147,16 @@ func main() {
 		}
 	}))
 
+	// 项目 API
+	// GET /v1/projects/:id - 获取项目及其子任务
+	http.HandleFunc("/v1/projects/", corsHandler(handler.GetProject))
+
+	// POST /v1/chapters - 解析小说章节
+	http.HandleFunc("/v1/chapters", corsHandler(handler.ParseChapters))
+
+	// GET /v1/styles - 获取画风预设列表
+	http.HandleFunc("/v1/styles", corsHandler(handler.ListStyles))
+
 	// 静态文件服务 - 提供生成的产物下载
 	http.Handle("/artifacts/", http.StripPrefix("/artifacts/", http.FileServer(http.Dir(config.Storage.OutputDir))))
 
@@ -116,8 +173,36 @@ func main() {
 	log.Println("  POST   /v1/tasks/              - 创建任务")
 	log.Println("  GET    /v1/tasks/              - 获取任务列表")
 	log.Println("  GET    /v1/tasks/:id           - 获取任务")
+	log.Println("  GET    /v1/tasks/:id/events    - 订阅任务事件 (SSE)")
+	log.Println("  GET    /v1/tasks/:id/script    - 获取剧本")
+	log.Println("  PUT    /v1/tasks/:id/script    - 替换剧本（待审核或已完成时）")
+	log.Println("  POST   /v1/tasks/:id/script/approve - 审核通过剧本")
+	log.Println("  POST   /v1/tasks/:id/script/scenes          - 插入场景")
+	log.Println("  PUT    /v1/tasks/:id/script/scenes/order    - 调整场景顺序")
+	log.Println("  PATCH  /v1/tasks/:id/script/scenes/:n       - 修改场景")
+	log.Println("  DELETE /v1/tasks/:id/script/scenes/:n       - 删除场景")
+	log.Println("  POST   /v1/tasks/:id/script/scenes/:n/dialogues       - 插入对话")
+	log.Println("  PUT    /v1/tasks/:id/script/scenes/:n/dialogues/order - 调整对话顺序")
+	log.Println("  PATCH  /v1/tasks/:id/script/scenes/:n/dialogues/:k    - 修改对话")
+	log.Println("  DELETE /v1/tasks/:id/script/scenes/:n/dialogues/:k    - 删除对话")
+	log.Println("  PUT    /v1/tasks/:id/script/characters/:name - 新增或修改角色")
+	log.Println("  DELETE /v1/tasks/:id/script/characters/:name - 删除角色")
+	log.Println("  PUT    /v1/tasks/:id/script/locations/:id    - 新增或修改地点")
+	log.Println("  DELETE /v1/tasks/:id/script/locations/:id    - 删除地点")
+	log.Println("  POST   /v1/tasks/:id/cancel    - 取消任务")
 	log.Println("  DELETE /v1/tasks/:id           - 删除任务")
 	log.Println("  GET    /v1/tasks/:id/artifacts - 获取任务产物")
+	log.Println("  POST   /v1/tasks/:id/pages     - 重新排版漫画页")
+	log.Println("  POST   /v1/tasks/:id/strip     - 导出条漫")
+	log.Println("  POST   /v1/tasks/:id/scenes/:n/image:regenerate - 重新生成场景图片")
+	log.Println("  GET    /v1/tasks/:id/scenes/:n/image/versions   - 场景图片版本列表")
+	log.Println("  POST   /v1/tasks/:id/scenes/:n/image:activate   - 切换场景图片版本")
+	log.Println("  POST   /v1/tasks/:id/scenes/:n/narration:regenerate       - 重新生成旁白音频")
+	log.Println("  POST   /v1/tasks/:id/scenes/:n/dialogues/:k/audio:regenerate - 重新生成对话音频")
+	log.Println("  GET    /v1/tasks/:id/regenerations/:jobId - 查询重新生成任务")
+	log.Println("  GET    /v1/projects/:id        - 获取项目及其子任务")
+	log.Println("  POST   /v1/chapters            - 解析小说章节")
+	log.Println("  GET    /v1/styles              - 获取画风预设列表")
 	log.Println("  GET    /artifacts/*            - 下载产物文件")
 	log.Println("  GET    /health                 - 健康检查")
 
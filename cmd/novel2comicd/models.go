@@ -1,21 +1,248 @@
 package main
 
-import "time"
+import (
+	"time"
+
+	"github.com/TxtAnime/txt-anime/pkgs/chapter"
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+)
+
+// 任务状态
+// queued -> running -> done；running 失败后回到 queued 等待重试，重试耗尽进入 failed。
+// 开启剧本审核的任务在剧本生成后进入 awaiting_review，审核通过后回到 queued 继续生成图片和音频。
+// 任意非终态都可以进入 cancelled。
+const (
+	TaskStatusQueued         = "queued"          // 排队中（包括等待重试）
+	TaskStatusRunning        = "running"         // 处理中
+	TaskStatusAwaitingReview = "awaiting_review" // 剧本已生成，等待人工审核
+	TaskStatusFailed         = "failed"          // 重试耗尽，处理失败（终态）
+	TaskStatusDone           = "done"            // 处理完成（终态）
+	TaskStatusCancelled      = "cancelled"       // 已取消（终态）
+
+	// taskStatusLegacyDoing 旧版本使用的处理中状态，按 queued 对待
+	taskStatusLegacyDoing = "doing"
+)
+
+// 任务处理阶段
+const (
+	StageScript     = "script"     // 剧本生成
+	StageReferences = "references" // 角色设定图生成
+	StageImages     = "images"     // 场景图片生成
+	StageAudios     = "audios"     // 音频生成
+	StagePages      = "pages"      // 漫画页排版
+	StageAssemble   = "assemble"   // 产物整理与入库
+)
 
 // Task 任务结构
 type Task struct {
 	ID         string    `bson:"_id" json:"id"`
 	Name       string    `bson:"name" json:"name"`
 	Novel      string    `bson:"novel" json:"novel"`
-	Status     string    `bson:"status" json:"status"`          // "doing" 或 "done"
+	Status     string    `bson:"status" json:"status"`          // 见 TaskStatus* 常量
 	StatusDesc string    `bson:"status_desc" json:"statusDesc"` // 状态描述
 	Scenes     []Scene   `bson:"scenes" json:"scenes"`
 	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+
+	RetryCount int       `bson:"retry_count" json:"retryCount"` // 已安排的重试次数
+	NextRunAt  time.Time `bson:"next_run_at" json:"nextRunAt"`  // 最早可执行时间（用于重试退避）
+	LastError  string    `bson:"last_error" json:"lastError"`   // 最近一次失败的错误信息
+	ErrorStage string    `bson:"error_stage" json:"errorStage"` // 最近一次失败所在阶段
+
+	Script         *novel2script.Response `bson:"script,omitempty" json:"-"`             // 剧本，与 script.json 保持一致
+	ScriptRevision int                    `bson:"script_revision" json:"scriptRevision"` // 剧本修改次数，用于并发编辑检测
+	Stale          TaskStale              `bson:"stale" json:"stale"`                    // 剧本修改后需要重新生成的产物
+
+	// UnresolvedNames 剧本中不在 characters 里的角色名，随剧本一起更新
+	UnresolvedNames []novel2script.UnresolvedName `bson:"unresolved_names,omitempty" json:"unresolvedNames,omitempty"`
+
+	Checkpoint TaskCheckpoint `bson:"checkpoint" json:"checkpoint"` // 各阶段已完成的产物
+	Progress   TaskProgress   `bson:"progress" json:"progress"`     // 结构化的处理进度
+
+	LeaseOwner     string    `bson:"lease_owner" json:"leaseOwner"`          // 持有处理租约的 worker ID
+	LeaseExpiresAt time.Time `bson:"lease_expires_at" json:"leaseExpiresAt"` // 租约过期时间，过期后其他 worker 可重新认领
+
+	ReviewScript   bool `bson:"review_script" json:"reviewScript"`     // 剧本生成后是否需要人工审核
+	ScriptApproved bool `bson:"script_approved" json:"scriptApproved"` // 剧本已审核通过
+
+	Style string `bson:"style,omitempty" json:"style,omitempty"` // 画风预设名，见 storyboard.Styles，旧任务为空时使用默认画风
+
+	CallbackURL       string            `bson:"callback_url,omitempty" json:"callbackUrl,omitempty"`             // 任务进入终态时回调的地址
+	CallbackSecret    string            `bson:"callback_secret,omitempty" json:"-"`                              // 回调签名密钥
+	WebhookDeliveries []WebhookDelivery `bson:"webhook_deliveries,omitempty" json:"webhookDeliveries,omitempty"` // 最近的回调投递记录
+	PendingWebhook    *PendingWebhook   `bson:"webhook_pending,omitempty" json:"-"`                              // 尚未投递完的回调
+
+	ArtifactVersions map[string]ArtifactVersions `bson:"artifact_versions,omitempty" json:"artifactVersions,omitempty"` // 重新生成过的产物的版本历史，key 见 artifactVersionKey
+	RegenerateJobs   []RegenerateJob             `bson:"regenerate_jobs,omitempty" json:"-"`                            // 最近的产物重新生成任务，按提交顺序排列
+
+	Pages []ComicPage    `bson:"pages,omitempty" json:"-"` // 最近一次排版的漫画页
+	Strip []StripSegment `bson:"strip,omitempty" json:"-"` // 最近一次导出的条漫
+
+	// 以下仅按章节拆分的子任务使用，见 Project
+	ProjectID    string        `bson:"project_id,omitempty" json:"projectId,omitempty"` // 所属项目
+	Sequence     int           `bson:"sequence,omitempty" json:"sequence,omitempty"`    // 在项目中的顺序，从 1 开始
+	Chapters     *TaskChapters `bson:"chapters,omitempty" json:"chapters,omitempty"`    // 包含的章节
+	PrevTaskID   string        `bson:"prev_task_id,omitempty" json:"-"`                 // 上一个子任务
+	WaitFor      string        `bson:"wait_for,omitempty" json:"-"`                     // 上一个子任务生成剧本（或结束）之前为其 ID，此时任务不会被认领
+	StorySummary string        `bson:"story_summary,omitempty" json:"-"`                // 截至本任务的剧情梗概，下一个子任务生成剧本时使用
+}
+
+// ComicPage 排好的一页漫画
+type ComicPage struct {
+	Page     int    `bson:"page"`      // 页码，从 1 开始
+	Filename string `bson:"filename"`  // 文件名，如 page_001.png
+	Layout   string `bson:"layout"`    // 使用的版式
+	SceneIDs []int  `bson:"scene_ids"` // 按分格顺序排列的场景
+}
+
+// StripSegment 导出的条漫中的一段
+type StripSegment struct {
+	Segment  int    `bson:"segment"`   // 段号，从 1 开始
+	Filename string `bson:"filename"`  // 文件名，如 strip_001.png
+	Width    int    `bson:"width"`     // 图片宽度
+	Height   int    `bson:"height"`    // 图片高度
+	SceneIDs []int  `bson:"scene_ids"` // 该段包含（或部分包含）的场景
+}
+
+// TaskChapters 子任务包含的章节
+type TaskChapters struct {
+	From   int      `bson:"from" json:"from"`     // 第一个章节的编号，从 1 开始
+	To     int      `bson:"to" json:"to"`         // 最后一个章节的编号（含）
+	Titles []string `bson:"titles" json:"titles"` // 各章节标题
+}
+
+// Project 按章节拆分的项目
+// 一次上传的小说按章节拆分为多个按顺序执行的子任务：每个子任务等上一个子任务的剧本生成后才开始，
+// 并沿用项目中已设计的角色、地点和上一个子任务的剧情梗概，保证角色和场景的形象在各章节之间一致
+type Project struct {
+	ID         string            `bson:"_id" json:"id"`
+	Name       string            `bson:"name" json:"name"`
+	TaskIDs    []string          `bson:"task_ids" json:"taskIds"`      // 子任务，按顺序排列
+	Chapters   []chapter.Chapter `bson:"chapters" json:"chapters"`     // 解析出的全部章节
+	Characters character.Bible   `bson:"characters" json:"characters"` // 各子任务共享的角色
+	Locations  location.Bible    `bson:"locations" json:"locations"`   // 各子任务共享的地点
+	Revision   int               `bson:"revision" json:"-"`            // 角色和地点修改次数，用于并发更新检测
+	CreatedAt  time.Time         `bson:"created_at" json:"createdAt"`
+	UpdatedAt  time.Time         `bson:"updated_at" json:"updatedAt"`
+}
+
+// TaskCheckpoint 各阶段已完成的产物
+// 产物文件名（script.json、character_%x.png、scene_%03d.png、scene_%03d_dialogue_%03d.mp3 等）即检查点的 key，
+// 任务重试或服务重启后从第一个缺失的产物继续执行
+type TaskCheckpoint struct {
+	Script     bool     `bson:"script" json:"script"`                             // script.json 已生成
+	References []string `bson:"references,omitempty" json:"references,omitempty"` // 已生成的角色设定图文件名
+	Images     []string `bson:"images" json:"images"`                             // 已生成的场景图片文件名
+	Audios     []string `bson:"audios" json:"audios"`                             // 已生成的音频文件名
+}
+
+// TaskStale 剧本修改后输入已变化、需要重新生成的产物
+// 只记录受影响的产物：修改场景描述只影响该场景的图片，修改台词只影响该句音频，
+// 修改角色描述影响该角色出场的所有场景图片。重新生成产物后从列表中移除。
+// 增删、重排场景或修改旁白、对话，以及重新生成或切换场景图片版本后，已有的漫画页和条漫标记为过期，重新排版或导出后清除
+type TaskStale struct {
+	Images []string `bson:"images" json:"images"`                   // 场景图片文件名，如 scene_001.png
+	Audios []string `bson:"audios" json:"audios"`                   // 音频文件名，如 scene_001_dialogue_002.mp3
+	Pages  bool     `bson:"pages,omitempty" json:"pages,omitempty"` // 漫画页需要重新排版
+	Strip  bool     `bson:"strip,omitempty" json:"strip,omitempty"` // 条漫需要重新导出
+}
+
+// IsEmpty 是否没有需要重新生成的产物
+func (s TaskStale) IsEmpty() bool {
+	return len(s.Images) == 0 && len(s.Audios) == 0 && !s.Pages && !s.Strip
+}
+
+// TaskProgress 结构化的任务进度
+type TaskProgress struct {
+	Stage  string                   `bson:"stage" json:"stage"`   // 当前阶段，见 Stage* 常量
+	Stages map[string]StageProgress `bson:"stages" json:"stages"` // 各阶段进度，key 为阶段名
+}
+
+// StageProgress 单个阶段的进度
+type StageProgress struct {
+	Completed  int        `bson:"completed" json:"completed"`                        // 已完成的条目数
+	Total      int        `bson:"total" json:"total"`                                // 总条目数
+	StartedAt  *time.Time `bson:"started_at,omitempty" json:"startedAt,omitempty"`   // 阶段开始时间
+	FinishedAt *time.Time `bson:"finished_at,omitempty" json:"finishedAt,omitempty"` // 阶段结束时间
+}
+
+// ArtifactVersions 单个产物的版本历史
+// 第一次重新生成时原始产物保存为 v1（如 scene_001.v1.png、scene_001_narration.v1.mp3），之后每次生成追加一个版本；
+// Active 为 GetArtifacts 中使用的版本
+type ArtifactVersions struct {
+	Latest   int               `bson:"latest" json:"latest"` // 已分配的最大版本号
+	Active   int               `bson:"active" json:"active"` // 当前使用的版本号
+	Versions []ArtifactVersion `bson:"versions" json:"versions"`
+}
+
+// ArtifactVersion 产物的一个版本
+type ArtifactVersion struct {
+	Version      int       `bson:"version" json:"version"`
+	Filename     string    `bson:"filename" json:"filename"`                             // 如 scene_001.v2.png
+	Prompt       string    `bson:"prompt,omitempty" json:"prompt,omitempty"`             // 替换默认提示词时使用的提示词
+	Instructions string    `bson:"instructions,omitempty" json:"instructions,omitempty"` // 追加到提示词的额外要求
+	Voice        string    `bson:"voice,omitempty" json:"voice,omitempty"`               // 音频使用的音色
+	Emotion      string    `bson:"emotion,omitempty" json:"emotion,omitempty"`           // 音频使用的情感
+	Text         string    `bson:"text,omitempty" json:"text,omitempty"`                 // 音频朗读的文本，切换版本时同步到 scenes
+	CreatedAt    time.Time `bson:"created_at" json:"createdAt"`
+}
+
+// 产物重新生成任务的状态
+const (
+	RegenerateJobQueued  = "queued"  // 排队中
+	RegenerateJobRunning = "running" // 生成中
+	RegenerateJobDone    = "done"    // 已生成新版本
+	RegenerateJobFailed  = "failed"  // 生成失败
+)
+
+// RegenerateJob 单个产物的重新生成任务
+// 请求时写入任务文档，由持有任务租约的 worker 按提交顺序在后台执行（见 Handler.runRegenerations）
+type RegenerateJob struct {
+	JobID   string `bson:"job_id" json:"jobId"`
+	Status  string `bson:"status" json:"status"` // 见 RegenerateJob* 常量
+	SceneID int    `bson:"scene_id" json:"sceneId"`
+	Target  string `bson:"target" json:"target"` // 产物名：image、narration 或 dialogues/:k/audio
+	Kind    string `bson:"kind" json:"kind"`     // image 或 audio
+
+	// 请求参数，见 RegenerateImageRequest、RegenerateAudioRequest
+	Prompt       string `bson:"prompt,omitempty" json:"prompt,omitempty"`
+	Instructions string `bson:"instructions,omitempty" json:"instructions,omitempty"`
+	Voice        string `bson:"voice,omitempty" json:"voice,omitempty"`
+	Emotion      string `bson:"emotion,omitempty" json:"emotion,omitempty"`
+	Text         string `bson:"text,omitempty" json:"text,omitempty"`
+
+	Version    int        `bson:"version,omitempty" json:"version,omitempty"` // 生成的版本号
+	URL        string     `bson:"url,omitempty" json:"url,omitempty"`         // 新版本的访问地址
+	Error      string     `bson:"error,omitempty" json:"error,omitempty"`     // 失败原因
+	CreatedAt  time.Time  `bson:"created_at" json:"createdAt"`
+	StartedAt  *time.Time `bson:"started_at,omitempty" json:"startedAt,omitempty"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty" json:"finishedAt,omitempty"`
+}
+
+// IsPending 重新生成任务是否尚未结束
+func (j RegenerateJob) IsPending() bool {
+	return j.Status == RegenerateJobQueued || j.Status == RegenerateJobRunning
+}
+
+// IsTerminal 任务是否已处于终态
+func (t *Task) IsTerminal() bool {
+	return isTerminalStatus(t.Status)
+}
+
+// isTerminalStatus 状态是否为终态
+func isTerminalStatus(status string) bool {
+	switch status {
+	case TaskStatusDone, TaskStatusFailed, TaskStatusCancelled:
+		return true
+	}
+	return false
 }
 
 // Scene 场景结构
 type Scene struct {
+	SceneID           int        `bson:"scene_id" json:"sceneId"` // 剧本中的 scene_id
 	ImageURL          string     `bson:"image_url" json:"imageURL"`
 	Narration         string     `bson:"narration" json:"narration"`
 	NarrationVoiceURL string     `bson:"narration_voice_url" json:"narrationVoiceURL"`
@@ -24,6 +251,7 @@ type Scene struct {
 
 // Dialogue 对话结构
 type Dialogue struct {
+	LineID    int    `bson:"line_id" json:"lineId"` // 剧本中的 line_id
 	Character string `bson:"character" json:"character"`
 	Line      string `bson:"line" json:"line"`
 	VoiceURL  string `bson:"voice_url" json:"voiceURL"`
@@ -33,27 +261,240 @@ type Dialogue struct {
 type CreateTaskRequest struct {
 	Name  string `json:"name"`
 	Novel string `json:"novel"`
+
+	ReviewScript bool `json:"reviewScript,omitempty"` // 可选，剧本生成后暂停，等待审核通过再生成图片和音频
+
+	Style string `json:"style,omitempty"` // 可选，画风预设名，见 GET /v1/styles，默认 storyboard.DefaultStyle
+
+	CallbackURL    string `json:"callbackUrl,omitempty"`    // 可选，任务完成、失败或取消时回调
+	CallbackSecret string `json:"callbackSecret,omitempty"` // 可选，用于回调请求的 HMAC 签名
+
+	Split *SplitOptions `json:"split,omitempty"` // 可选，按章节拆分为项目下的多个子任务
+}
+
+// SplitOptions 按章节拆分任务的选项，Ranges 和 ChaptersPerTask 都为空时每个章节一个子任务
+type SplitOptions struct {
+	ChaptersPerTask int             `json:"chaptersPerTask,omitempty"` // 每个子任务包含的章节数
+	Ranges          []chapter.Range `json:"ranges,omitempty"`          // 每个子任务的章节范围（从 1 开始，含两端），优先于 ChaptersPerTask
 }
 
 // CreateTaskResponse 创建任务响应
+// 按章节拆分时不返回 ID，返回项目 ID 和按顺序排列的子任务 ID
 type CreateTaskResponse struct {
-	ID string `json:"id"`
+	ID        string   `json:"id,omitempty"`
+	ProjectID string   `json:"projectId,omitempty"`
+	TaskIDs   []string `json:"taskIds,omitempty"`
+}
+
+// GetProjectResponse 获取项目响应
+type GetProjectResponse struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Chapters   []chapter.Chapter `json:"chapters"`
+	Characters character.Bible   `json:"characters"`
+	Locations  location.Bible    `json:"locations"`
+	Tasks      []GetTaskResponse `json:"tasks"` // 子任务，按顺序排列
+	CreatedAt  time.Time         `json:"createdAt"`
+}
+
+// ParseChaptersRequest 解析章节请求
+type ParseChaptersRequest struct {
+	Novel string `json:"novel"`
+}
+
+// ParseChaptersResponse 解析章节响应
+type ParseChaptersResponse struct {
+	Chapters []chapter.Chapter `json:"chapters"`
+}
+
+// StyleInfo 画风预设
+type StyleInfo struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}
+
+// ListStylesResponse 画风预设列表响应
+type ListStylesResponse struct {
+	Styles  []StyleInfo `json:"styles"`
+	Default string      `json:"default"`
 }
 
 // GetTaskResponse 获取任务响应
 type GetTaskResponse struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	Status     string `json:"status"`
-	StatusDesc string `json:"statusDesc"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	StatusDesc  string     `json:"statusDesc"`
+	RetryCount  int        `json:"retryCount"`
+	MaxRetries  int        `json:"maxRetries"`
+	NextRetryAt *time.Time `json:"nextRetryAt,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	ErrorStage  string     `json:"errorStage,omitempty"`
+
+	Progress *TaskProgress `json:"progress,omitempty"`
+
+	ReviewScript   bool `json:"reviewScript"`
+	ScriptApproved bool `json:"scriptApproved"`
+
+	Style string `json:"style"`
+
+	CallbackURL       string            `json:"callbackUrl,omitempty"`
+	WebhookDeliveries []WebhookDelivery `json:"webhookDeliveries,omitempty"`
+
+	ScriptRevision int        `json:"scriptRevision"`
+	Stale          *TaskStale `json:"stale,omitempty"` // 剧本修改后需要重新生成的产物，没有时不返回
+
+	UnresolvedNames []novel2script.UnresolvedName `json:"unresolvedNames,omitempty"` // 剧本中无法对应到角色设定的名字
+
+	ProjectID string        `json:"projectId,omitempty"`
+	Sequence  int           `json:"sequence,omitempty"`
+	Chapters  *TaskChapters `json:"chapters,omitempty"`
 }
 
 // GetArtifactsResponse 获取产物响应
 type GetArtifactsResponse struct {
-	Scenes []Scene `json:"scenes"`
+	Scenes     []Scene              `json:"scenes"`
+	References []CharacterReference `json:"references"`      // 角色设定图，按角色名排序
+	Pages      []PageArtifact       `json:"pages"`           // 漫画页，按页码排序
+	Strip      []StripArtifact      `json:"strip"`           // 条漫，按段号排序
+	Stale      *TaskStale           `json:"stale,omitempty"` // 剧本修改后需要重新生成的产物，没有时不返回
+}
+
+// CharacterReference 角色设定图，生成场景图片时作为该角色的参考图
+type CharacterReference struct {
+	Character string `json:"character"`
+	Filename  string `json:"filename"`
+	ImageURL  string `json:"imageURL"`
+}
+
+// PageArtifact 漫画页
+type PageArtifact struct {
+	Page     int    `json:"page"`
+	Filename string `json:"filename"`
+	Layout   string `json:"layout"`
+	SceneIDs []int  `json:"sceneIds"`
+	ImageURL string `json:"imageURL"`
+}
+
+// ComposePagesResponse 重新排版漫画页响应
+type ComposePagesResponse struct {
+	Pages []PageArtifact `json:"pages"`
+}
+
+// ExportStripRequest 导出条漫请求，字段为 0 时使用配置文件中的值
+type ExportStripRequest struct {
+	Width     int `json:"width"`
+	MaxHeight int `json:"maxHeight"`
+}
+
+// StripArtifact 条漫中的一段
+type StripArtifact struct {
+	Segment  int    `json:"segment"`
+	Filename string `json:"filename"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	SceneIDs []int  `json:"sceneIds"`
+	ImageURL string `json:"imageURL"`
+}
+
+// ExportStripResponse 导出条漫响应
+type ExportStripResponse struct {
+	Segments []StripArtifact `json:"segments"`
 }
 
 // GetTasksResponse 获取任务列表响应
 type GetTasksResponse struct {
-	Tasks []GetTaskResponse `json:"tasks"`
+	Tasks  []GetTaskResponse `json:"tasks"`
+	Total  int64             `json:"total"`  // 符合条件的任务总数
+	Offset int64             `json:"offset"` // 当前页起始位置
+	Limit  int64             `json:"limit"`  // 每页数量
+}
+
+// RegenerateImageRequest 重新生成场景图片请求，两个字段都为空时使用原提示词重新生成
+type RegenerateImageRequest struct {
+	Prompt       string `json:"prompt,omitempty"`       // 可选，完整替换默认提示词
+	Instructions string `json:"instructions,omitempty"` // 可选，追加到提示词末尾的额外要求
+}
+
+// RegenerateAudioRequest 重新生成旁白或对话音频请求，字段都为空时使用原音色和原文本重新生成
+type RegenerateAudioRequest struct {
+	Voice   string `json:"voice,omitempty"`   // 可选，音色（七牛云为音色名，腾讯云为音色 ID）
+	Emotion string `json:"emotion,omitempty"` // 可选，情感（仅腾讯云支持）
+	Text    string `json:"text,omitempty"`    // 可选，替换朗读的文本
 }
+
+// ActivateVersionRequest 切换产物版本请求
+type ActivateVersionRequest struct {
+	Version int `json:"version"`
+}
+
+// ArtifactVersionInfo 产物版本信息
+type ArtifactVersionInfo struct {
+	Version      int       `json:"version"`
+	Filename     string    `json:"filename"`
+	URL          string    `json:"url"`
+	Prompt       string    `json:"prompt,omitempty"`
+	Instructions string    `json:"instructions,omitempty"`
+	Voice        string    `json:"voice,omitempty"`
+	Emotion      string    `json:"emotion,omitempty"`
+	Text         string    `json:"text,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Active       bool      `json:"active"`
+}
+
+// GetArtifactVersionsResponse 产物版本列表响应
+// 没有重新生成过时 Versions 为空、Active 为 0，CurrentURL 为原始产物地址
+type GetArtifactVersionsResponse struct {
+	SceneID    int                   `json:"sceneId"`
+	Kind       string                `json:"kind"` // image 或 audio
+	Filename   string                `json:"filename"`
+	CurrentURL string                `json:"currentUrl"`
+	Active     int                   `json:"active"`
+	Versions   []ArtifactVersionInfo `json:"versions"`
+}
+
+// ListRegenerateJobsResponse 产物重新生成任务列表响应，按提交顺序排列
+type ListRegenerateJobsResponse struct {
+	Jobs []RegenerateJob `json:"jobs"`
+}
+
+// SceneEdit 修改场景的请求，只更新请求中出现的字段（字段名与剧本 JSON 一致）
+type SceneEdit struct {
+	Location          *string   `json:"location,omitempty"`
+	LocationID        *string   `json:"location_id,omitempty"`
+	TimeOfDay         *string   `json:"time_of_day,omitempty"`
+	CharactersPresent *[]string `json:"characters_present,omitempty"`
+	SceneDescription  *string   `json:"scene_description,omitempty"`
+	NarrationVO       *string   `json:"narration_vo,omitempty"`
+}
+
+// InsertSceneRequest 插入场景请求，scene_id 由服务端分配
+type InsertSceneRequest struct {
+	After *int `json:"after,omitempty"` // 插入到该 scene_id 之后，0 表示插入到开头，不传表示追加到末尾
+	novel2script.Scene
+}
+
+// DialogueEdit 修改对话的请求，只更新请求中出现的字段
+type DialogueEdit struct {
+	Character *string `json:"character,omitempty"`
+	Line      *string `json:"line,omitempty"`
+	Emotion   *string `json:"emotion,omitempty"`
+}
+
+// InsertDialogueRequest 插入对话请求，line_id 由服务端分配
+type InsertDialogueRequest struct {
+	After *int `json:"after,omitempty"` // 插入到该 line_id 之后，0 表示插入到开头，不传表示追加到末尾
+	novel2script.DialogueLine
+}
+
+// ReorderRequest 调整顺序请求，order 为全部 scene_id 或 line_id 的新顺序
+type ReorderRequest struct {
+	Order []int `json:"order"`
+}
+
+// CharacterEdit 新增或修改角色请求，字段与 character.Character 相同
+// 使用独立的类型以便按未知字段严格校验（character.Character 为兼容旧格式自定义了解析）
+type CharacterEdit character.Character
+
+// LocationEdit 新增或修改地点请求，字段与 location.Location 相同，地点 ID 以路径为准
+type LocationEdit location.Location
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/TxtAnime/txt-anime/pkgs/comicpage"
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+)
+
+// pagesDir 漫画页所在的子目录
+const pagesDir = "pages"
+
+// pageFilename 漫画页文件名，page 从 1 开始
+func pageFilename(page int) string {
+	return fmt.Sprintf("page_%03d.png", page)
+}
+
+// composePages 把场景图片按版式排成漫画页，绘制对话气泡和旁白框，写入存储并保存到任务
+// 每页只加载该页用到的场景图片；场景图片使用当前版本，缺失时该分格留空。progress 为 nil 时不汇报进度
+// 排版结果以 owner 的身份保存（见 DB.updateTaskAs）：处理任务时为 worker ID，已完成任务上重新排版时为空
+func (p *TaskProcessor) composePages(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, versions map[string]ArtifactVersions, progress *progressTracker) ([]ComicPage, error) {
+	font, err := p.comicFont()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := p.config.Comic.pageConfig(font)
+	if err != nil {
+		return nil, fmt.Errorf("加载版式失败: %w", err)
+	}
+
+	taskDir := filepath.Join(p.config.Storage.OutputDir, taskID)
+	localDir := filepath.Join(taskDir, pagesDir)
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建漫画页目录失败: %w", err)
+	}
+
+	scenes := scriptData.Script
+	layouts := comicpage.Paginate(len(scenes), cfg.Layouts)
+	if progress != nil {
+		progress.start(StagePages, len(layouts))
+	}
+
+	pages := make([]ComicPage, 0, len(layouts))
+	next := 0
+	for i, layout := range layouts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := min(next+len(layout.Panels), len(scenes))
+		page := ComicPage{Page: i + 1, Filename: pageFilename(i + 1), Layout: layout.Name}
+
+		panels := make([]comicpage.Panel, 0, end-next)
+		for _, scene := range scenes[next:end] {
+			panels = append(panels, scenePanel(taskDir, scene, versions))
+			page.SceneIDs = append(page.SceneIDs, scene.SceneID)
+		}
+		next = end
+
+		img, err := comicpage.ComposePage(panels, layout, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("排版第 %d 页失败: %w", page.Page, err)
+		}
+		data, err := comicpage.EncodePNG(img)
+		if err != nil {
+			return nil, fmt.Errorf("排版第 %d 页失败: %w", page.Page, err)
+		}
+		localPath := filepath.Join(localDir, page.Filename)
+		if err := writeFileAtomic(localPath, data); err != nil {
+			return nil, fmt.Errorf("保存第 %d 页失败: %w", page.Page, err)
+		}
+
+		key := artifactKey(taskID, pagesDir, page.Filename)
+		if err := p.store.Put(ctx, key, localPath); err != nil {
+			return nil, fmt.Errorf("写入产物 %s 失败: %w", key, err)
+		}
+		p.events.Publish(taskID, EventArtifact, ArtifactEvent{
+			Kind:     "page",
+			SceneID:  page.SceneIDs[0],
+			Page:     page.Page,
+			Filename: page.Filename,
+			URL:      p.store.URL(key),
+		})
+		pages = append(pages, page)
+		if progress != nil {
+			progress.advance(StagePages)
+		}
+		log.Printf("    ✅ 第 %d 页已排版 (%s，场景 %v)", page.Page, layout.Name, page.SceneIDs)
+	}
+
+	if err := p.db.SetTaskPages(taskID, owner, pages); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// scenePanel 构建场景对应的分格：当前版本的场景图片、旁白和对话
+func scenePanel(taskDir string, scene novel2script.Scene, versions map[string]ArtifactVersions) comicpage.Panel {
+	panel := comicpage.Panel{Narration: scene.NarrationVO}
+	for _, d := range scene.Dialogue {
+		panel.Dialogue = append(panel.Dialogue, comicpage.Line{Speaker: d.Character, Text: d.Line})
+	}
+
+	filename := sceneImageFilename(scene.SceneID)
+	if v, ok := versions[artifactVersionKey("images", filename)]; ok && v.Active > 0 {
+		filename = versionedFilename(filename, v.Active)
+	}
+	img, err := loadImage(filepath.Join(taskDir, "images", filename))
+	if err != nil {
+		log.Printf("    ⚠️  场景 %d 图片不可用，分格留空: %v", scene.SceneID, err)
+		return panel
+	}
+	panel.Image = img
+	return panel
+}
+
+// loadImage 读取并解码图片文件
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %w", err)
+	}
+	return img, nil
+}
+
+// ComposePages 按当前剧本和场景图片重新排版漫画页 POST /v1/tasks/:id/pages
+// 编辑剧本、重新生成或切换场景图片版本后调用，任务需已完成
+func (h *Handler) ComposePages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.config.Comic.Disable {
+		http.Error(w, "Comic pages are disabled", http.StatusConflict)
+		return
+	}
+
+	task, scriptData, ok := h.loadDoneTask(w, r)
+	if !ok {
+		return
+	}
+
+	pages, err := h.processor.composePages(r.Context(), task.ID, "", scriptData, task.ArtifactVersions, nil)
+	if err != nil {
+		log.Printf("排版漫画页失败: %v", err)
+		http.Error(w, "Failed to compose pages", http.StatusInternalServerError)
+		return
+	}
+	task.Pages = pages
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ComposePagesResponse{Pages: h.pageArtifacts(task)})
+
+	log.Printf("✅ 任务 %s 已重新排版 %d 页漫画", task.ID, len(pages))
+}
+
+// loadDoneTask 读取已完成的任务和剧本，出错时直接写入响应并返回 false
+func (h *Handler) loadDoneTask(w http.ResponseWriter, r *http.Request) (*Task, *novel2script.Response, bool) {
+	taskID := extractTaskID(r.URL.Path, "/v1/tasks/")
+	if taskID == "" {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if task.Status != TaskStatusDone {
+		http.Error(w, fmt.Sprintf("Task is not done, task is %s", task.Status), http.StatusConflict)
+		return nil, nil, false
+	}
+	scriptData, err := h.taskScript(task)
+	if err != nil {
+		log.Printf("读取剧本失败: %v", err)
+		http.Error(w, "Script not available", http.StatusNotFound)
+		return nil, nil, false
+	}
+	return task, scriptData, true
+}
+
+// pageArtifacts 返回任务最近一次排版的漫画页
+func (h *Handler) pageArtifacts(task *Task) []PageArtifact {
+	pages := make([]PageArtifact, 0, len(task.Pages))
+	for _, page := range task.Pages {
+		pages = append(pages, PageArtifact{
+			Page:     page.Page,
+			Filename: page.Filename,
+			Layout:   page.Layout,
+			SceneIDs: page.SceneIDs,
+			ImageURL: h.store.URL(artifactKey(task.ID, pagesDir, page.Filename)),
+		})
+	}
+	return pages
+}
@@ -1,270 +1,768 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/TxtAnime/txt-anime/pkgs/audiosync"
 	"github.com/TxtAnime/txt-anime/pkgs/audiosynctc"
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
 	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
 	"github.com/TxtAnime/txt-anime/pkgs/storyboard"
+	"github.com/google/uuid"
+	"golang.org/x/image/font/opentype"
 )
 
+// pollInterval 没有可执行任务时 worker 的等待间隔
+const pollInterval = 1 * time.Second
+
+// errAwaitingReview 剧本已生成，任务暂停等待人工审核
+var errAwaitingReview = errors.New("剧本待审核")
+
 // TaskProcessor 任务处理器
 type TaskProcessor struct {
-	db      *DB
-	baseURL string
-	config  *Config
+	db       *DB
+	store    ArtifactStore
+	config   *Config
+	workerID string // 本实例的 worker ID，每次认领任务时在其后追加随机后缀作为租约持有者（见 newLeaseOwner）
+	events   *EventHub
+	webhooks *WebhookNotifier
+
+	// comicFont 漫画页和条漫使用的字体，首次排版时加载
+	comicFont func() (*opentype.Font, error)
+
+	mu     sync.Mutex
+	active map[string][]*activeTask // 本实例正在处理的任务，处理任务和重新生成产物可能同时持有同一任务
+}
+
+// activeTask 正在处理的任务
+type activeTask struct {
+	cancel context.CancelFunc
+	done   chan struct{} // 任务处理退出后关闭
 }
 
 // NewTaskProcessor 创建任务处理器
-func NewTaskProcessor(db *DB, baseURL string, config *Config) *TaskProcessor {
+func NewTaskProcessor(db *DB, store ArtifactStore, config *Config, events *EventHub, webhooks *WebhookNotifier) *TaskProcessor {
+	workerID := config.Processor.WorkerID
+	if workerID == "" {
+		hostname, _ := os.Hostname()
+		workerID = fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8])
+	}
+
 	return &TaskProcessor{
-		db:      db,
-		baseURL: baseURL,
-		config:  config,
+		db:       db,
+		store:    store,
+		config:   config,
+		workerID: workerID,
+		events:   events,
+		webhooks: webhooks,
+		active:   make(map[string][]*activeTask),
+
+		comicFont: sync.OnceValues(config.Comic.font),
 	}
 }
 
-// Start 启动后台任务处理
+// newLeaseOwner 为一次认领生成租约持有者，格式为 worker ID 加随机后缀
+// 同一实例的各个 goroutine 持有不同的租约，租约过期后被本实例其他 goroutine 重新认领时，原 goroutine 的写入不再匹配
+func newLeaseOwner(workerID string) string {
+	return workerID + "/" + uuid.New().String()[:8]
+}
+
+// Start 启动后台任务处理，按配置启动多个 worker 并发处理任务，并启动回调投递 worker
 func (p *TaskProcessor) Start() {
-	ticker := time.NewTicker(1 * time.Second)
-	go func() {
-		for range ticker.C {
-			p.processTasks()
+	log.Printf("worker ID: %s，并发数: %d", p.workerID, p.config.Processor.Workers)
+	for i := 0; i < p.config.Processor.Workers; i++ {
+		go p.worker(i + 1)
+	}
+	p.webhooks.Start(p.workerID)
+}
+
+// worker 循环认领并处理任务，没有可执行的任务时等待一个轮询间隔
+func (p *TaskProcessor) worker(idx int) {
+	for {
+		task, err := p.db.ClaimTask(newLeaseOwner(p.workerID), p.config.Processor.leaseDuration())
+		if err != nil {
+			log.Printf("[worker %d] 认领任务失败: %v", idx, err)
+			time.Sleep(pollInterval)
+			continue
 		}
-	}()
+		if task == nil {
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		log.Printf("[worker %d] 认领任务: %s", idx, task.ID)
+		p.runTask(task)
+	}
 }
 
-// processTasks 处理所有 doing 状态的任务
-func (p *TaskProcessor) processTasks() {
-	tasks, err := p.db.GetDoingTasks()
-	if err != nil {
-		log.Printf("获取 doing 任务失败: %v", err)
+// runTask 执行一次任务尝试，并根据结果推进任务状态
+// 处理期间定期续期租约；租约丢失时中止处理，由新的持有者继续
+func (p *TaskProcessor) runTask(task *Task) {
+	log.Printf("开始处理任务: %s (%s)，第 %d 次尝试", task.ID, task.Name, task.RetryCount+1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := p.register(task.ID, cancel)
+	defer p.unregister(task.ID, done)
+	go p.heartbeat(ctx, cancel, task.ID, task.LeaseOwner)
+	p.publishStatus(task.ID)
+
+	progress := newProgressTracker(p.db, p.events, task.ID, task.LeaseOwner, cancel)
+	err := p.processTask(ctx, task, progress)
+	if errors.Is(err, errAwaitingReview) {
+		log.Printf("⏸️  任务 %s 剧本已生成，等待审核", task.ID)
+		p.publishStatus(task.ID)
+		return
+	}
+	if err == nil {
+		if current := p.publishStatus(task.ID); current != nil {
+			p.webhooks.Notify(current)
+		}
 		return
 	}
+	if ctx.Err() != nil || errors.Is(err, errLeaseLost) {
+		log.Printf("⚠️  任务 %s 已取消或租约已失效，停止处理: %v", task.ID, err)
+		if err := p.db.ReleaseLease(task.ID, task.LeaseOwner); err != nil {
+			log.Printf("  ⚠️  %v", err)
+		}
+		return
+	}
+
+	log.Printf("处理任务 %s 失败: %v", task.ID, err)
+	failed := p.handleFailure(task, err)
+	if current := p.publishStatus(task.ID); failed && current != nil {
+		p.webhooks.Notify(current)
+	}
+}
 
-	for _, task := range tasks {
-		log.Printf("开始处理任务: %s (%s)", task.ID, task.Name)
-		if err := p.processTask(&task); err != nil {
-			log.Printf("处理任务 %s 失败: %v", task.ID, err)
-			// 失败后继续处理下一个任务
+// heartbeat 定期续期任务租约，租约被他人持有时取消任务上下文
+// 数据库错误导致连续一个租约时长未能续期时同样取消，此时租约可能已过期并被重新认领
+func (p *TaskProcessor) heartbeat(ctx context.Context, cancel context.CancelFunc, taskID, owner string) {
+	lease := p.config.Processor.leaseDuration()
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := p.db.RenewLease(taskID, owner, lease)
+			if errors.Is(err, errLeaseLost) {
+				log.Printf("⚠️  任务 %s 的租约已失效（任务被取消或被其他 worker 认领），停止处理", taskID)
+				cancel()
+				return
+			}
+			if err == nil {
+				renewed = time.Now()
+				continue
+			}
+			log.Printf("  ⚠️  续期任务 %s 租约失败: %v", taskID, err)
+			if time.Since(renewed) >= lease {
+				log.Printf("⚠️  任务 %s 超过租约时长未能续期，停止处理", taskID)
+				cancel()
+				return
+			}
 		}
 	}
 }
 
-// processTask 处理单个任务
-func (p *TaskProcessor) processTask(task *Task) error {
-	// 1. 清理并创建输出目录
-	taskDir := filepath.Join(p.config.Storage.OutputDir, task.ID)
-	if err := os.RemoveAll(taskDir); err != nil {
-		return fmt.Errorf("清理输出目录失败: %w", err)
+// register 登记正在处理的任务，返回任务退出时关闭的 channel
+func (p *TaskProcessor) register(taskID string, cancel context.CancelFunc) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := &activeTask{cancel: cancel, done: make(chan struct{})}
+	p.active[taskID] = append(p.active[taskID], t)
+	return t.done
+}
+
+// unregister 移除任务登记并通知等待方
+func (p *TaskProcessor) unregister(taskID string, done chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tasks := slices.DeleteFunc(p.active[taskID], func(t *activeTask) bool { return t.done == done })
+	if len(tasks) == 0 {
+		delete(p.active, taskID)
+	} else {
+		p.active[taskID] = tasks
 	}
+	close(done)
+}
+
+// CancelTask 中止本实例中所有正在处理该任务的 goroutine
+// 返回的 channel 在它们全部退出后关闭；任务不在本实例处理时返回 nil
+func (p *TaskProcessor) CancelTask(taskID string) <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
+	// 复制一份，unregister 原地修改登记列表
+	tasks := slices.Clone(p.active[taskID])
+	if len(tasks) == 0 {
+		return nil
+	}
+	for _, t := range tasks {
+		t.cancel()
+	}
+	if len(tasks) == 1 {
+		return tasks[0].done
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, t := range tasks {
+			<-t.done
+		}
+		close(done)
+	}()
+	return done
+}
+
+// handleFailure 记录任务失败，未超过重试上限时安排退避重试，否则置为 failed
+// 返回任务是否已被置为 failed
+func (p *TaskProcessor) handleFailure(task *Task, err error) bool {
+	stage := StageAssemble
+	var se *stageError
+	if errors.As(err, &se) {
+		stage = se.stage
+	}
+
+	maxRetries := p.config.Processor.maxRetries()
+	if task.RetryCount >= maxRetries {
+		desc := fmt.Sprintf("处理失败（已重试 %d 次）", task.RetryCount)
+		if err := p.db.MarkTaskFailed(task.ID, task.LeaseOwner, err.Error(), stage, desc); err != nil {
+			log.Printf("  ⚠️  记录任务失败状态失败: %v", err)
+			return false
+		}
+		p.events.Publish(task.ID, EventError, ErrorEvent{Stage: stage, Error: err.Error(), Final: true, RetryCount: task.RetryCount})
+		log.Printf("❌ 任务 %s 重试次数已用尽，标记为失败", task.ID)
+		return true
+	}
+
+	retryCount := task.RetryCount + 1
+	backoff := p.config.Processor.retryBackoff(retryCount)
+	nextRunAt := time.Now().Add(backoff)
+	desc := fmt.Sprintf("等待第 %d/%d 次重试", retryCount, maxRetries)
+	if err := p.db.MarkTaskRetry(task.ID, task.LeaseOwner, retryCount, nextRunAt, err.Error(), stage, desc); err != nil {
+		log.Printf("  ⚠️  记录任务重试状态失败: %v", err)
+		return false
+	}
+	p.events.Publish(task.ID, EventError, ErrorEvent{Stage: stage, Error: err.Error(), RetryCount: retryCount, NextRetryAt: &nextRunAt})
+	log.Printf("  任务 %s 将在 %s 后进行第 %d 次重试", task.ID, backoff, retryCount)
+	return false
+}
+
+// stageError 带有失败阶段信息的错误
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
+
+// atStage 为错误标记所在阶段
+func atStage(stage string, err error) error {
+	return &stageError{stage: stage, err: err}
+}
+
+// processTask 处理单个任务，各阶段的进度通过 progress 记录
+func (p *TaskProcessor) processTask(ctx context.Context, task *Task, progress *progressTracker) error {
+	// 1. 创建输出目录（保留已有产物，用于断点续跑）
+	taskDir := filepath.Join(p.config.Storage.OutputDir, task.ID)
 	imagesDir := filepath.Join(taskDir, "images")
 	audiosDir := filepath.Join(taskDir, "audios")
 
 	if err := os.MkdirAll(imagesDir, 0o755); err != nil {
-		return fmt.Errorf("创建图片目录失败: %w", err)
+		return atStage(StageScript, fmt.Errorf("创建图片目录失败: %w", err))
 	}
 	if err := os.MkdirAll(audiosDir, 0o755); err != nil {
-		return fmt.Errorf("创建音频目录失败: %w", err)
+		return atStage(StageScript, fmt.Errorf("创建音频目录失败: %w", err))
 	}
 
 	// 2. novel2script: 生成剧本
-	log.Printf("  [1/3] 生成剧本...")
-	p.updateStatusDesc(task.ID, "剧本生成中...")
-	scriptData, err := p.generateScript(task.Novel)
+	log.Printf("  [1/5] 生成剧本...")
+	progress.start(StageScript, 1)
+	scriptFile := filepath.Join(taskDir, "script.json")
+	scriptData, err := p.prepareScript(ctx, task, scriptFile)
 	if err != nil {
-		return fmt.Errorf("生成剧本失败: %w", err)
+		return atStage(StageScript, err)
 	}
-	log.Printf("  生成了 %d 个场景, %d 个角色", len(scriptData.Script), len(scriptData.Characters))
+	progress.finish(StageScript)
 
-	// 保存剧本到文件用于调试
-	scriptFile := filepath.Join(taskDir, "script.json")
-	if err := saveScriptToFile(scriptData, scriptFile); err != nil {
-		log.Printf("  ⚠️  保存剧本文件失败: %v", err)
-	} else {
-		log.Printf("  已保存剧本到: %s", scriptFile)
+	// 需要审核剧本时在此暂停，审核通过后任务重新排队，从已有的 script.json 继续
+	if task.ReviewScript && !task.ScriptApproved {
+		if err := p.db.MarkTaskAwaitingReview(task.ID, task.LeaseOwner); err != nil {
+			return atStage(StageScript, err)
+		}
+		return errAwaitingReview
 	}
 
-	// 3. storyboard: 生成场景图片
-	log.Printf("  [2/3] 生成场景图片...")
-	p.updateStatusDesc(task.ID, "场景图片生成中...")
-	if err := p.generateImages(scriptData, imagesDir); err != nil {
-		return fmt.Errorf("生成图片失败: %w", err)
+	// 角色设定图和场景图片使用任务选择的画风
+	sbConfig := newStoryboardConfig(p.config, task)
+
+	// 3. storyboard: 生成角色设定图，之后作为场景图片的参考图
+	if !p.config.AI.DisableReferenceSheets {
+		log.Printf("  [2/5] 生成角色设定图...")
+		progress.start(StageReferences, len(referenceCharacters(scriptData.Characters)))
+		if err := p.generateReferences(ctx, task.ID, task.LeaseOwner, scriptData, sbConfig, progress); err != nil {
+			return atStage(StageReferences, fmt.Errorf("生成角色设定图失败: %w", err))
+		}
+		progress.finish(StageReferences)
 	}
 
-	// 4. audiosync: 生成音频
-	log.Printf("  [3/3] 生成音频...")
-	p.updateStatusDesc(task.ID, "场景对话生成中...")
-	if err := p.generateAudios(scriptData, audiosDir); err != nil {
-		return fmt.Errorf("生成音频失败: %w", err)
+	// 4. storyboard: 生成场景图片
+	log.Printf("  [3/5] 生成场景图片...")
+	progress.start(StageImages, len(scriptData.Script))
+	if err := p.generateImages(ctx, task.ID, task.LeaseOwner, scriptData, imagesDir, sbConfig, progress); err != nil {
+		return atStage(StageImages, fmt.Errorf("生成图片失败: %w", err))
 	}
+	progress.finish(StageImages)
 
-	// 5. 构建 scenes 数据（使用本地文件服务器 URL）
+	// 5. audiosync: 生成音频
+	if err := ctx.Err(); err != nil {
+		return atStage(StageAudios, err)
+	}
+	log.Printf("  [4/5] 生成音频...")
+	progress.start(StageAudios, countAudioItems(scriptData))
+	if err := p.generateAudios(ctx, task.ID, task.LeaseOwner, scriptData, audiosDir, progress); err != nil {
+		return atStage(StageAudios, fmt.Errorf("生成音频失败: %w", err))
+	}
+	progress.finish(StageAudios)
+
+	// 6. comicpage: 把场景图片排成漫画页
+	if !p.config.Comic.Disable {
+		log.Printf("  [5/5] 排版漫画页...")
+		if _, err := p.composePages(ctx, task.ID, task.LeaseOwner, scriptData, task.ArtifactVersions, progress); err != nil {
+			return atStage(StagePages, fmt.Errorf("排版漫画页失败: %w", err))
+		}
+		progress.finish(StagePages)
+	}
+
+	// 7. 构建 scenes 数据（使用本地文件服务器 URL）
 	log.Printf("  构建产物 URL...")
-	scenes, err := p.buildScenes(task.ID, scriptData, imagesDir, audiosDir)
+	progress.start(StageAssemble, 1)
+	scenes, err := buildScenes(p.store, task, scriptData, taskDir)
 	if err != nil {
-		return fmt.Errorf("构建产物失败: %w", err)
+		return atStage(StageAssemble, fmt.Errorf("构建产物失败: %w", err))
 	}
+	progress.finish(StageAssemble)
 
-	// 6. 更新任务状态
-	task.Scenes = scenes
-	task.Status = "done"
-	task.StatusDesc = "完成"
-	task.UpdatedAt = time.Now()
-
-	if err := p.db.UpdateTask(task); err != nil {
-		return fmt.Errorf("更新任务失败: %w", err)
+	// 8. 更新任务状态
+	if err := p.db.MarkTaskDone(task.ID, task.LeaseOwner, scenes); err != nil {
+		return atStage(StageAssemble, fmt.Errorf("更新任务失败: %w", err))
 	}
+	task.Scenes = scenes
+	task.Status = TaskStatusDone
 
 	log.Printf("✅ 任务 %s 处理完成", task.ID)
 	return nil
 }
 
+// prepareScript 准备任务剧本
+// 任务文档中已保存剧本（或存在 script.json 检查点）时直接复用，否则调用 novel2script 生成并保存
+func (p *TaskProcessor) prepareScript(ctx context.Context, task *Task, scriptFile string) (*novel2script.Response, error) {
+	if task.Script != nil && len(task.Script.Script) > 0 {
+		log.Printf("  复用任务剧本 (%d 个场景，版本 %d)", len(task.Script.Script), task.ScriptRevision)
+		scriptData := task.Script
+		scriptData.AssignLineIDs()
+		return scriptData, nil
+	}
+
+	if scriptData, err := loadScriptFromFile(scriptFile); err == nil {
+		log.Printf("  复用已有剧本: %s (%d 个场景)", scriptFile, len(scriptData.Script))
+		if err := p.setScriptCheckpoint(task.ID, task.LeaseOwner, scriptData); err != nil {
+			return nil, err
+		}
+		return scriptData, nil
+	}
+
+	scriptData, err := p.generateScript(ctx, task)
+	if err != nil {
+		return nil, fmt.Errorf("生成剧本失败: %w", err)
+	}
+	log.Printf("  生成了 %d 个场景, %d 个角色, %d 个地点", len(scriptData.Script), len(scriptData.Characters), len(scriptData.Locations))
+	if unresolved := scriptData.UnresolvedNames(); len(unresolved) > 0 {
+		log.Printf("  ⚠️  %d 个名字无法对应到角色设定，将使用角色名生成图片、默认音色生成语音", len(unresolved))
+	}
+
+	if err := saveScriptToFile(scriptData, scriptFile); err != nil {
+		return nil, fmt.Errorf("保存剧本文件失败: %w", err)
+	}
+	log.Printf("  已保存剧本到: %s", scriptFile)
+	if err := p.setScriptCheckpoint(task.ID, task.LeaseOwner, scriptData); err != nil {
+		return nil, err
+	}
+
+	return scriptData, nil
+}
+
 // generateScript 生成剧本
-func (p *TaskProcessor) generateScript(novelText string) (*novel2script.Response, error) {
+// 项目子任务在项目已有角色和上一个子任务剧情梗概的基础上生成，并把新设计的角色合并回项目
+func (p *TaskProcessor) generateScript(ctx context.Context, task *Task) (*novel2script.Response, error) {
 	cfg := novel2script.Config{
-		BaseURL: p.config.AI.BaseURL,
-		APIKey:  p.config.AI.APIKey,
-		Model:   p.config.AI.TextModel,
+		BaseURL:        p.config.AI.BaseURL,
+		APIKey:         p.config.AI.APIKey,
+		Model:          p.config.AI.TextModel,
+		MaxChunkTokens: p.config.AI.MaxChunkTokens,
+		ResponseFormat: p.config.AI.responseFormat(),
 	}
 
-	return novel2script.Process(novelText, cfg)
-}
+	if task.ProjectID == "" {
+		return novel2script.Process(ctx, task.Novel, cfg)
+	}
 
-// generateImages 生成场景图片
-func (p *TaskProcessor) generateImages(scriptData *novel2script.Response, imagesDir string) error {
-	cfg := storyboard.Config{
-		BaseURL:   p.config.AI.BaseURL,
-		APIKey:    p.config.AI.APIKey,
-		Model:     p.config.AI.ImageModel,
-		ImageSize: "1024x1024",
+	prior, err := p.projectPrior(task)
+	if err != nil {
+		return nil, err
 	}
+	log.Printf("  项目子任务 %d：沿用 %d 个已有角色、%d 个已有地点", task.Sequence, len(prior.Characters), len(prior.Locations))
 
-	for _, scene := range scriptData.Script {
-		log.Printf("    生成场景 %d 图片...", scene.SceneID)
+	scriptData, next, err := novel2script.ProcessContinuation(ctx, task.Novel, prior, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.db.MergeProjectSettings(task.ProjectID, next.Characters, next.Locations); err != nil {
+		return nil, err
+	}
+	if err := p.db.SetStorySummary(task.ID, task.LeaseOwner, next.Summary); err != nil {
+		return nil, err
+	}
+	return scriptData, nil
+}
+
+// projectPrior 返回项目子任务生成剧本时的前文信息：项目中已有的角色、地点和上一个子任务的剧情梗概
+func (p *TaskProcessor) projectPrior(task *Task) (novel2script.Prior, error) {
+	prior := novel2script.Prior{Characters: character.Bible{}, Locations: location.Bible{}}
 
-		// 转换为 storyboard.Scene 类型
-		sbScene := convertToStoryboardScene(scene, scriptData.Characters)
+	project, err := p.db.GetProject(task.ProjectID)
+	if err != nil {
+		return prior, err
+	}
+	if project != nil && project.Characters != nil {
+		prior.Characters = project.Characters
+	}
+	if project != nil && project.Locations != nil {
+		prior.Locations = project.Locations
+	}
 
-		imageData, err := storyboard.GenerateImage(sbScene, scriptData.Characters, cfg)
+	if task.PrevTaskID != "" {
+		prev, err := p.db.GetTask(task.PrevTaskID)
 		if err != nil {
-			return fmt.Errorf("生成场景 %d 图片失败: %w", scene.SceneID, err)
+			return prior, err
 		}
+		if prev != nil {
+			prior.Summary = prev.StorySummary
+		}
+	}
+	return prior, nil
+}
 
-		// 保存图片
-		filename := fmt.Sprintf("scene_%03d.png", scene.SceneID)
-		filepath := filepath.Join(imagesDir, filename)
-		if err := os.WriteFile(filepath, imageData, 0o644); err != nil {
-			return fmt.Errorf("保存场景 %d 图片失败: %w", scene.SceneID, err)
+// generateImages 并发生成场景图片，已存在的场景图片会被跳过
+// 并发数为 ai.image_concurrency，请求经过图片服务商共享的限流器，可重试的错误（429、5xx 等）退避后重试（见 storyboard.Limiter）；
+// 单个场景失败不影响其他场景，全部场景处理完后返回失败场景的汇总错误，任务重试时只生成缺失的场景图片；
+// 租约失效时停止生成并返回 errLeaseLost
+func (p *TaskProcessor) generateImages(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, imagesDir string, cfg storyboard.Config, progress *progressTracker) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scenes := scriptData.Script
+	errs := make([]error, len(scenes))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(p.config.AI.ImageConcurrency, len(scenes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := p.generateSceneImage(ctx, taskID, owner, scriptData, scenes[i], imagesDir, cfg)
+				if err != nil {
+					errs[i] = err
+					if errors.Is(err, errLeaseLost) {
+						cancel()
+						continue
+					}
+					if ctx.Err() == nil {
+						log.Printf("    ❌ %v", err)
+						p.events.Publish(taskID, EventError, ErrorEvent{Stage: StageImages, Error: err.Error()})
+					}
+					continue
+				}
+				progress.advance(StageImages)
+			}
+		}()
+	}
+
+feed:
+	for i := range scenes {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
 		}
+	}
+	close(jobs)
+	wg.Wait()
 
-		log.Printf("    ✅ 场景 %d 图片已保存: %s", scene.SceneID, filename)
+	for _, err := range errs {
+		if errors.Is(err, errLeaseLost) {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var failed []string
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d 个场景图片生成失败: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// generateSceneImage 生成单个场景的图片并写入存储，图片已存在时只重新写入存储
+// 出场角色有设定图时作为参考图发送，服务商不支持时只用提示词生成（见 storyboard.GenerateImageWithReferences）
+func (p *TaskProcessor) generateSceneImage(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, scene novel2script.Scene, imagesDir string, cfg storyboard.Config) error {
+	filename := sceneImageFilename(scene.SceneID)
+	imagePath := filepath.Join(imagesDir, filename)
+	if fileExists(imagePath) {
+		log.Printf("    ⏭️  场景 %d 图片已存在，跳过", scene.SceneID)
+		// 上次可能在写入存储前中断，重新写入一次（覆盖）
+		return p.saveArtifact(ctx, taskID, owner, "image", scene.SceneID, "images", filename, imagePath)
 	}
 
+	log.Printf("    生成场景 %d 图片...", scene.SceneID)
+
+	// 转换为 storyboard.Scene 类型
+	sbScene := convertToStoryboardScene(scene, scriptData.Characters)
+
+	prompt := storyboard.BuildPrompt(sbScene, scriptData.Characters, scriptData.Locations, cfg.Style)
+	refs, err := p.sceneReferences(ctx, taskID, owner, scriptData, sbScene, cfg)
+	if err != nil {
+		return err
+	}
+	imageData, err := storyboard.GenerateImageWithReferences(ctx, prompt, refs, cfg)
+	if err != nil {
+		return fmt.Errorf("生成场景 %d 图片失败: %w", scene.SceneID, err)
+	}
+
+	// 保存图片
+	if err := writeFileAtomic(imagePath, imageData); err != nil {
+		return fmt.Errorf("保存场景 %d 图片失败: %w", scene.SceneID, err)
+	}
+	if err := p.saveArtifact(ctx, taskID, owner, "image", scene.SceneID, "images", filename, imagePath); err != nil {
+		return err
+	}
+
+	log.Printf("    ✅ 场景 %d 图片已保存: %s", scene.SceneID, filename)
 	return nil
 }
 
-// generateAudios 生成音频
-func (p *TaskProcessor) generateAudios(scriptData *novel2script.Response, audiosDir string) error {
-	// 根据配置选择TTS提供商
-	ttsProvider := p.config.TTSProvider
-	if ttsProvider == "" {
-		ttsProvider = "qiniu" // 默认使用七牛云
+// newStoryboardConfig 构建任务的场景图片生成配置
+func newStoryboardConfig(config *Config, task *Task) storyboard.Config {
+	return storyboard.Config{
+		BaseURL:   config.AI.BaseURL,
+		APIKey:    config.AI.APIKey,
+		Model:     config.AI.ImageModel,
+		ImageSize: "1024x1024",
+		Style:     taskStyle(task),
+		Limiter:   config.AI.imageLimiter(),
 	}
+}
 
+// sceneImageFilename 场景图片文件名
+func sceneImageFilename(sceneID int) string {
+	return fmt.Sprintf("scene_%03d.png", sceneID)
+}
+
+// narrationAudioFilename 场景旁白音频文件名
+func narrationAudioFilename(sceneID int) string {
+	return fmt.Sprintf("scene_%03d_narration.mp3", sceneID)
+}
+
+// dialogueAudioFilename 对话音频文件名，lineID 为对话的 line_id
+func dialogueAudioFilename(sceneID, lineID int) string {
+	return fmt.Sprintf("scene_%03d_dialogue_%03d.mp3", sceneID, lineID)
+}
+
+// generateAudios 生成音频，已存在的音频文件会被跳过
+func (p *TaskProcessor) generateAudios(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, audiosDir string, progress *progressTracker) error {
+	// 根据配置选择TTS提供商
+	ttsProvider := p.config.ttsProvider()
 	switch ttsProvider {
 	case "tencent":
 		// 使用腾讯云TTS
-		return p.generateAudiosTencent(scriptData, audiosDir)
+		return p.generateAudiosTencent(ctx, taskID, owner, scriptData, audiosDir, progress)
 	case "qiniu":
 		// 使用七牛云TTS
-		return p.generateAudiosQiniu(scriptData, audiosDir)
+		return p.generateAudiosQiniu(ctx, taskID, owner, scriptData, audiosDir, progress)
 	default:
 		return fmt.Errorf("不支持的TTS提供商: %s", ttsProvider)
 	}
 }
 
 // generateAudiosQiniu 使用七牛云生成音频
-func (p *TaskProcessor) generateAudiosQiniu(scriptData *novel2script.Response, audiosDir string) error {
+func (p *TaskProcessor) generateAudiosQiniu(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, audiosDir string, progress *progressTracker) error {
 	// 转换数据结构为 audiosync 需要的格式
 	asScriptData := audiosync.ScriptData{
 		Script:     convertScenesForQiniu(scriptData.Script),
 		Characters: scriptData.Characters,
 	}
 
-	cfg := audiosync.Config{
-		BaseURL:  p.config.AI.BaseURL,
-		APIKey:   p.config.AI.APIKey,
-		LLMModel: p.config.AI.TextModel,
+	var saveErr error // 第一个写入存储失败的错误
+	cfg := newAudiosyncConfig(p.config)
+	cfg.SkipExisting = true
+	cfg.OnProgress = func(pr audiosync.Progress) {
+		if err := p.onAudioProgress(ctx, taskID, owner, progress, audiosDir, pr.SceneID, pr.Filename, pr.Done, pr.Total, pr.Err); err != nil && saveErr == nil {
+			saveErr = err
+		}
 	}
 
 	// 调用 audiosync 处理
-	return audiosync.Process(asScriptData, audiosDir, cfg)
+	if err := audiosync.Process(ctx, asScriptData, audiosDir, cfg); err != nil {
+		return err
+	}
+	return saveErr
 }
 
 // generateAudiosTencent 使用腾讯云生成音频
-func (p *TaskProcessor) generateAudiosTencent(scriptData *novel2script.Response, audiosDir string) error {
+func (p *TaskProcessor) generateAudiosTencent(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, audiosDir string, progress *progressTracker) error {
 	// 转换数据结构为 audiosynctc 需要的格式
 	tcScriptData := audiosynctc.ScriptData{
 		Script:     convertScenesForTencent(scriptData.Script),
 		Characters: scriptData.Characters,
 	}
 
-	cfg := audiosynctc.Config{
-		SecretID:  p.config.TencentTTS.SecretID,
-		SecretKey: p.config.TencentTTS.SecretKey,
-		Region:    p.config.TencentTTS.Region,
+	var saveErr error // 第一个写入存储失败的错误
+	cfg := newAudiosynctcConfig(p.config)
+	cfg.SkipExisting = true
+	cfg.OnProgress = func(pr audiosynctc.Progress) {
+		if err := p.onAudioProgress(ctx, taskID, owner, progress, audiosDir, pr.SceneID, pr.Filename, pr.Done, pr.Total, pr.Err); err != nil && saveErr == nil {
+			saveErr = err
+		}
+	}
+
+	// 调用 audiosynctc 处理
+	if err := audiosynctc.Process(ctx, tcScriptData, audiosDir, cfg); err != nil {
+		return err
+	}
+	return saveErr
+}
+
+// newAudiosyncConfig 构建七牛云音频生成配置
+func newAudiosyncConfig(config *Config) audiosync.Config {
+	return audiosync.Config{
+		BaseURL:  config.AI.BaseURL,
+		APIKey:   config.AI.APIKey,
+		LLMModel: config.AI.TextModel,
+
+		ResponseFormat: config.AI.responseFormat(),
+	}
+}
+
+// newAudiosynctcConfig 构建腾讯云音频生成配置
+func newAudiosynctcConfig(config *Config) audiosynctc.Config {
+	return audiosynctc.Config{
+		SecretID:  config.TencentTTS.SecretID,
+		SecretKey: config.TencentTTS.SecretKey,
+		Region:    config.TencentTTS.Region,
 		LLMConfig: audiosynctc.LLMConfig{
-			BaseURL: p.config.AI.BaseURL,
-			APIKey:  p.config.AI.APIKey,
-			Model:   p.config.AI.TextModel,
+			BaseURL: config.AI.BaseURL,
+			APIKey:  config.AI.APIKey,
+			Model:   config.AI.TextModel,
+
+			ResponseFormat: config.AI.responseFormat(),
 		},
 	}
+}
 
-	// 调用 audiosynctc 处理
-	return audiosynctc.Process(tcScriptData, audiosDir, cfg)
+// onAudioProgress 处理单条音频的进度回调：写入存储、记录检查点、通知订阅者并更新阶段进度
+// 返回写入存储的错误；音频生成本身的失败只记录，不中断任务
+func (p *TaskProcessor) onAudioProgress(ctx context.Context, taskID, owner string, progress *progressTracker, audiosDir string, sceneID int, filename string, done, total int, err error) error {
+	defer progress.set(StageAudios, done, total)
+
+	if err != nil {
+		log.Printf("    ❌ 音频 %s 生成失败: %v", filename, err)
+		p.events.Publish(taskID, EventError, ErrorEvent{
+			Stage: StageAudios,
+			Error: fmt.Sprintf("%s: %v", filename, err),
+		})
+		return nil
+	}
+	return p.saveArtifact(ctx, taskID, owner, "audio", sceneID, "audios", filename, filepath.Join(audiosDir, filename))
+}
+
+// countAudioItems 统计需要生成的音频条目数（旁白 + 对话）
+func countAudioItems(scriptData *novel2script.Response) int {
+	total := 0
+	for _, scene := range scriptData.Script {
+		if scene.NarrationVO != "" {
+			total++
+		}
+		total += len(scene.Dialogue)
+	}
+	return total
 }
 
-// buildScenes 构建 scenes 数据（使用本地文件服务器 URL）
-func (p *TaskProcessor) buildScenes(taskID string, scriptData *novel2script.Response, imagesDir, audiosDir string) ([]Scene, error) {
+// buildScenes 按剧本构建任务的 scenes 数据，产物 URL 由 store 给出，taskDir 为任务的本地输出目录
+// 只为已生成的产物给出 URL（产物在检查点中、本地文件存在或有重新生成的版本），
+// 未生成的产物（如编辑剧本新增的场景、生成失败的音频）URL 为空；重新生成过的产物使用当前版本的 URL
+func buildScenes(store ArtifactStore, task *Task, scriptData *novel2script.Response, taskDir string) ([]Scene, error) {
 	var scenes []Scene
 
+	generated := map[string][]string{"images": task.Checkpoint.Images, "audios": task.Checkpoint.Audios}
+	// artifactURL 返回产物当前版本的 URL，产物未生成时返回空
+	artifactURL := func(dir, filename string) string {
+		if v, ok := task.ArtifactVersions[artifactVersionKey(dir, filename)]; ok && v.Active > 0 {
+			return store.URL(artifactKey(task.ID, dir, versionedFilename(filename, v.Active)))
+		}
+		if !slices.Contains(generated[dir], filename) && !fileExists(filepath.Join(taskDir, dir, filename)) {
+			return ""
+		}
+		return store.URL(artifactKey(task.ID, dir, filename))
+	}
+
 	for _, scene := range scriptData.Script {
 		// 构建场景图片 URL
-		imageURL := fmt.Sprintf("%s/artifacts/%s/images/scene_%03d.png", p.baseURL, taskID, scene.SceneID)
+		imageURL := artifactURL("images", sceneImageFilename(scene.SceneID))
 
 		// 构建旁白音频 URL
 		narrationVoiceURL := ""
-		narrationPath := filepath.Join(audiosDir, fmt.Sprintf("scene_%03d_narration.mp3", scene.SceneID))
-		if _, err := os.Stat(narrationPath); err == nil {
-			narrationVoiceURL = fmt.Sprintf("%s/artifacts/%s/audios/scene_%03d_narration.mp3", p.baseURL, taskID, scene.SceneID)
+		if scene.NarrationVO != "" {
+			narrationVoiceURL = artifactURL("audios", narrationAudioFilename(scene.SceneID))
 		}
 
 		// 处理对话音频
 		var dialogues []Dialogue
-		for idx, dialogue := range scene.Dialogue {
-			voiceURL := ""
-			// 检查音频文件是否存在（可能没有对话的场景）
-			audioPath := filepath.Join(audiosDir, fmt.Sprintf("scene_%03d_dialogue_%03d.mp3", scene.SceneID, idx+1))
-			if _, err := os.Stat(audioPath); err == nil {
-				voiceURL = fmt.Sprintf("%s/artifacts/%s/audios/scene_%03d_dialogue_%03d.mp3", p.baseURL, taskID, scene.SceneID, idx+1)
-			}
-
+		for _, dialogue := range scene.Dialogue {
 			dialogues = append(dialogues, Dialogue{
+				LineID:    dialogue.LineID,
 				Character: dialogue.Character,
 				Line:      dialogue.Line,
-				VoiceURL:  voiceURL,
+				VoiceURL:  artifactURL("audios", dialogueAudioFilename(scene.SceneID, dialogue.LineID)),
 			})
 		}
 
 		scenes = append(scenes, Scene{
+			SceneID:           scene.SceneID,
 			ImageURL:          imageURL,
 			Narration:         scene.NarrationVO,
 			NarrationVoiceURL: narrationVoiceURL,
@@ -276,7 +774,7 @@ func (p *TaskProcessor) buildScenes(taskID string, scriptData *novel2script.Resp
 }
 
 // convertToStoryboardScene 转换场景格式为 storyboard 需要的格式
-func convertToStoryboardScene(scene novel2script.Scene, characters map[string]string) storyboard.Scene {
+func convertToStoryboardScene(scene novel2script.Scene, characters character.Bible) storyboard.Scene {
 	var dialogues []storyboard.DialogueLine
 	for _, d := range scene.Dialogue {
 		dialogues = append(dialogues, storyboard.DialogueLine{
@@ -288,6 +786,7 @@ func convertToStoryboardScene(scene novel2script.Scene, characters map[string]st
 	return storyboard.Scene{
 		SceneID:           scene.SceneID,
 		Location:          scene.Location,
+		LocationID:        scene.LocationID,
 		TimeOfDay:         scene.TimeOfDay,
 		CharactersPresent: scene.CharactersPresent,
 		SceneDescription:  scene.SceneDescription,
@@ -306,6 +805,7 @@ func convertScenesForQiniu(scenes []novel2script.Scene) []audiosync.Scene {
 				Character: d.Character,
 				Line:      d.Line,
 				Emotion:   d.Emotion, // 保留emotion字段（七牛云会忽略）
+				LineID:    d.LineID,
 			})
 		}
 
@@ -332,6 +832,7 @@ func convertScenesForTencent(scenes []novel2script.Scene) []audiosynctc.Scene {
 				Character: d.Character,
 				Line:      d.Line,
 				Emotion:   d.Emotion, // 保留emotion字段用于腾讯云TTS
+				LineID:    d.LineID,
 			})
 		}
 
@@ -348,18 +849,100 @@ func convertScenesForTencent(scenes []novel2script.Scene) []audiosynctc.Scene {
 	return result
 }
 
-// saveScriptToFile 保存剧本到文件（用于调试）
+// saveScriptToFile 保存剧本到文件（同时作为剧本阶段的检查点）
 func saveScriptToFile(scriptData *novel2script.Response, filepath string) error {
 	data, err := json.MarshalIndent(scriptData, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath, data, 0o644)
+	return writeFileAtomic(filepath, data)
 }
 
-// updateStatusDesc 更新任务的状态描述
-func (p *TaskProcessor) updateStatusDesc(taskID, statusDesc string) {
-	if err := p.db.UpdateTaskStatusDesc(taskID, statusDesc); err != nil {
-		log.Printf("  ⚠️  更新状态描述失败: %v", err)
+// loadScriptFromFile 从文件读取剧本
+func loadScriptFromFile(filepath string) (*novel2script.Response, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
 	}
+	var scriptData novel2script.Response
+	if err := json.Unmarshal(data, &scriptData); err != nil {
+		return nil, fmt.Errorf("解析剧本文件失败: %w", err)
+	}
+	if len(scriptData.Script) == 0 {
+		return nil, fmt.Errorf("剧本文件中没有场景")
+	}
+	scriptData.AssignLineIDs()
+	return &scriptData, nil
+}
+
+// fileExists 判断文件是否存在且非空
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Size() > 0
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免中断时留下不完整的产物被当作检查点
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// setScriptCheckpoint 将剧本保存到任务文档并记录剧本检查点
+// 只在租约失效时返回错误（errLeaseLost），其他写入失败只记录日志
+func (p *TaskProcessor) setScriptCheckpoint(taskID, owner string, scriptData *novel2script.Response) error {
+	err := p.db.SetScriptCheckpoint(taskID, owner, scriptData)
+	if errors.Is(err, errLeaseLost) {
+		return err
+	}
+	if err != nil {
+		log.Printf("  ⚠️  更新剧本检查点失败: %v", err)
+	}
+	return nil
+}
+
+// addCheckpointItem 以 owner 的身份记录单个产物检查点（见 DB.updateTaskAs）
+// 只在租约失效时返回错误（errLeaseLost），其他写入失败只记录日志
+func (p *TaskProcessor) addCheckpointItem(taskID, owner, field, filename string) error {
+	err := p.db.AddCheckpointItem(taskID, owner, field, filename)
+	if errors.Is(err, errLeaseLost) {
+		return err
+	}
+	if err != nil {
+		log.Printf("  ⚠️  更新检查点失败 (%s): %v", filename, err)
+	}
+	return nil
+}
+
+// publishStatus 读取任务最新状态并通知订阅者，返回读取到的任务
+func (p *TaskProcessor) publishStatus(taskID string) *Task {
+	task, err := p.db.GetTask(taskID)
+	if err != nil || task == nil {
+		log.Printf("  ⚠️  读取任务 %s 状态失败: %v", taskID, err)
+		return nil
+	}
+	p.events.Publish(taskID, EventStatus, newTaskResponse(task, p.config))
+	return task
+}
+
+// saveArtifact 将本地生成的产物写入存储，记录检查点并通知订阅者
+// dir 为产物所在子目录（images 或 audios），同时也是检查点字段名；租约已失效时返回 errLeaseLost，不再通知订阅者
+func (p *TaskProcessor) saveArtifact(ctx context.Context, taskID, owner, kind string, sceneID int, dir, filename, localPath string) error {
+	key := artifactKey(taskID, dir, filename)
+	if err := p.store.Put(ctx, key, localPath); err != nil {
+		return fmt.Errorf("写入产物 %s 失败: %w", key, err)
+	}
+	if err := p.addCheckpointItem(taskID, owner, dir, filename); err != nil {
+		return err
+	}
+
+	p.events.Publish(taskID, EventArtifact, ArtifactEvent{
+		Kind:     kind,
+		SceneID:  sceneID,
+		Filename: filename,
+		URL:      p.store.URL(key),
+	})
+	return nil
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelTaskReachesAllGoroutines(t *testing.T) {
+	p := &TaskProcessor{active: make(map[string][]*activeTask)}
+
+	// 处理任务和重新生成产物同时持有同一任务
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	done1 := p.register("task1", cancel1)
+	done2 := p.register("task1", cancel2)
+
+	done := p.CancelTask("task1")
+	if done == nil {
+		t.Fatal("CancelTask returned nil for an active task")
+	}
+	if ctx1.Err() == nil || ctx2.Err() == nil {
+		t.Fatal("CancelTask should cancel every registered goroutine")
+	}
+
+	p.unregister("task1", done1)
+	select {
+	case <-done:
+		t.Fatal("done closed before every goroutine exited")
+	case <-time.After(10 * time.Millisecond):
+	}
+	p.unregister("task1", done2)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("done not closed after every goroutine exited")
+	}
+
+	if p.CancelTask("task1") != nil {
+		t.Error("CancelTask should return nil once the task is no longer active")
+	}
+}
+
+func TestNewLeaseOwnerIsPerClaim(t *testing.T) {
+	a, b := newLeaseOwner("host-1"), newLeaseOwner("host-1")
+	if a == b {
+		t.Errorf("lease owners should differ per claim: %s", a)
+	}
+	if !strings.HasPrefix(a, "host-1/") {
+		t.Errorf("lease owner %s should start with the worker ID", a)
+	}
+}
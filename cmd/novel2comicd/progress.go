@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// stageLabels 各阶段的状态描述
+var stageLabels = map[string]string{
+	StageScript:     "剧本生成中",
+	StageReferences: "角色设定图生成中",
+	StageImages:     "场景图片生成中",
+	StageAudios:     "场景对话生成中",
+	StagePages:      "漫画页排版中",
+	StageAssemble:   "产物整理中",
+}
+
+// progressTracker 记录单个任务各阶段的结构化进度，并同步写入数据库
+// 只在 owner 仍持有任务租约且任务仍为 running 时写入；租约失效后不再写入和通知，并取消任务上下文
+type progressTracker struct {
+	db     *DB
+	events *EventHub
+	taskID string
+	owner  string
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	stages map[string]StageProgress
+	lost   bool // 租约已失效
+}
+
+// newProgressTracker 创建进度记录器，cancel 在租约失效时调用
+func newProgressTracker(db *DB, events *EventHub, taskID, owner string, cancel context.CancelFunc) *progressTracker {
+	return &progressTracker{
+		db:     db,
+		events: events,
+		taskID: taskID,
+		owner:  owner,
+		cancel: cancel,
+		stages: make(map[string]StageProgress),
+	}
+}
+
+// start 开始一个阶段
+func (t *progressTracker) start(stage string, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.stages[stage] = StageProgress{Total: total, StartedAt: &now}
+	t.save(stage)
+}
+
+// set 更新阶段的完成数和总数
+func (t *progressTracker) set(stage string, completed, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sp := t.stages[stage]
+	sp.Completed = completed
+	sp.Total = total
+	t.stages[stage] = sp
+	t.save(stage)
+}
+
+// advance 阶段完成数加一
+func (t *progressTracker) advance(stage string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sp := t.stages[stage]
+	sp.Completed++
+	t.stages[stage] = sp
+	t.save(stage)
+}
+
+// finish 结束一个阶段
+func (t *progressTracker) finish(stage string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	sp := t.stages[stage]
+	sp.Completed = sp.Total
+	sp.FinishedAt = &now
+	t.stages[stage] = sp
+	t.save(stage)
+}
+
+// save 将阶段进度写入数据库并通知订阅者，调用方需持有锁
+func (t *progressTracker) save(stage string) {
+	if t.lost {
+		return
+	}
+	sp := t.stages[stage]
+	desc := stageLabels[stage]
+	if sp.FinishedAt == nil && sp.Total > 1 {
+		desc = fmt.Sprintf("%s (%d/%d)", desc, sp.Completed, sp.Total)
+	}
+	err := t.db.UpdateStageProgress(t.taskID, t.owner, stage, sp, desc)
+	if errors.Is(err, errLeaseLost) {
+		log.Printf("⚠️  任务 %s 的租约已失效（任务被取消或被其他 worker 认领），停止更新进度", t.taskID)
+		t.lost = true
+		t.cancel()
+		return
+	}
+	if err != nil {
+		log.Printf("  ⚠️  更新任务进度失败: %v", err)
+	}
+	t.events.Publish(t.taskID, EventProgress, ProgressEvent{Stage: stage, Progress: sp, StatusDesc: desc})
+}
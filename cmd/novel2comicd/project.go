@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TxtAnime/txt-anime/pkgs/chapter"
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
+	"github.com/google/uuid"
+)
+
+// maxProjectTasks 一个项目最多拆分的子任务数
+const maxProjectTasks = 500
+
+// createProject 按章节拆分小说，创建项目和按顺序执行的子任务
+// 第一个子任务立即排队，之后的子任务等上一个子任务生成剧本后才会被认领
+func (h *Handler) createProject(w http.ResponseWriter, req CreateTaskRequest) {
+	chapters := chapter.Parse(req.Novel)
+	if len(chapters) == 0 {
+		http.Error(w, "novel is empty", http.StatusBadRequest)
+		return
+	}
+
+	ranges := req.Split.Ranges
+	if len(ranges) == 0 {
+		ranges = chapter.Group(len(chapters), req.Split.ChaptersPerTask)
+	}
+	if err := chapter.ValidateRanges(ranges, len(chapters)); err != nil {
+		http.Error(w, fmt.Sprintf("invalid split: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(ranges) > maxProjectTasks {
+		http.Error(w, fmt.Sprintf("invalid split: too many tasks (%d > %d)", len(ranges), maxProjectTasks), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	project := &Project{
+		ID:         uuid.New().String(),
+		Name:       req.Name,
+		Chapters:   chapters,
+		Characters: character.Bible{},
+		Locations:  location.Bible{},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	tasks := make([]*Task, 0, len(ranges))
+	prevID := ""
+	for i, r := range ranges {
+		titles := make([]string, 0, r.To-r.From+1)
+		for _, ch := range chapters[r.From-1 : r.To] {
+			titles = append(titles, ch.Title)
+		}
+
+		task := &Task{
+			ID:         uuid.New().String(),
+			Name:       projectTaskName(req.Name, i+1, titles),
+			Novel:      chapter.Slice(req.Novel, chapters, r),
+			Status:     TaskStatusQueued,
+			StatusDesc: "排队中",
+			Scenes:     make([]Scene, 0),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+
+			ReviewScript:   req.ReviewScript,
+			Style:          req.Style,
+			CallbackURL:    req.CallbackURL,
+			CallbackSecret: req.CallbackSecret,
+
+			ProjectID:  project.ID,
+			Sequence:   i + 1,
+			Chapters:   &TaskChapters{From: r.From, To: r.To, Titles: titles},
+			PrevTaskID: prevID,
+			WaitFor:    prevID,
+		}
+		if prevID != "" {
+			task.StatusDesc = "等待上一部分剧本生成"
+		}
+		tasks = append(tasks, task)
+		project.TaskIDs = append(project.TaskIDs, task.ID)
+		prevID = task.ID
+	}
+
+	if err := h.db.CreateProject(project, tasks); err != nil {
+		log.Printf("创建项目失败: %v", err)
+		http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		return
+	}
+
+	resp := CreateTaskResponse{ProjectID: project.ID, TaskIDs: project.TaskIDs}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	log.Printf("创建项目成功: %s (%s)，%d 个章节拆分为 %d 个子任务", project.ID, req.Name, len(chapters), len(tasks))
+}
+
+// projectTaskName 子任务名称，如 "流浪地球 - 第一章 刹车时代 ~ 第三章 逃逸"
+func projectTaskName(name string, sequence int, titles []string) string {
+	first, last := titles[0], titles[len(titles)-1]
+	switch {
+	case first == "" && last == "":
+		return fmt.Sprintf("%s - 第 %d 部分", name, sequence)
+	case first == "":
+		return fmt.Sprintf("%s - 开篇 ~ %s", name, last)
+	case len(titles) == 1:
+		return fmt.Sprintf("%s - %s", name, first)
+	default:
+		return fmt.Sprintf("%s - %s ~ %s", name, first, last)
+	}
+}
+
+// GetProject 获取项目及其子任务 GET /v1/projects/:id
+func (h *Handler) GetProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := extractTaskID(r.URL.Path, "/v1/projects/")
+	if projectID == "" {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	project, err := h.db.GetProject(projectID)
+	if err != nil {
+		log.Printf("查询项目失败: %v", err)
+		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		return
+	}
+	if project == nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	tasks, err := h.db.GetProjectTasks(projectID)
+	if err != nil {
+		log.Printf("查询子任务失败: %v", err)
+		http.Error(w, "Failed to get project tasks", http.StatusInternalServerError)
+		return
+	}
+
+	resp := GetProjectResponse{
+		ID:         project.ID,
+		Name:       project.Name,
+		Chapters:   project.Chapters,
+		Characters: project.Characters,
+		Locations:  project.Locations,
+		Tasks:      make([]GetTaskResponse, 0, len(tasks)),
+		CreatedAt:  project.CreatedAt,
+	}
+	if resp.Characters == nil {
+		resp.Characters = character.Bible{}
+	}
+	if resp.Locations == nil {
+		resp.Locations = location.Bible{}
+	}
+	for i := range tasks {
+		resp.Tasks = append(resp.Tasks, newTaskResponse(&tasks[i], h.config))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ParseChapters 解析小说章节 POST /v1/chapters
+// 用于创建任务前预览章节拆分结果，不创建任何任务
+func (h *Handler) ParseChapters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("读取请求体失败: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var req ParseChaptersRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Novel == "" {
+		http.Error(w, "novel is required", http.StatusBadRequest)
+		return
+	}
+
+	chapters := chapter.Parse(req.Novel)
+	if chapters == nil {
+		chapters = make([]chapter.Chapter, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ParseChaptersResponse{Chapters: chapters})
+}
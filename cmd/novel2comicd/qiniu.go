@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/qiniu/go-sdk/v7/auth/qbox"
 	"github.com/qiniu/go-sdk/v7/storage"
@@ -34,25 +35,29 @@ func NewQiniuUploader(cfg QiniuConfig) *QiniuUploader {
 	}
 }
 
-// UploadFile 上传文件到七牛云
+// UploadFile 上传文件到七牛云，key 已存在时覆盖
 // localPath: 本地文件路径
 // key: 七牛云对象存储的 key（如 "tasks/{taskID}/scene_1.png"）
 // 返回: 公开访问 URL
-func (u *QiniuUploader) UploadFile(localPath, key string) (string, error) {
+func (u *QiniuUploader) UploadFile(ctx context.Context, localPath, key string) (string, error) {
+	// scope 指定 key 时为覆盖上传，重试时重复上传同一产物不会报错
 	putPolicy := storage.PutPolicy{
-		Scope: u.bucket,
+		Scope: fmt.Sprintf("%s:%s", u.bucket, key),
 	}
 	upToken := putPolicy.UploadToken(u.mac)
 
 	formUploader := storage.NewFormUploader(u.cfg)
 	ret := storage.PutRet{}
 
-	err := formUploader.PutFile(context.Background(), &ret, upToken, key, localPath, nil)
+	err := formUploader.PutFile(ctx, &ret, upToken, key, localPath, nil)
 	if err != nil {
 		return "", fmt.Errorf("上传文件失败: %w", err)
 	}
 
-	// 构建公开访问 URL
-	url := fmt.Sprintf("%s/%s", u.domain, key)
-	return url, nil
+	return u.URL(key), nil
+}
+
+// URL 构建公开访问 URL
+func (u *QiniuUploader) URL(key string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(u.domain, "/"), key)
 }
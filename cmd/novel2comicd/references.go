@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+	"github.com/TxtAnime/txt-anime/pkgs/storyboard"
+)
+
+// referencesDir 角色设定图所在的子目录，同时也是检查点字段名
+const referencesDir = "references"
+
+// referenceFilename 角色设定图文件名
+// 由角色名和视觉描述的哈希组成：编辑剧本修改了角色的视觉设定后文件名随之变化，旧的设定图不再使用
+func referenceFilename(name string, c character.Character) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + c.Visual()))
+	return fmt.Sprintf("character_%x.png", sum[:6])
+}
+
+// referenceCharacters 返回需要生成设定图的角色（有视觉描述的角色），按角色名排序
+func referenceCharacters(characters character.Bible) []string {
+	names := make([]string, 0, len(characters))
+	for name, c := range characters {
+		if c.Visual() != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// generateReferences 为剧本中的每个角色生成设定图，已存在的设定图会被跳过
+func (p *TaskProcessor) generateReferences(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, cfg storyboard.Config, progress *progressTracker) error {
+	for _, name := range referenceCharacters(scriptData.Characters) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := p.referenceSheet(ctx, taskID, owner, name, scriptData.Characters[name], cfg, true); err != nil {
+			return fmt.Errorf("生成角色 %s 设定图失败: %w", name, err)
+		}
+		progress.advance(StageReferences)
+	}
+	return nil
+}
+
+// referenceSheet 返回角色设定图，本地不存在时生成并写入存储，检查点以 owner 的身份记录（见 DB.updateTaskAs）
+// resave 为 true 时已存在的设定图也重新写入存储一次（上次可能在写入存储前中断）
+func (p *TaskProcessor) referenceSheet(ctx context.Context, taskID, owner, name string, c character.Character, cfg storyboard.Config, resave bool) ([]byte, error) {
+	refsDir := filepath.Join(p.config.Storage.OutputDir, taskID, referencesDir)
+	filename := referenceFilename(name, c)
+	sheetPath := filepath.Join(refsDir, filename)
+
+	if data, err := os.ReadFile(sheetPath); err == nil {
+		if resave {
+			log.Printf("    ⏭️  角色 %s 设定图已存在，跳过", name)
+			if err := p.saveReference(ctx, taskID, owner, name, filename, sheetPath); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+
+	log.Printf("    生成角色 %s 设定图...", name)
+	data, err := storyboard.GenerateReferenceSheet(ctx, name, c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(refsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建设定图目录失败: %w", err)
+	}
+	if err := writeFileAtomic(sheetPath, data); err != nil {
+		return nil, fmt.Errorf("保存角色 %s 设定图失败: %w", name, err)
+	}
+	if err := p.saveReference(ctx, taskID, owner, name, filename, sheetPath); err != nil {
+		return nil, err
+	}
+	log.Printf("    ✅ 角色 %s 设定图已保存: %s", name, filename)
+	return data, nil
+}
+
+// saveReference 将角色设定图写入存储，记录检查点并通知订阅者
+func (p *TaskProcessor) saveReference(ctx context.Context, taskID, owner, name, filename, localPath string) error {
+	key := artifactKey(taskID, referencesDir, filename)
+	if err := p.store.Put(ctx, key, localPath); err != nil {
+		return fmt.Errorf("写入产物 %s 失败: %w", key, err)
+	}
+	if err := p.addCheckpointItem(taskID, owner, referencesDir, filename); err != nil {
+		return err
+	}
+
+	p.events.Publish(taskID, EventArtifact, ArtifactEvent{
+		Kind:      "reference",
+		Character: name,
+		Filename:  filename,
+		URL:       p.store.URL(key),
+	})
+	return nil
+}
+
+// sceneReferences 返回场景出场角色的设定图，按出场顺序最多 storyboard.MaxReferenceImages 张
+// 缺少设定图的角色（如编辑剧本修改了视觉设定）先生成设定图，生成失败时跳过该角色；未开启角色设定图时返回 nil。
+// 只在租约失效（见 DB.updateTaskAs）时返回错误
+func (p *TaskProcessor) sceneReferences(ctx context.Context, taskID, owner string, scriptData *novel2script.Response, scene storyboard.Scene, cfg storyboard.Config) ([]storyboard.Reference, error) {
+	if p.config.AI.DisableReferenceSheets {
+		return nil, nil
+	}
+
+	var refs []storyboard.Reference
+	for _, name := range scene.CharactersPresent {
+		c, ok := scriptData.Characters[name]
+		if !ok || c.Visual() == "" || slices.ContainsFunc(refs, func(r storyboard.Reference) bool { return r.Name == name }) {
+			continue
+		}
+		data, err := p.referenceSheet(ctx, taskID, owner, name, c, cfg, false)
+		if errors.Is(err, errLeaseLost) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("    ⚠️  角色 %s 设定图不可用，场景 %d 不使用该角色的参考图: %v", name, scene.SceneID, err)
+			continue
+		}
+		refs = append(refs, storyboard.Reference{Name: name, Image: data})
+		if len(refs) == storyboard.MaxReferenceImages {
+			break
+		}
+	}
+	return refs, nil
+}
+
+// characterReferences 返回任务中当前角色设定对应、且已生成的角色设定图
+func (h *Handler) characterReferences(task *Task) []CharacterReference {
+	refs := make([]CharacterReference, 0)
+	if task.Script == nil {
+		return refs
+	}
+	for _, name := range referenceCharacters(task.Script.Characters) {
+		filename := referenceFilename(name, task.Script.Characters[name])
+		if !slices.Contains(task.Checkpoint.References, filename) {
+			continue
+		}
+		refs = append(refs, CharacterReference{
+			Character: name,
+			Filename:  filename,
+			ImageURL:  h.store.URL(artifactKey(task.ID, referencesDir, filename)),
+		})
+	}
+	return refs
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TxtAnime/txt-anime/pkgs/storyboard"
+)
+
+const (
+	maxPendingRegenerations = 20 // 单个任务排队中和生成中的重新生成任务上限
+	maxRegenerateJobs       = 50 // 单个任务保留的重新生成任务记录数
+)
+
+// StartRegenerations 启动产物重新生成 worker，并发数与任务处理 worker 相同
+// 重新生成请求写入任务文档后立即返回，由 worker 认领任务租约后按提交顺序执行（见 DB.ClaimRegeneration）
+func (h *Handler) StartRegenerations() {
+	for i := 0; i < h.config.Processor.Workers; i++ {
+		go h.regenerateWorker(i + 1)
+	}
+}
+
+// regenerateWorker 循环认领有待执行重新生成任务的已完成任务，没有时等待一个轮询间隔
+func (h *Handler) regenerateWorker(idx int) {
+	p := h.processor
+	for {
+		task, err := h.db.ClaimRegeneration(newLeaseOwner(p.workerID), p.config.Processor.leaseDuration())
+		if err != nil {
+			log.Printf("[重新生成 worker %d] 认领任务失败: %v", idx, err)
+			time.Sleep(pollInterval)
+			continue
+		}
+		if task == nil {
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		log.Printf("[重新生成 worker %d] 认领任务: %s", idx, task.ID)
+		h.runRegenerations(task)
+	}
+}
+
+// runRegenerations 持有任务租约，按提交顺序执行任务中所有待执行的重新生成任务
+// 处理期间定期续期租约；租约丢失（任务被删除或重新处理）时中止，未完成的重新生成任务由新的持有者重新执行
+// 任何情况下退出时都释放租约，删除任务时无需等待租约过期
+func (h *Handler) runRegenerations(task *Task) {
+	p := h.processor
+	taskID, owner := task.ID, task.LeaseOwner
+	ctx, cancel := context.WithCancel(context.Background())
+	done := p.register(taskID, cancel)
+	defer p.unregister(taskID, done)
+	// 先于 unregister 释放租约，等待任务停止的一方随后即可删除任务；租约已被他人持有时不做修改
+	defer func() {
+		cancel()
+		if err := h.db.ReleaseLease(taskID, owner); err != nil {
+			log.Printf("  ⚠️  %v", err)
+		}
+	}()
+	go p.heartbeat(ctx, cancel, taskID, owner)
+
+	for {
+		job, ok := nextRegenerateJob(task)
+		if !ok {
+			return
+		}
+		if err := h.runRegenerateJob(ctx, task, owner, job); err != nil {
+			log.Printf("⚠️  任务 %s 已删除、租约已失效或写入失败，停止重新生成: %v", taskID, err)
+			return
+		}
+
+		current, err := h.db.GetTask(taskID)
+		if err != nil || current == nil {
+			log.Printf("  ⚠️  重新查询任务 %s 失败: %v", taskID, err)
+			return
+		}
+		task = current
+	}
+}
+
+// nextRegenerateJob 返回最早提交的未结束的重新生成任务
+func nextRegenerateJob(task *Task) (RegenerateJob, bool) {
+	for _, job := range task.RegenerateJobs {
+		if job.IsPending() {
+			return job, true
+		}
+	}
+	return RegenerateJob{}, false
+}
+
+// runRegenerateJob 执行单个重新生成任务并记录结果
+// 生成失败记录在任务中并返回 nil；只有租约丢失或上下文取消时返回错误，此时任务保持 running，由新的持有者重新执行
+func (h *Handler) runRegenerateJob(ctx context.Context, task *Task, owner string, job RegenerateJob) error {
+	now := time.Now()
+	job.Status = RegenerateJobRunning
+	job.StartedAt = &now
+	job.Error = ""
+	if err := h.db.SetRegenerateJob(task.ID, owner, job); err != nil {
+		return err
+	}
+	h.events.Publish(task.ID, EventRegenerate, job)
+
+	record, url, err := h.regenerate(ctx, task, owner, job)
+	if err != nil && (ctx.Err() != nil || errors.Is(err, errLeaseLost)) {
+		return err
+	}
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	if err != nil {
+		log.Printf("❌ 重新生成任务 %s 失败: %v", job.JobID, err)
+		job.Status = RegenerateJobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = RegenerateJobDone
+		job.Version = record.Version
+		job.URL = url
+	}
+	if err := h.db.SetRegenerateJob(task.ID, owner, job); err != nil {
+		return err
+	}
+	h.events.Publish(task.ID, EventRegenerate, job)
+	return nil
+}
+
+// regenerate 按重新生成任务生成产物的新版本并设为当前版本，返回新版本及其访问地址
+// 场景和产物按执行时的剧本定位，提交后被编辑删除的场景或对话返回错误
+func (h *Handler) regenerate(ctx context.Context, task *Task, owner string, job RegenerateJob) (ArtifactVersion, string, error) {
+	var record ArtifactVersion
+	scriptData, err := h.taskScript(task)
+	if err != nil {
+		return record, "", fmt.Errorf("读取剧本失败: %w", err)
+	}
+	idx := scriptData.FindScene(job.SceneID)
+	if idx < 0 || idx >= len(task.Scenes) {
+		return record, "", fmt.Errorf("场景 %d 不存在", job.SceneID)
+	}
+	a, ok := resolveSceneArtifact(job.Target, task, scriptData, idx)
+	if !ok {
+		return record, "", fmt.Errorf("场景 %d 中不存在产物 %s", job.SceneID, job.Target)
+	}
+
+	// 准备新版本的生成参数
+	var generate func(ctx context.Context) ([]byte, error)
+	switch a.kind {
+	case "image":
+		record.Prompt = job.Prompt
+		record.Instructions = job.Instructions
+
+		sbScene := convertToStoryboardScene(scriptData.Script[idx], scriptData.Characters)
+		cfg := newStoryboardConfig(h.config, task)
+		prompt := job.Prompt
+		if prompt == "" {
+			prompt = storyboard.BuildPrompt(sbScene, scriptData.Characters, scriptData.Locations, cfg.Style)
+		}
+		if job.Instructions != "" {
+			prompt += " Additional requirements: " + job.Instructions
+		}
+		generate = func(ctx context.Context) ([]byte, error) {
+			refs, err := h.processor.sceneReferences(ctx, task.ID, owner, scriptData, sbScene, cfg)
+			if err != nil {
+				return nil, err
+			}
+			return storyboard.GenerateImageWithReferences(ctx, prompt, refs, cfg)
+		}
+
+	case "audio":
+		voice, err := h.lineVoice(task.ID, a.character, job.Voice)
+		if err != nil {
+			return record, "", err
+		}
+		record.Voice = voice
+		record.Emotion = a.emotion
+		if job.Emotion != "" {
+			record.Emotion = job.Emotion
+		}
+		record.Text = a.text
+		if strings.TrimSpace(job.Text) != "" {
+			record.Text = job.Text
+		}
+		generate = func(ctx context.Context) ([]byte, error) {
+			return generateLineAudio(ctx, h.config, record.Text, record.Voice, record.Emotion)
+		}
+	}
+
+	version, err := h.db.NextArtifactVersion(task.ID, a.key())
+	if err != nil {
+		return record, "", fmt.Errorf("分配版本号失败: %w", err)
+	}
+	if version == 1 {
+		saved, err := h.saveOriginalVersion(ctx, task, owner, a)
+		if err != nil {
+			return record, "", err
+		}
+		if saved {
+			// 第一次重新生成，原始产物已保存为 v1
+			if version, err = h.db.NextArtifactVersion(task.ID, a.key()); err != nil {
+				return record, "", fmt.Errorf("分配版本号失败: %w", err)
+			}
+		}
+	}
+
+	log.Printf("重新生成产物: %s %s (v%d)", task.ID, a.filename, version)
+	data, err := generate(ctx)
+	if err != nil {
+		return record, "", fmt.Errorf("生成 %s 失败: %w", a.kind, err)
+	}
+
+	record.Version = version
+	record.Filename = versionedFilename(a.filename, version)
+	url, err := h.saveArtifactVersion(ctx, task.ID, a.dir, record.Filename, data)
+	if err != nil {
+		return record, "", fmt.Errorf("保存 %s 失败: %w", record.Filename, err)
+	}
+	record.CreatedAt = time.Now()
+	update := a.sceneURL(url)
+	if err := h.db.AddArtifactVersion(task.ID, owner, a.key(), record, &update); err != nil {
+		return record, "", err
+	}
+	// 新版本按当前剧本生成，不再需要重新生成；修改了朗读文本时同步到剧本
+	if a.kind == "audio" && record.Text != a.text {
+		h.syncArtifactText(task.ID, a, record.Text)
+	} else if err := h.db.ClearStale(task.ID, a.dir, a.filename); err != nil {
+		log.Printf("  ⚠️  %v", err)
+	}
+	h.markComicsStale(task.ID, a)
+
+	h.events.Publish(task.ID, EventArtifact, ArtifactEvent{
+		Kind:     a.kind,
+		SceneID:  a.sceneID,
+		Filename: record.Filename,
+		URL:      url,
+	})
+
+	log.Printf("✅ 产物已重新生成: %s %s (v%d)", task.ID, a.filename, version)
+	return record, url, nil
+}
+
+// RegenerateJobs 查询产物重新生成任务
+// GET /v1/tasks/:id/regenerations        - 列出最近的重新生成任务
+// GET /v1/tasks/:id/regenerations/:jobId - 查询单个重新生成任务
+func (h *Handler) RegenerateJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	taskID, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/tasks/"), "/regenerations")
+	if !ok || taskID == "" || strings.Contains(taskID, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	jobID := strings.Trim(rest, "/")
+
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	var resp any = ListRegenerateJobsResponse{Jobs: task.RegenerateJobs}
+	if task.RegenerateJobs == nil {
+		resp = ListRegenerateJobsResponse{Jobs: []RegenerateJob{}}
+	}
+	if jobID != "" {
+		i := slices.IndexFunc(task.RegenerateJobs, func(job RegenerateJob) bool { return job.JobID == jobID })
+		if i < 0 {
+			http.Error(w, "Regeneration job not found", http.StatusNotFound)
+			return
+		}
+		resp = task.RegenerateJobs[i]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
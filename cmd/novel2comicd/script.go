@@ -0,0 +1,647 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+)
+
+// scriptEditError 剧本修改失败，code 为返回给客户端的 HTTP 状态码
+type scriptEditError struct {
+	code int
+	msg  string
+}
+
+func (e *scriptEditError) Error() string { return e.msg }
+
+// editErrorf 构建剧本修改错误
+func editErrorf(code int, format string, args ...any) error {
+	return &scriptEditError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// EditScript 编辑任务剧本 /v1/tasks/:id/script/...
+// 只能在 awaiting_review 或 done 状态下编辑；done 状态下受影响的图片和音频会被标记为需要重新生成
+//
+//	POST   /script/scenes                       - 插入场景
+//	PUT    /script/scenes/order                 - 调整场景顺序
+//	PATCH  /script/scenes/:n                    - 修改场景字段（含旁白）
+//	DELETE /script/scenes/:n                    - 删除场景
+//	POST   /script/scenes/:n/dialogues          - 插入对话
+//	PUT    /script/scenes/:n/dialogues/order    - 调整对话顺序
+//	PATCH  /script/scenes/:n/dialogues/:k       - 修改对话
+//	DELETE /script/scenes/:n/dialogues/:k       - 删除对话
+//	PUT    /script/characters/:name             - 新增或修改角色描述
+//	DELETE /script/characters/:name             - 删除角色（仍被场景引用时返回 400）
+//	PUT    /script/locations/:id                - 新增或修改地点
+//	DELETE /script/locations/:id                - 删除地点（仍被场景引用时返回 400）
+func (h *Handler) EditScript(w http.ResponseWriter, r *http.Request) {
+	taskID := extractTaskID(r.URL.Path, "/v1/tasks/")
+	_, rest, _ := strings.Cut(r.URL.Path, "/script/")
+	if taskID == "" || rest == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxScriptSize+1))
+	if err != nil {
+		log.Printf("读取请求体失败: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+	if len(body) > maxScriptSize {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	mutate, err := scriptMutation(r.Method, strings.Split(rest, "/"), body)
+	if err != nil {
+		writeEditError(w, err)
+		return
+	}
+
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	scriptData, err := h.editScript(task, func(s *novel2script.Response) error {
+		return mutate(task, s)
+	})
+	if err != nil {
+		writeEditError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scriptData)
+
+	log.Printf("✅ 任务剧本已修改: %s %s /script/%s", taskID, r.Method, rest)
+}
+
+// scriptMutation 根据请求方法和路径构建对剧本的修改
+func scriptMutation(method string, parts []string, body []byte) (func(*Task, *novel2script.Response) error, error) {
+	route := method + " " + strings.Join(routePattern(parts), "/")
+	switch route {
+	case "POST scenes":
+		var req InsertSceneRequest
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		return func(task *Task, s *novel2script.Response) error {
+			return insertScene(task, s, req)
+		}, nil
+
+	case "PUT scenes/order":
+		var req ReorderRequest
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		return func(task *Task, s *novel2script.Response) error {
+			return reorderScenes(s, req.Order)
+		}, nil
+
+	case "PATCH scenes/:n":
+		var req SceneEdit
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		return withScene(parts[1], func(task *Task, s *novel2script.Response, i int) error {
+			applySceneEdit(&s.Script[i], req)
+			return nil
+		}), nil
+
+	case "DELETE scenes/:n":
+		return withScene(parts[1], func(task *Task, s *novel2script.Response, i int) error {
+			s.Script = append(s.Script[:i], s.Script[i+1:]...)
+			return nil
+		}), nil
+
+	case "POST scenes/:n/dialogues":
+		var req InsertDialogueRequest
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		return withScene(parts[1], func(task *Task, s *novel2script.Response, i int) error {
+			return insertDialogue(task, &s.Script[i], req)
+		}), nil
+
+	case "PUT scenes/:n/dialogues/order":
+		var req ReorderRequest
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		return withScene(parts[1], func(task *Task, s *novel2script.Response, i int) error {
+			return reorderDialogues(&s.Script[i], req.Order)
+		}), nil
+
+	case "PATCH scenes/:n/dialogues/:k":
+		var req DialogueEdit
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		return withLine(parts[1], parts[3], func(scene *novel2script.Scene, j int) {
+			applyDialogueEdit(&scene.Dialogue[j], req)
+		}), nil
+
+	case "DELETE scenes/:n/dialogues/:k":
+		return withLine(parts[1], parts[3], func(scene *novel2script.Scene, j int) {
+			scene.Dialogue = append(scene.Dialogue[:j], scene.Dialogue[j+1:]...)
+		}), nil
+
+	case "PUT characters/:name":
+		var req CharacterEdit
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		name := parts[1]
+		return func(task *Task, s *novel2script.Response) error {
+			if s.Characters == nil {
+				s.Characters = make(character.Bible)
+			}
+			s.Characters[name] = character.Character(req)
+			s.Characters.Normalize()
+			return nil
+		}, nil
+
+	case "DELETE characters/:name":
+		name := parts[1]
+		return func(task *Task, s *novel2script.Response) error {
+			if _, ok := s.Characters[name]; !ok {
+				return editErrorf(http.StatusNotFound, "角色 %s 不存在", name)
+			}
+			delete(s.Characters, name)
+			return nil
+		}, nil
+
+	case "PUT locations/:id":
+		var req LocationEdit
+		if err := decodeEditBody(body, &req); err != nil {
+			return nil, err
+		}
+		id := parts[1]
+		return func(task *Task, s *novel2script.Response) error {
+			if s.Locations == nil {
+				s.Locations = make(location.Bible)
+			}
+			s.Locations[id] = location.Location(req)
+			s.Locations.Normalize()
+			return nil
+		}, nil
+
+	case "DELETE locations/:id":
+		id := parts[1]
+		return func(task *Task, s *novel2script.Response) error {
+			if _, ok := s.Locations[id]; !ok {
+				return editErrorf(http.StatusNotFound, "地点 %s 不存在", id)
+			}
+			delete(s.Locations, id)
+			return nil
+		}, nil
+	}
+
+	for _, p := range routePattern(parts) {
+		if p == ":n" || p == ":k" || p == ":name" || p == ":id" || p == "scenes" || p == "characters" || p == "locations" {
+			return nil, editErrorf(http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+	return nil, editErrorf(http.StatusNotFound, "Not found")
+}
+
+// routePattern 将路径中的 ID、角色名和地点 ID 替换为占位符，例如 scenes/3/dialogues/2 -> scenes/:n/dialogues/:k
+func routePattern(parts []string) []string {
+	pattern := make([]string, len(parts))
+	for i, p := range parts {
+		switch {
+		case i == 1 && parts[0] == "scenes" && p != "order":
+			pattern[i] = ":n"
+		case i == 3 && parts[0] == "scenes" && parts[2] == "dialogues" && p != "order":
+			pattern[i] = ":k"
+		case i == 1 && parts[0] == "characters":
+			pattern[i] = ":name"
+		case i == 1 && parts[0] == "locations":
+			pattern[i] = ":id"
+		default:
+			pattern[i] = p
+		}
+	}
+	return pattern
+}
+
+// withScene 对 scene_id 为 rawID 的场景执行修改
+func withScene(rawID string, fn func(task *Task, s *novel2script.Response, i int) error) func(*Task, *novel2script.Response) error {
+	return func(task *Task, s *novel2script.Response) error {
+		sceneID, err := strconv.Atoi(rawID)
+		if err != nil {
+			return editErrorf(http.StatusBadRequest, "无效的 scene_id: %s", rawID)
+		}
+		i := s.FindScene(sceneID)
+		if i < 0 {
+			return editErrorf(http.StatusNotFound, "场景 %d 不存在", sceneID)
+		}
+		return fn(task, s, i)
+	}
+}
+
+// withLine 对场景 rawSceneID 中 line_id 为 rawLineID 的对话执行修改
+func withLine(rawSceneID, rawLineID string, fn func(scene *novel2script.Scene, j int)) func(*Task, *novel2script.Response) error {
+	return withScene(rawSceneID, func(task *Task, s *novel2script.Response, i int) error {
+		lineID, err := strconv.Atoi(rawLineID)
+		if err != nil {
+			return editErrorf(http.StatusBadRequest, "无效的 line_id: %s", rawLineID)
+		}
+		scene := &s.Script[i]
+		j := scene.FindLine(lineID)
+		if j < 0 {
+			return editErrorf(http.StatusNotFound, "场景 %d 中对话 %d 不存在", scene.SceneID, lineID)
+		}
+		fn(scene, j)
+		return nil
+	})
+}
+
+// insertScene 插入场景，分配新的 scene_id
+func insertScene(task *Task, s *novel2script.Response, req InsertSceneRequest) error {
+	scene := req.Scene
+	scene.SceneID = nextSceneID(task, s)
+	for j := range scene.Dialogue {
+		scene.Dialogue[j].LineID = j + 1
+	}
+
+	pos := len(s.Script)
+	if req.After != nil {
+		pos = 0
+		if *req.After != 0 {
+			i := s.FindScene(*req.After)
+			if i < 0 {
+				return editErrorf(http.StatusNotFound, "场景 %d 不存在", *req.After)
+			}
+			pos = i + 1
+		}
+	}
+	s.Script = append(s.Script[:pos], append([]novel2script.Scene{scene}, s.Script[pos:]...)...)
+	return nil
+}
+
+// insertDialogue 在场景中插入对话，分配新的 line_id
+func insertDialogue(task *Task, scene *novel2script.Scene, req InsertDialogueRequest) error {
+	line := req.DialogueLine
+	line.LineID = nextLineID(task, scene)
+
+	pos := len(scene.Dialogue)
+	if req.After != nil {
+		pos = 0
+		if *req.After != 0 {
+			j := scene.FindLine(*req.After)
+			if j < 0 {
+				return editErrorf(http.StatusNotFound, "场景 %d 中对话 %d 不存在", scene.SceneID, *req.After)
+			}
+			pos = j + 1
+		}
+	}
+	scene.Dialogue = append(scene.Dialogue[:pos], append([]novel2script.DialogueLine{line}, scene.Dialogue[pos:]...)...)
+	return nil
+}
+
+// reorderScenes 按 order 中的 scene_id 顺序重排场景，order 必须包含全部场景
+func reorderScenes(s *novel2script.Response, order []int) error {
+	if len(order) != len(s.Script) {
+		return editErrorf(http.StatusBadRequest, "order 必须包含全部 %d 个场景", len(s.Script))
+	}
+	reordered := make([]novel2script.Scene, 0, len(order))
+	seen := make(map[int]bool, len(order))
+	for _, id := range order {
+		i := s.FindScene(id)
+		if i < 0 || seen[id] {
+			return editErrorf(http.StatusBadRequest, "order 中的场景 %d 不存在或重复", id)
+		}
+		seen[id] = true
+		reordered = append(reordered, s.Script[i])
+	}
+	s.Script = reordered
+	return nil
+}
+
+// reorderDialogues 按 order 中的 line_id 顺序重排对话，order 必须包含全部对话
+func reorderDialogues(scene *novel2script.Scene, order []int) error {
+	if len(order) != len(scene.Dialogue) {
+		return editErrorf(http.StatusBadRequest, "order 必须包含全部 %d 句对话", len(scene.Dialogue))
+	}
+	reordered := make([]novel2script.DialogueLine, 0, len(order))
+	seen := make(map[int]bool, len(order))
+	for _, id := range order {
+		j := scene.FindLine(id)
+		if j < 0 || seen[id] {
+			return editErrorf(http.StatusBadRequest, "order 中的对话 %d 不存在或重复", id)
+		}
+		seen[id] = true
+		reordered = append(reordered, scene.Dialogue[j])
+	}
+	scene.Dialogue = reordered
+	return nil
+}
+
+// applySceneEdit 更新场景中请求里出现的字段
+func applySceneEdit(scene *novel2script.Scene, edit SceneEdit) {
+	if edit.Location != nil {
+		scene.Location = *edit.Location
+	}
+	if edit.LocationID != nil {
+		scene.LocationID = *edit.LocationID
+	}
+	if edit.TimeOfDay != nil {
+		scene.TimeOfDay = *edit.TimeOfDay
+	}
+	if edit.CharactersPresent != nil {
+		scene.CharactersPresent = *edit.CharactersPresent
+	}
+	if edit.SceneDescription != nil {
+		scene.SceneDescription = *edit.SceneDescription
+	}
+	if edit.NarrationVO != nil {
+		scene.NarrationVO = *edit.NarrationVO
+	}
+}
+
+// applyDialogueEdit 更新对话中请求里出现的字段
+func applyDialogueEdit(line *novel2script.DialogueLine, edit DialogueEdit) {
+	if edit.Character != nil {
+		line.Character = *edit.Character
+	}
+	if edit.Line != nil {
+		line.Line = *edit.Line
+	}
+	if edit.Emotion != nil {
+		line.Emotion = *edit.Emotion
+	}
+}
+
+// editScript 修改任务剧本并保存
+// mutate 在剧本副本上修改；保存前校验剧本，done 状态下计算受影响的产物并重建 scenes，
+// 然后同时写入任务文档和 script.json。fresh 为修改后已与剧本一致的产物文件名（如刚重新生成的音频），不标记为需要重新生成
+func (h *Handler) editScript(task *Task, mutate func(*novel2script.Response) error, fresh ...string) (*novel2script.Response, error) {
+	if task.Status != TaskStatusAwaitingReview && task.Status != TaskStatusDone {
+		return nil, editErrorf(http.StatusConflict, "Script can only be edited while awaiting review or done, task is %s", task.Status)
+	}
+
+	current, err := h.taskScript(task)
+	if err != nil {
+		return nil, editErrorf(http.StatusNotFound, "Script not available")
+	}
+	edited, err := copyScript(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := mutate(edited); err != nil {
+		return nil, err
+	}
+	if err := edited.Validate(); err != nil {
+		return nil, editErrorf(http.StatusBadRequest, "Invalid script: %v", err)
+	}
+	edited.Canonicalize(nil)
+	edited.AssignLineIDs()
+
+	// 已生成产物的任务：标记受影响的产物，并按新剧本重建 scenes
+	var scenes []Scene
+	stale := TaskStale{Images: []string{}, Audios: []string{}}
+	if task.Status == TaskStatusDone {
+		stale = mergeStale(task.Stale, staleArtifacts(current, edited), edited, fresh)
+		changed := comicInputsChanged(current, edited)
+		stale.Pages = task.Stale.Pages || (changed && len(task.Pages) > 0)
+		stale.Strip = task.Stale.Strip || (changed && len(task.Strip) > 0)
+		if scenes, err = buildScenes(h.store, task, edited, filepath.Join(h.outputDir, task.ID)); err != nil {
+			return nil, err
+		}
+		if scenes == nil {
+			scenes = make([]Scene, 0)
+		}
+	}
+
+	saved, err := h.db.SaveScript(task.ID, task.ScriptRevision, edited, scenes, stale)
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, editErrorf(http.StatusConflict, "Script was modified concurrently or the task has started processing, please retry")
+	}
+	if err := saveScriptToFile(edited, h.scriptPath(task.ID)); err != nil {
+		log.Printf("  ⚠️  写入 script.json 失败: %v", err)
+	}
+
+	task.Script = edited
+	task.ScriptRevision++
+	task.Stale = stale
+	if scenes != nil {
+		task.Scenes = scenes
+	}
+	return edited, nil
+}
+
+// taskScript 返回任务剧本，优先使用任务文档中的剧本，旧任务回退到 script.json
+func (h *Handler) taskScript(task *Task) (*novel2script.Response, error) {
+	if task.Script != nil && len(task.Script.Script) > 0 {
+		task.Script.AssignLineIDs()
+		return task.Script, nil
+	}
+	return loadScriptFromFile(h.scriptPath(task.ID))
+}
+
+// copyScript 深拷贝剧本
+func copyScript(s *novel2script.Response) (*novel2script.Response, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var c novel2script.Response
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// staleArtifacts 比较修改前后的剧本，返回输入发生变化或新增的产物
+// 图片受场景地点、时间、出场角色、场景描述以及出场角色和地点的描述影响；音频受文本、角色和情感影响
+func staleArtifacts(before, after *novel2script.Response) TaskStale {
+	var stale TaskStale
+	for _, scene := range after.Script {
+		i := before.FindScene(scene.SceneID)
+		if i < 0 {
+			// 新场景：全部产物都需要生成
+			stale.Images = append(stale.Images, sceneImageFilename(scene.SceneID))
+			if scene.NarrationVO != "" {
+				stale.Audios = append(stale.Audios, narrationAudioFilename(scene.SceneID))
+			}
+			for _, d := range scene.Dialogue {
+				stale.Audios = append(stale.Audios, dialogueAudioFilename(scene.SceneID, d.LineID))
+			}
+			continue
+		}
+		old := before.Script[i]
+
+		if imageInputsChanged(old, scene, before, after) {
+			stale.Images = append(stale.Images, sceneImageFilename(scene.SceneID))
+		}
+		if scene.NarrationVO != "" && scene.NarrationVO != old.NarrationVO {
+			stale.Audios = append(stale.Audios, narrationAudioFilename(scene.SceneID))
+		}
+		for _, d := range scene.Dialogue {
+			j := old.FindLine(d.LineID)
+			if j < 0 || old.Dialogue[j].Character != d.Character || old.Dialogue[j].Line != d.Line || old.Dialogue[j].Emotion != d.Emotion {
+				stale.Audios = append(stale.Audios, dialogueAudioFilename(scene.SceneID, d.LineID))
+			}
+		}
+	}
+	return stale
+}
+
+// imageInputsChanged 场景图片的提示词输入是否变化
+func imageInputsChanged(before, after novel2script.Scene, beforeScript, afterScript *novel2script.Response) bool {
+	if before.Location != after.Location ||
+		before.LocationID != after.LocationID ||
+		before.TimeOfDay != after.TimeOfDay ||
+		before.SceneDescription != after.SceneDescription ||
+		!reflect.DeepEqual(before.CharactersPresent, after.CharactersPresent) {
+		return true
+	}
+	for _, name := range after.CharactersPresent {
+		if beforeScript.Characters[name].Visual() != afterScript.Characters[name].Visual() {
+			return true
+		}
+	}
+	beforeLocation, afterLocation := beforeScript.Locations[after.LocationID], afterScript.Locations[after.LocationID]
+	return beforeLocation.Name != afterLocation.Name || beforeLocation.Visual() != afterLocation.Visual()
+}
+
+// comicInputsChanged 漫画页和条漫中除场景图片以外的内容是否变化：场景的增删和顺序、旁白以及对话的角色和台词
+func comicInputsChanged(before, after *novel2script.Response) bool {
+	if len(before.Script) != len(after.Script) {
+		return true
+	}
+	for i, scene := range after.Script {
+		old := before.Script[i]
+		if old.SceneID != scene.SceneID || old.NarrationVO != scene.NarrationVO || len(old.Dialogue) != len(scene.Dialogue) {
+			return true
+		}
+		for j, d := range scene.Dialogue {
+			if old.Dialogue[j].Character != d.Character || old.Dialogue[j].Line != d.Line {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mergeStale 合并已有和新增的待重新生成产物，去掉 fresh 中的产物以及剧本中已不存在的产物
+func mergeStale(current, changed TaskStale, script *novel2script.Response, fresh []string) TaskStale {
+	valid := make(map[string]bool)
+	for _, scene := range script.Script {
+		valid[sceneImageFilename(scene.SceneID)] = true
+		if scene.NarrationVO != "" {
+			valid[narrationAudioFilename(scene.SceneID)] = true
+		}
+		for _, d := range scene.Dialogue {
+			valid[dialogueAudioFilename(scene.SceneID, d.LineID)] = true
+		}
+	}
+	for _, f := range fresh {
+		delete(valid, f)
+	}
+
+	merge := func(lists ...[]string) []string {
+		result := []string{}
+		seen := make(map[string]bool)
+		for _, list := range lists {
+			for _, f := range list {
+				if valid[f] && !seen[f] {
+					seen[f] = true
+					result = append(result, f)
+				}
+			}
+		}
+		return result
+	}
+	return TaskStale{
+		Images: merge(current.Images, changed.Images),
+		Audios: merge(current.Audios, changed.Audios),
+	}
+}
+
+// nextSceneID 返回新场景的 scene_id
+// 不复用已删除场景的 ID，因为其产物文件和版本历史仍然存在
+func nextSceneID(task *Task, s *novel2script.Response) int {
+	id := s.NextSceneID()
+	for _, f := range task.Checkpoint.Images {
+		var n int
+		if _, err := fmt.Sscanf(f, "scene_%d.png", &n); err == nil && n >= id {
+			id = n + 1
+		}
+	}
+	for key := range task.ArtifactVersions {
+		var n int
+		if _, err := fmt.Sscanf(key, "images/scene_%d", &n); err == nil && n >= id {
+			id = n + 1
+		}
+	}
+	return id
+}
+
+// nextLineID 返回场景中新对话的 line_id，同样不复用已删除对话的 ID
+func nextLineID(task *Task, scene *novel2script.Scene) int {
+	id := scene.MaxLineID() + 1
+	used := func(sceneID, lineID int) {
+		if sceneID == scene.SceneID && lineID >= id {
+			id = lineID + 1
+		}
+	}
+	for _, f := range task.Checkpoint.Audios {
+		var sceneID, lineID int
+		if _, err := fmt.Sscanf(f, "scene_%d_dialogue_%d.mp3", &sceneID, &lineID); err == nil {
+			used(sceneID, lineID)
+		}
+	}
+	for key := range task.ArtifactVersions {
+		var sceneID, lineID int
+		if _, err := fmt.Sscanf(key, "audios/scene_%d_dialogue_%d", &sceneID, &lineID); err == nil {
+			used(sceneID, lineID)
+		}
+	}
+	return id
+}
+
+// decodeEditBody 严格解析剧本修改请求体
+func decodeEditBody(body []byte, v any) error {
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return editErrorf(http.StatusBadRequest, "Invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// writeEditError 将剧本修改错误写入响应
+func writeEditError(w http.ResponseWriter, err error) {
+	var editErr *scriptEditError
+	if errors.As(err, &editErr) {
+		http.Error(w, editErr.msg, editErr.code)
+		return
+	}
+	log.Printf("修改剧本失败: %v", err)
+	http.Error(w, "Failed to edit script", http.StatusInternalServerError)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/qiniu/go-sdk/v7/storage"
+)
+
+// 产物存储后端
+const (
+	StorageBackendLocal = "local" // 本地磁盘，由 /artifacts/ 静态文件服务提供访问
+	StorageBackendQiniu = "qiniu" // 七牛云 Kodo
+)
+
+// ArtifactStore 产物存储
+// key 为相对路径，如 "<taskID>/images/scene_001.png"
+type ArtifactStore interface {
+	// Put 将本地文件写入存储，key 已存在时覆盖
+	Put(ctx context.Context, key, localPath string) error
+	// URL 返回 key 的访问地址
+	URL(key string) string
+	// Delete 删除 key，key 不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// List 列出以 prefix 开头的所有 key
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// NewArtifactStore 根据配置创建产物存储
+func NewArtifactStore(config *Config, baseURL string) (ArtifactStore, error) {
+	switch config.Storage.Backend {
+	case "", StorageBackendLocal:
+		return NewLocalStore(config.Storage.OutputDir, baseURL), nil
+	case StorageBackendQiniu:
+		if config.Qiniu.Bucket == "" || config.Qiniu.Domain == "" {
+			return nil, fmt.Errorf("七牛云存储需要配置 qiniu.bucket 和 qiniu.domain")
+		}
+		return NewQiniuStore(config.Qiniu, config.Storage.KeyPrefix), nil
+	default:
+		return nil, fmt.Errorf("不支持的存储后端: %s", config.Storage.Backend)
+	}
+}
+
+// artifactKey 构建任务产物的 key
+func artifactKey(taskID, dir, filename string) string {
+	return path.Join(taskID, dir, filename)
+}
+
+// LocalStore 本地磁盘存储
+// 产物保存在 root 下，通过 /artifacts/ 静态文件服务访问
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+// NewLocalStore 创建本地磁盘存储
+func NewLocalStore(root, baseURL string) *LocalStore {
+	return &LocalStore{root: root, baseURL: baseURL}
+}
+
+// Put 将本地文件复制到存储目录，源文件已在目标位置时不做任何操作
+func (s *LocalStore) Put(ctx context.Context, key, localPath string) error {
+	dst := s.path(key)
+
+	srcAbs, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+	dstAbs, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	if srcAbs == dstAbs {
+		return nil
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+	return writeFileAtomic(dst, data)
+}
+
+// URL 返回静态文件服务的访问地址
+func (s *LocalStore) URL(key string) string {
+	return fmt.Sprintf("%s/artifacts/%s", s.baseURL, key)
+}
+
+// Delete 删除文件
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("删除文件失败: %w", err)
+	}
+	return nil
+}
+
+// List 遍历 prefix 所在目录，列出以 prefix 开头的文件
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]string, error) {
+	dir := prefix
+	if !strings.HasSuffix(prefix, "/") {
+		dir = path.Dir(prefix)
+	}
+	var keys []string
+	err := filepath.WalkDir(s.path(dir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("列出文件失败: %w", err)
+	}
+	return keys, nil
+}
+
+// path 将 key 转换为存储目录下的本地路径，".." 不会越出存储目录
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// QiniuStore 七牛云 Kodo 存储
+type QiniuStore struct {
+	uploader      *QiniuUploader
+	bucketManager *storage.BucketManager
+	keyPrefix     string
+}
+
+// NewQiniuStore 创建七牛云存储，keyPrefix 会拼接在所有 key 之前（如 "tasks/"）
+func NewQiniuStore(cfg QiniuConfig, keyPrefix string) *QiniuStore {
+	uploader := NewQiniuUploader(cfg)
+	return &QiniuStore{
+		uploader:      uploader,
+		bucketManager: storage.NewBucketManager(uploader.mac, uploader.cfg),
+		keyPrefix:     keyPrefix,
+	}
+}
+
+// Put 上传文件
+func (s *QiniuStore) Put(ctx context.Context, key, localPath string) error {
+	_, err := s.uploader.UploadFile(ctx, localPath, s.keyPrefix+key)
+	return err
+}
+
+// URL 返回公开访问地址
+func (s *QiniuStore) URL(key string) string {
+	return s.uploader.URL(s.keyPrefix + key)
+}
+
+// Delete 删除对象，对象不存在时忽略
+func (s *QiniuStore) Delete(ctx context.Context, key string) error {
+	err := s.bucketManager.Delete(s.uploader.bucket, s.keyPrefix+key)
+	var info *storage.ErrorInfo
+	if errors.As(err, &info) && info.Code == 612 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("删除七牛云文件失败: %w", err)
+	}
+	return nil
+}
+
+// List 分页列出以 prefix 开头的对象
+func (s *QiniuStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	marker := ""
+	for {
+		ret, hasNext, err := s.bucketManager.ListFilesWithContext(ctx, s.uploader.bucket,
+			storage.ListInputOptionsPrefix(s.keyPrefix+prefix),
+			storage.ListInputOptionsMarker(marker),
+			storage.ListInputOptionsLimit(1000),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("列出七牛云文件失败: %w", err)
+		}
+		for _, item := range ret.Items {
+			keys = append(keys, strings.TrimPrefix(item.Key, s.keyPrefix))
+		}
+		if !hasNext {
+			return keys, nil
+		}
+		marker = ret.Marker
+	}
+}
+
+// MemoryStore 进程内存存储，用于在没有网络和磁盘依赖的情况下测试
+type MemoryStore struct {
+	baseURL string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore(baseURL string) *MemoryStore {
+	return &MemoryStore{
+		baseURL: baseURL,
+		objects: make(map[string][]byte),
+	}
+}
+
+// Put 读取本地文件并保存到内存
+func (s *MemoryStore) Put(ctx context.Context, key, localPath string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+// URL 返回形如 <baseURL>/memory/<key> 的地址
+func (s *MemoryStore) URL(key string) string {
+	return fmt.Sprintf("%s/memory/%s", s.baseURL, key)
+}
+
+// Delete 删除对象
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+// List 列出以 prefix 开头的对象，按 key 排序
+func (s *MemoryStore) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Get 返回对象内容，用于测试断言
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[key]
+	return data, ok
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+)
+
+// writeTempFile 在临时目录中写入一个文件，返回文件路径
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testArtifactStore 对 ArtifactStore 的实现做通用的 Put、List、Delete 检查
+// read 返回 key 在存储中的内容，不存在时返回 false
+func testArtifactStore(t *testing.T, store ArtifactStore, read func(key string) ([]byte, bool)) {
+	t.Helper()
+	ctx := context.Background()
+
+	image := writeTempFile(t, "scene_001.png", "image")
+	audio := writeTempFile(t, "scene_001_narration.mp3", "audio")
+	other := writeTempFile(t, "scene_001.png", "other task")
+	puts := map[string]string{
+		"task1/images/scene_001.png":           image,
+		"task1/audios/scene_001_narration.mp3": audio,
+		"task2/images/scene_001.png":           other,
+	}
+	for key, src := range puts {
+		if err := store.Put(ctx, key, src); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+	if data, ok := read("task1/images/scene_001.png"); !ok || string(data) != "image" {
+		t.Fatalf("read after Put = %q, %v", data, ok)
+	}
+
+	// 覆盖已有的 key
+	if err := store.Put(ctx, "task1/images/scene_001.png", writeTempFile(t, "v2.png", "image v2")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := read("task1/images/scene_001.png"); string(data) != "image v2" {
+		t.Fatalf("read after overwrite = %q", data)
+	}
+
+	lists := []struct {
+		prefix string
+		want   []string
+	}{
+		{"task1/", []string{"task1/audios/scene_001_narration.mp3", "task1/images/scene_001.png"}},
+		{"task1/images/", []string{"task1/images/scene_001.png"}},
+		{"task1/audios/scene_001_", []string{"task1/audios/scene_001_narration.mp3"}},
+		{"task3/", nil},
+	}
+	for _, tt := range lists {
+		keys, err := store.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%s): %v", tt.prefix, err)
+		}
+		if len(keys) == 0 {
+			keys = nil
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("List(%s) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+
+	if err := store.Delete(ctx, "task1/images/scene_001.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := read("task1/images/scene_001.png"); ok {
+		t.Error("key still exists after Delete")
+	}
+	if err := store.Delete(ctx, "task1/images/missing.png"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+	if keys, _ := store.List(ctx, "task2/"); len(keys) != 1 {
+		t.Errorf("Delete affected other tasks: %v", keys)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocalStore(root, "http://localhost:8080")
+
+	testArtifactStore(t, store, func(key string) ([]byte, bool) {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(key)))
+		return data, err == nil
+	})
+
+	if got, want := store.URL("task1/images/scene_001.png"), "http://localhost:8080/artifacts/task1/images/scene_001.png"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+
+	// 源文件已在存储目录中（任务输出目录即存储目录）时不做任何操作
+	inPlace := filepath.Join(root, "task4", "images", "scene_001.png")
+	if err := os.MkdirAll(filepath.Dir(inPlace), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(inPlace, []byte("in place"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "task4/images/scene_001.png", inPlace); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(inPlace); string(data) != "in place" {
+		t.Errorf("in-place Put changed the file: %q", data)
+	}
+
+	// ".." 不会越出存储目录
+	if err := store.Put(context.Background(), "../escape.png", writeTempFile(t, "escape.png", "x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.png")); err != nil {
+		t.Errorf("key with .. was not kept inside the root: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escape.png")); err == nil {
+		t.Error("key with .. escaped the root")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore("http://localhost:8080")
+
+	testArtifactStore(t, store, store.Get)
+
+	if got, want := store.URL("task1/images/scene_001.png"), "http://localhost:8080/memory/task1/images/scene_001.png"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+	if err := store.Put(context.Background(), "task1/missing.png", filepath.Join(t.TempDir(), "missing.png")); err == nil {
+		t.Error("Put of a missing local file should fail")
+	}
+}
+
+func TestBuildScenesUsesStore(t *testing.T) {
+	store := NewMemoryStore("http://cdn.example.com")
+	taskDir := t.TempDir()
+
+	// 场景 1 的对话 2 只有本地文件（检查点写入前中断）
+	if err := os.MkdirAll(filepath.Join(taskDir, "audios"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(taskDir, "audios", dialogueAudioFilename(1, 2)), []byte("audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{
+		ID: "task1",
+		Checkpoint: TaskCheckpoint{
+			Images: []string{sceneImageFilename(1)},
+			Audios: []string{narrationAudioFilename(1), dialogueAudioFilename(1, 1)},
+		},
+		ArtifactVersions: map[string]ArtifactVersions{
+			artifactVersionKey("images", sceneImageFilename(2)): {Latest: 2, Active: 2},
+		},
+	}
+	scriptData := &novel2script.Response{Script: []novel2script.Scene{
+		{
+			SceneID:     1,
+			NarrationVO: "旁白",
+			Dialogue: []novel2script.DialogueLine{
+				{LineID: 1, Character: "小红帽", Line: "外婆"},
+				{LineID: 2, Character: "狼", Line: "你好"},
+				{LineID: 3, Character: "狼", Line: "生成失败的台词"},
+			},
+		},
+		{SceneID: 2},
+		// 编辑剧本新增、尚未生成产物的场景
+		{SceneID: 3, NarrationVO: "新旁白", Dialogue: []novel2script.DialogueLine{{LineID: 1, Character: "小红帽", Line: "新台词"}}},
+	}}
+
+	scenes, err := buildScenes(store, task, scriptData, taskDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenes) != 3 {
+		t.Fatalf("got %d scenes, want 3", len(scenes))
+	}
+
+	url := func(dir, filename string) string {
+		return store.URL(artifactKey("task1", dir, filename))
+	}
+	checks := []struct {
+		name, got, want string
+	}{
+		{"scene 1 image", scenes[0].ImageURL, url("images", "scene_001.png")},
+		{"scene 1 narration", scenes[0].NarrationVoiceURL, url("audios", "scene_001_narration.mp3")},
+		{"scene 1 line 1", scenes[0].Dialogues[0].VoiceURL, url("audios", "scene_001_dialogue_001.mp3")},
+		{"scene 1 line 2", scenes[0].Dialogues[1].VoiceURL, url("audios", "scene_001_dialogue_002.mp3")},
+		{"scene 1 line 3", scenes[0].Dialogues[2].VoiceURL, ""},
+		{"scene 2 image", scenes[1].ImageURL, url("images", "scene_002.v2.png")},
+		{"scene 3 image", scenes[2].ImageURL, ""},
+		{"scene 3 narration", scenes[2].NarrationVoiceURL, ""},
+		{"scene 3 line 1", scenes[2].Dialogues[0].VoiceURL, ""},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s URL = %q, want %q", c.name, c.got, c.want)
+		}
+	}
+	if scenes[2].Narration != "新旁白" || scenes[2].Dialogues[0].LineID != 1 {
+		t.Errorf("scene 3 content = %+v", scenes[2])
+	}
+}
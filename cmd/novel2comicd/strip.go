@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/TxtAnime/txt-anime/pkgs/comicpage"
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+)
+
+// stripDir 条漫所在的子目录
+const stripDir = "strip"
+
+// 条漫尺寸的取值范围，0 表示使用默认值
+const (
+	minStripWidth     = 200
+	maxStripWidth     = 2048
+	minStripMaxHeight = 400
+)
+
+// stripFilename 条漫分段文件名，segment 从 1 开始
+func stripFilename(segment int) string {
+	return fmt.Sprintf("strip_%03d.png", segment)
+}
+
+// validateStripSize 校验条漫宽度和每段最大高度
+func validateStripSize(width, maxHeight int) error {
+	if width != 0 && (width < minStripWidth || width > maxStripWidth) {
+		return fmt.Errorf("条漫宽度必须在 %d 到 %d 之间", minStripWidth, maxStripWidth)
+	}
+	if maxHeight != 0 && maxHeight < minStripMaxHeight {
+		return fmt.Errorf("条漫每段最大高度不能小于 %d", minStripMaxHeight)
+	}
+	return nil
+}
+
+// exportStrip 把场景图片纵向拼接为条漫，旁白框放在图片上方，对话气泡叠加在图片上，按最大高度切分后写入存储并保存到任务
+// 逐个场景加载图片，同一时刻只保留不到一段的图片；场景图片使用当前版本，缺失时留空
+func (p *TaskProcessor) exportStrip(ctx context.Context, taskID string, scriptData *novel2script.Response, versions map[string]ArtifactVersions, width, maxHeight int) ([]StripSegment, error) {
+	font, err := p.comicFont()
+	if err != nil {
+		return nil, err
+	}
+
+	taskDir := filepath.Join(p.config.Storage.OutputDir, taskID)
+	localDir := filepath.Join(taskDir, stripDir)
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建条漫目录失败: %w", err)
+	}
+
+	scenes := scriptData.Script
+	var segments []StripSegment
+	writer, err := comicpage.NewStripWriter(p.config.Comic.stripConfig(font, width, maxHeight), func(seg comicpage.Segment) error {
+		s := StripSegment{
+			Segment:  len(segments) + 1,
+			Filename: stripFilename(len(segments) + 1),
+			Width:    seg.Image.Bounds().Dx(),
+			Height:   seg.Image.Bounds().Dy(),
+		}
+		for _, i := range seg.Panels {
+			s.SceneIDs = append(s.SceneIDs, scenes[i].SceneID)
+		}
+
+		data, err := comicpage.EncodePNG(seg.Image)
+		if err != nil {
+			return fmt.Errorf("导出第 %d 段失败: %w", s.Segment, err)
+		}
+		localPath := filepath.Join(localDir, s.Filename)
+		if err := writeFileAtomic(localPath, data); err != nil {
+			return fmt.Errorf("保存第 %d 段失败: %w", s.Segment, err)
+		}
+		key := artifactKey(taskID, stripDir, s.Filename)
+		if err := p.store.Put(ctx, key, localPath); err != nil {
+			return fmt.Errorf("写入产物 %s 失败: %w", key, err)
+		}
+		p.events.Publish(taskID, EventArtifact, ArtifactEvent{
+			Kind:     "strip",
+			SceneID:  s.SceneIDs[0],
+			Segment:  s.Segment,
+			Filename: s.Filename,
+			URL:      p.store.URL(key),
+		})
+		segments = append(segments, s)
+		log.Printf("    ✅ 条漫第 %d 段已导出 (%dx%d，场景 %v)", s.Segment, s.Width, s.Height, s.SceneIDs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scene := range scenes {
+		if err := ctx.Err(); err != nil {
+			writer.Discard()
+			return nil, err
+		}
+		if err := writer.Add(scenePanel(taskDir, scene, versions)); err != nil {
+			writer.Discard()
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := p.db.SetTaskStrip(taskID, segments); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// ExportStrip 按当前剧本和场景图片导出条漫 POST /v1/tasks/:id/strip
+// 请求体可选，指定宽度和每段最大高度；任务需已完成
+func (h *Handler) ExportStrip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ExportStripRequest
+	if !decodeOptionalJSON(w, r, &req) {
+		return
+	}
+	if err := validateStripSize(req.Width, req.MaxHeight); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, scriptData, ok := h.loadDoneTask(w, r)
+	if !ok {
+		return
+	}
+
+	segments, err := h.processor.exportStrip(r.Context(), task.ID, scriptData, task.ArtifactVersions, req.Width, req.MaxHeight)
+	if err != nil {
+		log.Printf("导出条漫失败: %v", err)
+		http.Error(w, "Failed to export strip", http.StatusInternalServerError)
+		return
+	}
+	task.Strip = segments
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExportStripResponse{Segments: h.stripArtifacts(task)})
+
+	log.Printf("✅ 任务 %s 已导出 %d 段条漫", task.ID, len(segments))
+}
+
+// stripArtifacts 返回任务最近一次导出的条漫
+func (h *Handler) stripArtifacts(task *Task) []StripArtifact {
+	segments := make([]StripArtifact, 0, len(task.Strip))
+	for _, s := range task.Strip {
+		segments = append(segments, StripArtifact{
+			Segment:  s.Segment,
+			Filename: s.Filename,
+			Width:    s.Width,
+			Height:   s.Height,
+			SceneIDs: s.SceneIDs,
+			ImageURL: h.store.URL(artifactKey(task.ID, stripDir, s.Filename)),
+		})
+	}
+	return segments
+}
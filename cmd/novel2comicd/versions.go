@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TxtAnime/txt-anime/pkgs/audiosync"
+	"github.com/TxtAnime/txt-anime/pkgs/audiosynctc"
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+	"github.com/google/uuid"
+)
+
+// maxRegenerateBodySize 重新生成和切换版本请求体大小上限
+const maxRegenerateBodySize = 64 << 10
+
+// SceneArtifacts 单个场景产物的重新生成与版本管理
+// 产物为 image（场景图片）、narration（旁白音频）或 dialogues/:k/audio（line_id 为 k 的对话音频）：
+// POST /v1/tasks/:id/scenes/:n/<产物>:regenerate - 提交重新生成任务，在后台生成新版本并设为当前版本
+// GET  /v1/tasks/:id/scenes/:n/<产物>/versions   - 列出所有版本
+// POST /v1/tasks/:id/scenes/:n/<产物>:activate   - 切换当前版本
+func (h *Handler) SceneArtifacts(w http.ResponseWriter, r *http.Request) {
+	taskID, sceneID, action, ok := parseScenePath(r.URL.Path)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	target, op := splitSceneAction(action)
+	method := http.MethodPost
+	switch op {
+	case "regenerate", "activate":
+	case "versions":
+		method = http.MethodGet
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	task, scriptData, idx, ok := h.loadScene(w, taskID, sceneID, op != "versions")
+	if !ok {
+		return
+	}
+	artifact, ok := resolveSceneArtifact(target, task, scriptData, idx)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Artifact %s not found in scene %d", target, sceneID), http.StatusNotFound)
+		return
+	}
+
+	switch op {
+	case "regenerate":
+		h.regenerateArtifact(w, r, task, target, artifact)
+	case "versions":
+		h.listArtifactVersions(w, task, artifact)
+	case "activate":
+		h.activateArtifactVersion(w, r, task, artifact)
+	}
+}
+
+// sceneArtifact 场景中可以单独重新生成的产物
+type sceneArtifact struct {
+	sceneID    int
+	kind       string // image 或 audio
+	dir        string // images 或 audios
+	filename   string // 原始产物文件名
+	urlField   string // scenes 中 URL 的字段名：image_url、narration_voice_url 或 voice_url
+	currentURL string // scenes 中当前的 URL
+
+	// 以下仅音频使用
+	lineID    int    // 对话的 line_id，旁白为 0
+	text      string // 当前朗读文本
+	character string // 说话角色，旁白为 "旁白"
+	emotion   string // 剧本中的情感
+}
+
+// key 产物在版本历史中的 key
+func (a sceneArtifact) key() string {
+	return artifactVersionKey(a.dir, a.filename)
+}
+
+// sceneURL 切换到新版本时 scenes 中 URL 的更新
+func (a sceneArtifact) sceneURL(url string) SceneURLUpdate {
+	return SceneURLUpdate{SceneID: a.sceneID, LineID: a.lineID, Field: a.urlField, URL: url}
+}
+
+// setText 将剧本中该音频的朗读文本修改为 text
+func (a sceneArtifact) setText(s *novel2script.Response, text string) error {
+	i := s.FindScene(a.sceneID)
+	if i < 0 {
+		return editErrorf(http.StatusNotFound, "场景 %d 不存在", a.sceneID)
+	}
+	scene := &s.Script[i]
+	if a.lineID == 0 {
+		scene.NarrationVO = text
+		return nil
+	}
+	j := scene.FindLine(a.lineID)
+	if j < 0 {
+		return editErrorf(http.StatusNotFound, "场景 %d 中对话 %d 不存在", a.sceneID, a.lineID)
+	}
+	scene.Dialogue[j].Line = text
+	return nil
+}
+
+// resolveSceneArtifact 根据路径中的产物名找到场景中的产物，产物不存在时返回 false
+func resolveSceneArtifact(target string, task *Task, scriptData *novel2script.Response, idx int) (sceneArtifact, bool) {
+	scene := scriptData.Script[idx]
+	var current *Scene
+	if idx < len(task.Scenes) {
+		current = &task.Scenes[idx]
+	}
+
+	switch {
+	case target == "image":
+		a := sceneArtifact{
+			sceneID:  scene.SceneID,
+			kind:     "image",
+			dir:      "images",
+			filename: sceneImageFilename(scene.SceneID),
+			urlField: "image_url",
+		}
+		if current != nil {
+			a.currentURL = current.ImageURL
+		}
+		return a, true
+
+	case target == "narration":
+		if scene.NarrationVO == "" {
+			return sceneArtifact{}, false
+		}
+		a := sceneArtifact{
+			sceneID:   scene.SceneID,
+			kind:      "audio",
+			dir:       "audios",
+			filename:  narrationAudioFilename(scene.SceneID),
+			urlField:  "narration_voice_url",
+			text:      scene.NarrationVO,
+			character: audiosync.NarratorCharacter,
+		}
+		if current != nil {
+			a.currentURL = current.NarrationVoiceURL
+		}
+		return a, true
+
+	case strings.HasPrefix(target, "dialogues/") && strings.HasSuffix(target, "/audio"):
+		lineID, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(target, "dialogues/"), "/audio"))
+		if err != nil {
+			return sceneArtifact{}, false
+		}
+		j := scene.FindLine(lineID)
+		if j < 0 {
+			return sceneArtifact{}, false
+		}
+		dialogue := scene.Dialogue[j]
+		a := sceneArtifact{
+			sceneID:   scene.SceneID,
+			kind:      "audio",
+			dir:       "audios",
+			filename:  dialogueAudioFilename(scene.SceneID, lineID),
+			urlField:  "voice_url",
+			lineID:    lineID,
+			text:      dialogue.Line,
+			character: dialogue.Character,
+			emotion:   dialogue.Emotion,
+		}
+		if current != nil && j < len(current.Dialogues) {
+			a.currentURL = current.Dialogues[j].VoiceURL
+		}
+		return a, true
+	}
+	return sceneArtifact{}, false
+}
+
+// regenerateArtifact 提交单个产物的重新生成任务，返回 202 和排队中的任务
+// 生成在后台执行（见 Handler.runRegenerations），通过 GET /v1/tasks/:id/regenerations/:jobId 或 SSE regenerate 事件获取结果
+func (h *Handler) regenerateArtifact(w http.ResponseWriter, r *http.Request, task *Task, target string, a sceneArtifact) {
+	job := RegenerateJob{
+		JobID:     uuid.New().String(),
+		Status:    RegenerateJobQueued,
+		SceneID:   a.sceneID,
+		Target:    target,
+		Kind:      a.kind,
+		CreatedAt: time.Now(),
+	}
+	switch a.kind {
+	case "image":
+		var req RegenerateImageRequest
+		if !decodeOptionalJSON(w, r, &req) {
+			return
+		}
+		job.Prompt = req.Prompt
+		job.Instructions = req.Instructions
+
+	case "audio":
+		var req RegenerateAudioRequest
+		if !decodeOptionalJSON(w, r, &req) {
+			return
+		}
+		// 提前校验音色，执行时按当时的音色匹配结果重新确定
+		if _, err := h.lineVoice(task.ID, a.character, req.Voice); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job.Voice = req.Voice
+		job.Emotion = req.Emotion
+		job.Text = req.Text
+	}
+
+	pending := 0
+	for _, j := range task.RegenerateJobs {
+		if j.IsPending() {
+			pending++
+		}
+	}
+	if pending >= maxPendingRegenerations {
+		http.Error(w, fmt.Sprintf("Too many pending regenerations (max %d)", maxPendingRegenerations), http.StatusTooManyRequests)
+		return
+	}
+
+	added, err := h.db.AddRegenerateJob(task.ID, job, maxRegenerateJobs)
+	if err != nil {
+		log.Printf("提交重新生成任务失败: %v", err)
+		http.Error(w, "Failed to submit regeneration", http.StatusInternalServerError)
+		return
+	}
+	if !added {
+		http.Error(w, "Task is not done", http.StatusConflict)
+		return
+	}
+	h.events.Publish(task.ID, EventRegenerate, job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/v1/tasks/%s/regenerations/%s", task.ID, job.JobID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+
+	log.Printf("已提交重新生成任务: %s %s (%s)", task.ID, a.filename, job.JobID)
+}
+
+// listArtifactVersions 列出产物的所有版本
+func (h *Handler) listArtifactVersions(w http.ResponseWriter, task *Task, a sceneArtifact) {
+	resp := h.artifactVersionsResponse(task, a)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// activateArtifactVersion 切换产物的当前版本，GetArtifacts 随之返回该版本（音频同时返回该版本的文本）
+func (h *Handler) activateArtifactVersion(w http.ResponseWriter, r *http.Request, task *Task, a sceneArtifact) {
+	var req ActivateVersionRequest
+	if !decodeOptionalJSON(w, r, &req) {
+		return
+	}
+	if req.Version <= 0 {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	versions := task.ArtifactVersions[a.key()]
+	var record *ArtifactVersion
+	for i := range versions.Versions {
+		if versions.Versions[i].Version == req.Version {
+			record = &versions.Versions[i]
+			break
+		}
+	}
+	if record == nil {
+		http.Error(w, fmt.Sprintf("Version %d not found", req.Version), http.StatusNotFound)
+		return
+	}
+
+	url := h.store.URL(artifactKey(task.ID, a.dir, record.Filename))
+	activated, err := h.db.ActivateArtifactVersion(task.ID, a.key(), req.Version, a.sceneURL(url))
+	if err != nil {
+		log.Printf("切换 %s 版本失败: %v", a.filename, err)
+		http.Error(w, "Failed to activate version", http.StatusInternalServerError)
+		return
+	}
+	if !activated {
+		http.Error(w, fmt.Sprintf("Version %d not found", req.Version), http.StatusNotFound)
+		return
+	}
+	if a.kind == "audio" && record.Text != "" && record.Text != a.text {
+		h.syncArtifactText(task.ID, a, record.Text)
+	}
+	h.markComicsStale(task.ID, a)
+
+	h.events.Publish(task.ID, EventArtifact, ArtifactEvent{
+		Kind:     a.kind,
+		SceneID:  a.sceneID,
+		Filename: record.Filename,
+		URL:      url,
+	})
+
+	versions.Active = req.Version
+	task.ArtifactVersions[a.key()] = versions
+	a.currentURL = url
+	resp := h.artifactVersionsResponse(task, a)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	log.Printf("✅ 产物已切换版本: %s %s (v%d)", task.ID, a.filename, req.Version)
+}
+
+// markComicsStale 场景图片的当前版本变化后，将已有的漫画页和条漫标记为过期；失败只记录日志
+func (h *Handler) markComicsStale(taskID string, a sceneArtifact) {
+	if a.kind != "image" {
+		return
+	}
+	if err := h.db.MarkComicsStale(taskID); err != nil {
+		log.Printf("  ⚠️  %v", err)
+	}
+}
+
+// syncArtifactText 音频的当前版本使用了与剧本不同的文本时，将文本同步到剧本
+// 该音频本身视为与剧本一致，不会被标记为需要重新生成；同步失败只记录日志，音频版本已经保存
+func (h *Handler) syncArtifactText(taskID string, a sceneArtifact, text string) {
+	task, err := h.db.GetTask(taskID)
+	if err != nil || task == nil {
+		log.Printf("  ⚠️  同步 %s 的文本到剧本失败: 查询任务失败: %v", a.filename, err)
+		return
+	}
+	_, err = h.editScript(task, func(s *novel2script.Response) error {
+		return a.setText(s, text)
+	}, a.filename)
+	if err != nil {
+		log.Printf("  ⚠️  同步 %s 的文本到剧本失败: %v", a.filename, err)
+	}
+}
+
+// lineVoice 确定重新生成音频使用的音色
+// 优先使用请求指定的音色，其次是任务音色匹配结果中该角色的音色，最后使用默认音色
+func (h *Handler) lineVoice(taskID, character, override string) (string, error) {
+	audiosDir := filepath.Join(h.outputDir, taskID, "audios")
+
+	switch h.config.ttsProvider() {
+	case "tencent":
+		if override != "" {
+			if _, err := strconv.ParseInt(override, 10, 64); err != nil {
+				return "", fmt.Errorf("腾讯云音色必须为数字 ID: %s", override)
+			}
+			return override, nil
+		}
+		if matches, err := audiosynctc.LoadVoiceMatches(audiosDir); err == nil {
+			if voice, ok := matches[character]; ok {
+				return strconv.FormatInt(voice, 10), nil
+			}
+		}
+		if character == audiosynctc.NarratorCharacter {
+			return strconv.FormatInt(audiosynctc.DefaultNarrationVoice, 10), nil
+		}
+		return strconv.FormatInt(audiosynctc.DefaultDialogueVoice, 10), nil
+	default:
+		if override != "" {
+			return override, nil
+		}
+		if matches, err := audiosync.LoadVoiceMatches(audiosDir); err == nil {
+			if voice, ok := matches[character]; ok {
+				return voice, nil
+			}
+		}
+		return audiosync.DefaultVoiceType, nil
+	}
+}
+
+// generateLineAudio 使用配置的 TTS 提供商生成单条音频
+func generateLineAudio(ctx context.Context, config *Config, text, voice, emotion string) ([]byte, error) {
+	switch provider := config.ttsProvider(); provider {
+	case "tencent":
+		voiceType, err := strconv.ParseInt(voice, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("腾讯云音色必须为数字 ID: %s", voice)
+		}
+		return audiosynctc.GenerateLine(ctx, text, voiceType, emotion, newAudiosynctcConfig(config))
+	case "qiniu":
+		// 七牛云不支持情感参数
+		return audiosync.GenerateLine(ctx, text, voice, newAudiosyncConfig(config))
+	default:
+		return nil, fmt.Errorf("不支持的TTS提供商: %s", provider)
+	}
+}
+
+// loadScene 读取任务和剧本，返回场景在剧本中的下标，出错时直接写入响应并返回 false
+// requireDone 为 true 时要求任务已完成（重新生成和切换版本只对已完成的任务开放）
+func (h *Handler) loadScene(w http.ResponseWriter, taskID string, sceneID int, requireDone bool) (*Task, *novel2script.Response, int, bool) {
+	task, err := h.db.GetTask(taskID)
+	if err != nil {
+		log.Printf("查询任务失败: %v", err)
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return nil, nil, 0, false
+	}
+	if task == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return nil, nil, 0, false
+	}
+	if requireDone && task.Status != TaskStatusDone {
+		http.Error(w, fmt.Sprintf("Task is not done, task is %s", task.Status), http.StatusConflict)
+		return nil, nil, 0, false
+	}
+
+	scriptData, err := h.taskScript(task)
+	if err != nil {
+		log.Printf("读取剧本失败: %v", err)
+		http.Error(w, "Script not available", http.StatusNotFound)
+		return nil, nil, 0, false
+	}
+	for i, scene := range scriptData.Script {
+		if scene.SceneID == sceneID {
+			if requireDone && i >= len(task.Scenes) {
+				http.Error(w, "Scene artifacts not available", http.StatusConflict)
+				return nil, nil, 0, false
+			}
+			return task, scriptData, i, true
+		}
+	}
+	http.Error(w, fmt.Sprintf("Scene %d not found", sceneID), http.StatusNotFound)
+	return nil, nil, 0, false
+}
+
+// saveOriginalVersion 以 owner 的身份将原始产物复制为 v1 并记录到版本历史，返回是否已保存
+// 本地原始文件不存在时（例如编辑剧本新增的场景、原始音频生成失败）只记录日志，新版本直接作为 v1；
+// 只有租约丢失时返回错误
+func (h *Handler) saveOriginalVersion(ctx context.Context, task *Task, owner string, a sceneArtifact) (bool, error) {
+	original := filepath.Join(h.outputDir, task.ID, a.dir, a.filename)
+	info, err := os.Stat(original)
+	if err != nil {
+		log.Printf("  ⚠️  原始产物 %s 不可用，不保存为 v1: %v", original, err)
+		return false, nil
+	}
+	data, err := os.ReadFile(original)
+	if err != nil {
+		log.Printf("  ⚠️  读取原始产物 %s 失败: %v", original, err)
+		return false, nil
+	}
+
+	record := ArtifactVersion{
+		Version:   1,
+		Filename:  versionedFilename(a.filename, 1),
+		CreatedAt: info.ModTime(),
+	}
+	if a.kind == "audio" {
+		record.Text = a.text
+		record.Emotion = a.emotion
+		if voice, err := h.lineVoice(task.ID, a.character, ""); err == nil {
+			record.Voice = voice
+		}
+	}
+	if _, err := h.saveArtifactVersion(ctx, task.ID, a.dir, record.Filename, data); err != nil {
+		log.Printf("  ⚠️  保存原始产物 %s 失败: %v", record.Filename, err)
+		return false, nil
+	}
+	if err := h.db.AddArtifactVersion(task.ID, owner, a.key(), record, nil); err != nil {
+		if errors.Is(err, errLeaseLost) {
+			return false, err
+		}
+		log.Printf("  ⚠️  %v", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// saveArtifactVersion 将版本文件写入任务工作目录并上传到存储，返回访问地址
+func (h *Handler) saveArtifactVersion(ctx context.Context, taskID, dir, filename string, data []byte) (string, error) {
+	localDir := filepath.Join(h.outputDir, taskID, dir)
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return "", fmt.Errorf("创建目录失败: %w", err)
+	}
+	localPath := filepath.Join(localDir, filename)
+	if err := writeFileAtomic(localPath, data); err != nil {
+		return "", fmt.Errorf("写入文件失败: %w", err)
+	}
+	key := artifactKey(taskID, dir, filename)
+	if err := h.store.Put(ctx, key, localPath); err != nil {
+		return "", fmt.Errorf("写入产物 %s 失败: %w", key, err)
+	}
+	return h.store.URL(key), nil
+}
+
+// artifactVersionsResponse 构建产物版本列表响应，版本按版本号升序
+func (h *Handler) artifactVersionsResponse(task *Task, a sceneArtifact) GetArtifactVersionsResponse {
+	versions := task.ArtifactVersions[a.key()]
+	resp := GetArtifactVersionsResponse{
+		SceneID:    a.sceneID,
+		Kind:       a.kind,
+		Filename:   a.filename,
+		CurrentURL: a.currentURL,
+		Active:     versions.Active,
+		Versions:   make([]ArtifactVersionInfo, 0, len(versions.Versions)),
+	}
+	for _, v := range versions.Versions {
+		url := h.store.URL(artifactKey(task.ID, a.dir, v.Filename))
+		resp.Versions = append(resp.Versions, newArtifactVersionInfo(v, url, v.Version == versions.Active))
+	}
+	sort.Slice(resp.Versions, func(i, j int) bool {
+		return resp.Versions[i].Version < resp.Versions[j].Version
+	})
+	return resp
+}
+
+// newArtifactVersionInfo 构建产物版本信息
+func newArtifactVersionInfo(v ArtifactVersion, url string, active bool) ArtifactVersionInfo {
+	return ArtifactVersionInfo{
+		Version:      v.Version,
+		Filename:     v.Filename,
+		URL:          url,
+		Prompt:       v.Prompt,
+		Instructions: v.Instructions,
+		Voice:        v.Voice,
+		Emotion:      v.Emotion,
+		Text:         v.Text,
+		CreatedAt:    v.CreatedAt,
+		Active:       active,
+	}
+}
+
+// decodeOptionalJSON 解析可为空的 JSON 请求体，出错时直接写入响应并返回 false
+func decodeOptionalJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRegenerateBodySize+1))
+	if err != nil {
+		log.Printf("读取请求体失败: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	defer r.Body.Close()
+	if len(body) > maxRegenerateBodySize {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// artifactVersionKey 产物在版本历史中的 key：子目录 + 去掉扩展名的文件名，如 images/scene_001
+// （MongoDB 字段路径中不能含有 "."）
+func artifactVersionKey(dir, filename string) string {
+	return dir + "/" + strings.TrimSuffix(filename, path.Ext(filename))
+}
+
+// versionedFilename 版本文件名，如 scene_001.png -> scene_001.v2.png
+func versionedFilename(filename string, version int) string {
+	ext := path.Ext(filename)
+	return fmt.Sprintf("%s.v%d%s", strings.TrimSuffix(filename, ext), version, ext)
+}
+
+// splitSceneAction 将 action 拆分为产物名和操作
+// 例如: image:regenerate -> image, regenerate；dialogues/2/audio/versions -> dialogues/2/audio, versions
+func splitSceneAction(action string) (string, string) {
+	if target, ok := strings.CutSuffix(action, "/versions"); ok {
+		return target, "versions"
+	}
+	if target, op, ok := strings.Cut(action, ":"); ok {
+		return target, op
+	}
+	return action, ""
+}
+
+// parseScenePath 解析 /v1/tasks/:id/scenes/:n/<action>，返回任务 ID、场景 ID 和剩余的 action
+// 例如: /v1/tasks/abc/scenes/3/image/versions -> abc, 3, image/versions
+func parseScenePath(p string) (string, int, string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/v1/tasks/"), "/", 4)
+	if len(parts) != 4 || parts[0] == "" || parts[1] != "scenes" || parts[3] == "" {
+		return "", 0, "", false
+	}
+	sceneID, err := strconv.Atoi(parts[2])
+	if err != nil || sceneID <= 0 {
+		return "", 0, "", false
+	}
+	return parts[0], sceneID, parts[3], true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TxtAnime/txt-anime/pkgs/novel2script"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSceneURLUpdateUsesArrayFilters(t *testing.T) {
+	task := &Task{ID: "task1", Scenes: []Scene{{SceneID: 3}, {SceneID: 7}}}
+	scriptData := &novel2script.Response{Script: []novel2script.Scene{
+		{SceneID: 3},
+		{SceneID: 7, NarrationVO: "旁白", Dialogue: []novel2script.DialogueLine{
+			{LineID: 4, Character: "狼", Line: "你好"},
+			{LineID: 2, Character: "小红帽", Line: "外婆"},
+		}},
+	}}
+
+	tests := []struct {
+		target      string
+		wantSet     bson.M
+		wantFilters []any
+	}{
+		{
+			"image",
+			bson.M{"scenes.$[scene].image_url": "u"},
+			[]any{bson.M{"scene.scene_id": 7}},
+		},
+		{
+			"narration",
+			bson.M{"scenes.$[scene].narration_voice_url": "u"},
+			[]any{bson.M{"scene.scene_id": 7}},
+		},
+		{
+			// line_id 2 是场景中的第二条对话，按 line_id 而不是下标定位
+			"dialogues/2/audio",
+			bson.M{"scenes.$[scene].dialogues.$[line].voice_url": "u"},
+			[]any{bson.M{"scene.scene_id": 7}, bson.M{"line.line_id": 2}},
+		},
+	}
+	for _, tt := range tests {
+		a, ok := resolveSceneArtifact(tt.target, task, scriptData, 1)
+		if !ok {
+			t.Fatalf("resolveSceneArtifact(%s) failed", tt.target)
+		}
+		set := bson.M{}
+		filters := a.sceneURL("u").apply(set)
+		if !reflect.DeepEqual(set, tt.wantSet) {
+			t.Errorf("%s: set = %v, want %v", tt.target, set, tt.wantSet)
+		}
+		if !reflect.DeepEqual(filters, tt.wantFilters) {
+			t.Errorf("%s: arrayFilters = %v, want %v", tt.target, filters, tt.wantFilters)
+		}
+	}
+}
+
+func TestNextRegenerateJob(t *testing.T) {
+	task := &Task{RegenerateJobs: []RegenerateJob{
+		{JobID: "a", Status: RegenerateJobDone},
+		{JobID: "b", Status: RegenerateJobFailed},
+		{JobID: "c", Status: RegenerateJobQueued},
+		{JobID: "d", Status: RegenerateJobQueued},
+	}}
+	if job, ok := nextRegenerateJob(task); !ok || job.JobID != "c" {
+		t.Errorf("next job = %s, %v, want c", job.JobID, ok)
+	}
+
+	// 中断时生成中的任务由新的持有者重新执行
+	task.RegenerateJobs[1].Status = RegenerateJobRunning
+	if job, ok := nextRegenerateJob(task); !ok || job.JobID != "b" {
+		t.Errorf("next job = %s, %v, want b", job.JobID, ok)
+	}
+
+	task.RegenerateJobs = task.RegenerateJobs[:1]
+	if _, ok := nextRegenerateJob(task); ok {
+		t.Error("expected no pending job")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook 请求头
+const (
+	webhookHeaderEvent     = "X-TxtAnime-Event"     // 事件类型，如 task.done
+	webhookHeaderDelivery  = "X-TxtAnime-Delivery"  // 本次通知的唯一 ID，重试时不变，可用于去重
+	webhookHeaderTimestamp = "X-TxtAnime-Timestamp" // 发送时间（Unix 秒）
+	webhookHeaderSignature = "X-TxtAnime-Signature" // sha256=HMAC-SHA256(secret, timestamp + "." + body)
+)
+
+const (
+	maxWebhookDeliveries = 20 // 任务上保留的最近投递记录数
+	webhookWorkers       = 4  // 每个实例并发投递回调的 worker 数
+)
+
+// WebhookDelivery 单次 webhook 投递记录
+type WebhookDelivery struct {
+	DeliveryID  string    `bson:"delivery_id" json:"deliveryId"`
+	Event       string    `bson:"event" json:"event"`
+	Attempt     int       `bson:"attempt" json:"attempt"`
+	AttemptedAt time.Time `bson:"attempted_at" json:"attemptedAt"`
+	StatusCode  int       `bson:"status_code,omitempty" json:"statusCode,omitempty"`
+	Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+	Success     bool      `bson:"success" json:"success"`
+	DurationMs  int64     `bson:"duration_ms" json:"durationMs"`
+}
+
+// PendingWebhook 尚未投递完的回调，记录在任务上，实例重启后由其他 worker 继续投递
+type PendingWebhook struct {
+	DeliveryID     string    `bson:"delivery_id"`
+	Event          string    `bson:"event"`
+	Body           string    `bson:"body"`                       // 请求体，重试时不变
+	Attempts       int       `bson:"attempts"`                   // 已投递次数
+	NextAttemptAt  time.Time `bson:"next_attempt_at"`            // 下次投递时间
+	LeaseOwner     string    `bson:"lease_owner,omitempty"`      // 正在投递的 worker
+	LeaseExpiresAt time.Time `bson:"lease_expires_at,omitempty"` // 投递租约过期时间
+}
+
+// WebhookPayload 任务进入终态时回调的请求体
+type WebhookPayload struct {
+	Event      string        `json:"event"` // task.done / task.failed / task.cancelled
+	TaskID     string        `json:"taskId"`
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	StatusDesc string        `json:"statusDesc"`
+	Scenes     []Scene       `json:"scenes,omitempty"` // 仅 done 时返回，URL 为完整地址
+	Error      *WebhookError `json:"error,omitempty"`  // 仅 failed 时返回
+	Timestamp  time.Time     `json:"timestamp"`
+}
+
+// WebhookError 失败信息
+type WebhookError struct {
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+}
+
+// webhookDB WebhookNotifier 使用的任务存储，见 DB 中的同名方法
+type webhookDB interface {
+	SetPendingWebhook(taskID string, pending PendingWebhook) error
+	ClaimWebhook(owner string, lease time.Duration) (*Task, error)
+	FinishWebhookAttempt(taskID, owner string, delivery WebhookDelivery, keep int, next time.Time) error
+}
+
+// WebhookNotifier 任务终态的 webhook 通知器
+// 待投递的回调记录在任务上，由后台 worker 投递，失败时按指数退避加随机抖动重试，每次尝试都记录到任务的 webhook_deliveries
+type WebhookNotifier struct {
+	db     webhookDB
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookNotifier 创建 webhook 通知器
+func NewWebhookNotifier(db webhookDB, config WebhookConfig) *WebhookNotifier {
+	return &WebhookNotifier{
+		db:     db,
+		config: config,
+		client: newWebhookClient(config),
+	}
+}
+
+// newWebhookClient 创建回调使用的 HTTP 客户端
+// 连接时检查实际拨号的 IP（包括重定向后的地址），防止域名在校验后重新解析到内网地址（DNS rebinding）
+func newWebhookClient(config WebhookConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: config.timeout()}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // 经代理转发时拨号的是代理地址，无法检查回调地址
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if config.hostAllowed(host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for i, ip := range addrs {
+			addrs[i] = ip.Unmap()
+			if !isPublicIP(addrs[i]) {
+				return nil, fmt.Errorf("回调地址 %s 解析到内网地址 %s", host, addrs[i])
+			}
+		}
+		// 直接拨号已检查的 IP，不再重新解析
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+	return &http.Client{Timeout: config.timeout(), Transport: transport}
+}
+
+// isPublicIP 是否为公网地址
+// 回环、私有网络、链路本地、运营商级 NAT、未指定和组播地址都不是公网地址
+func isPublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() &&
+		!ip.IsPrivate() &&
+		!ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace 运营商级 NAT 地址段（RFC 6598）
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Notify 任务进入终态时记录待投递的回调，由 Start 启动的 worker 在后台投递；未设置回调地址或任务未结束时忽略
+func (n *WebhookNotifier) Notify(task *Task) {
+	if task.CallbackURL == "" || !task.IsTerminal() {
+		return
+	}
+
+	payload := n.buildPayload(task)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("⚠️  序列化 webhook 请求体失败 (taskID=%s): %v", task.ID, err)
+		return
+	}
+
+	pending := PendingWebhook{
+		DeliveryID:    uuid.New().String(),
+		Event:         payload.Event,
+		Body:          string(body),
+		NextAttemptAt: time.Now(),
+	}
+	if err := n.db.SetPendingWebhook(task.ID, pending); err != nil {
+		log.Printf("⚠️  %v (taskID=%s)", err, task.ID)
+	}
+}
+
+// Start 启动回调投递 worker，实例重启前未投递完的回调同样会继续投递
+func (n *WebhookNotifier) Start(workerID string) {
+	for i := 0; i < webhookWorkers; i++ {
+		go n.worker(workerID)
+	}
+}
+
+// worker 循环投递到期的回调，没有到期的回调时等待一个轮询间隔，每次认领使用新的租约持有者
+func (n *WebhookNotifier) worker(workerID string) {
+	for {
+		claimed, err := n.deliverNext(newLeaseOwner(workerID))
+		if err != nil {
+			log.Printf("⚠️  webhook 投递失败: %v", err)
+		}
+		if !claimed || err != nil {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// lease 投递租约时长，超过单次请求超时，租约过期时投递者必然已经结束或崩溃
+func (n *WebhookNotifier) lease() time.Duration {
+	return 2*n.config.timeout() + pollInterval
+}
+
+// buildPayload 构建回调请求体
+func (n *WebhookNotifier) buildPayload(task *Task) WebhookPayload {
+	payload := WebhookPayload{
+		Event:      "task." + task.Status,
+		TaskID:     task.ID,
+		Name:       task.Name,
+		Status:     task.Status,
+		StatusDesc: task.StatusDesc,
+		Timestamp:  time.Now(),
+	}
+
+	switch task.Status {
+	case TaskStatusDone:
+		payload.Scenes = make([]Scene, 0, len(task.Scenes))
+		for _, scene := range task.Scenes {
+			scene.ImageURL = n.absoluteURL(scene.ImageURL)
+			scene.NarrationVoiceURL = n.absoluteURL(scene.NarrationVoiceURL)
+			dialogues := make([]Dialogue, len(scene.Dialogues))
+			for i, d := range scene.Dialogues {
+				d.VoiceURL = n.absoluteURL(d.VoiceURL)
+				dialogues[i] = d
+			}
+			scene.Dialogues = dialogues
+			payload.Scenes = append(payload.Scenes, scene)
+		}
+	case TaskStatusFailed:
+		payload.Error = &WebhookError{Stage: task.ErrorStage, Message: task.LastError}
+	}
+	return payload
+}
+
+// absoluteURL 为相对路径的产物 URL 加上对外访问地址
+func (n *WebhookNotifier) absoluteURL(u string) string {
+	if u == "" || !strings.HasPrefix(u, "/") || n.config.PublicBaseURL == "" {
+		return u
+	}
+	return strings.TrimSuffix(n.config.PublicBaseURL, "/") + u
+}
+
+// deliverNext 认领一个到期的回调并投递一次，没有到期的回调时返回 false
+// 失败且可以重试时按 retryBackoff 安排下次投递；成功、遇到不可重试的响应或次数用尽时投递结束
+func (n *WebhookNotifier) deliverNext(owner string) (bool, error) {
+	task, err := n.db.ClaimWebhook(owner, n.lease())
+	if err != nil {
+		return false, err
+	}
+	if task == nil || task.PendingWebhook == nil {
+		return false, nil
+	}
+
+	pending := task.PendingWebhook
+	attempt := pending.Attempts + 1
+	record, retryable := n.send(task.CallbackURL, task.CallbackSecret, pending.Event, pending.DeliveryID, []byte(pending.Body))
+	record.Attempt = attempt
+
+	var next time.Time
+	switch {
+	case record.Success:
+		log.Printf("✅ webhook 已送达: %s (taskID=%s, 第 %d 次)", pending.Event, task.ID, attempt)
+	case retryable && attempt < n.config.MaxAttempts:
+		delay := n.config.retryBackoff(attempt)
+		next = time.Now().Add(delay)
+		log.Printf("⚠️  webhook 投递失败: %s (taskID=%s, 第 %d/%d 次)，%v 后重试: %s", pending.Event, task.ID, attempt, n.config.MaxAttempts, delay.Round(time.Second), record.Error)
+	default:
+		log.Printf("⚠️  webhook 投递失败: %s (taskID=%s, 第 %d/%d 次): %s", pending.Event, task.ID, attempt, n.config.MaxAttempts, record.Error)
+		log.Printf("❌ webhook 投递放弃: %s (taskID=%s)", pending.Event, task.ID)
+	}
+	return true, n.db.FinishWebhookAttempt(task.ID, owner, record, maxWebhookDeliveries, next)
+}
+
+// send 发送一次回调请求，返回投递记录以及失败时是否值得重试
+func (n *WebhookNotifier) send(callbackURL, secret, event, deliveryID string, body []byte) (WebhookDelivery, bool) {
+	start := time.Now()
+	record := WebhookDelivery{
+		DeliveryID:  deliveryID,
+		Event:       event,
+		AttemptedAt: start,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		record.Error = fmt.Sprintf("创建请求失败: %v", err)
+		return record, false
+	}
+
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "novel2comicd-webhook")
+	req.Header.Set(webhookHeaderEvent, event)
+	req.Header.Set(webhookHeaderDelivery, deliveryID)
+	req.Header.Set(webhookHeaderTimestamp, timestamp)
+	if secret != "" {
+		req.Header.Set(webhookHeaderSignature, "sha256="+signWebhook(secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	record.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		record.Error = fmt.Sprintf("请求失败: %v", err)
+		return record, true
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	record.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		record.Success = true
+		return record, false
+	}
+
+	record.Error = fmt.Sprintf("响应状态码 %d", resp.StatusCode)
+	retryable := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return record, retryable
+}
+
+// signWebhook 计算回调签名：hex(HMAC-SHA256(secret, timestamp + "." + body))
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateCallbackURL 校验回调地址，只允许 http/https 绝对地址
+// 不在 allowed_hosts 中的主机不能是 localhost 或内网 IP；域名解析到的地址在每次连接时检查（见 newWebhookClient）
+func validateCallbackURL(raw string, config WebhookConfig) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("回调地址格式错误: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("回调地址只支持 http/https")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("回调地址缺少主机名")
+	}
+	if config.hostAllowed(host) {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("回调地址不能是内网地址: %s", host)
+		}
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("回调地址不能是内网地址: %s", host)
+	}
+	return nil
+}
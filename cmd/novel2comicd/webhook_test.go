@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeWebhookDB 内存中的 webhookDB，只保存一个任务，不检查投递时间和租约
+type fakeWebhookDB struct {
+	mu   sync.Mutex
+	task Task
+}
+
+func (db *fakeWebhookDB) SetPendingWebhook(taskID string, pending PendingWebhook) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.task.PendingWebhook = &pending
+	return nil
+}
+
+func (db *fakeWebhookDB) ClaimWebhook(owner string, lease time.Duration) (*Task, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.task.PendingWebhook == nil {
+		return nil, nil
+	}
+	task := db.task
+	pending := *db.task.PendingWebhook
+	pending.LeaseOwner = owner
+	task.PendingWebhook = &pending
+	return &task, nil
+}
+
+func (db *fakeWebhookDB) FinishWebhookAttempt(taskID, owner string, delivery WebhookDelivery, keep int, next time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.task.WebhookDeliveries = append(db.task.WebhookDeliveries, delivery)
+	if n := len(db.task.WebhookDeliveries); n > keep {
+		db.task.WebhookDeliveries = db.task.WebhookDeliveries[n-keep:]
+	}
+	if next.IsZero() {
+		db.task.PendingWebhook = nil
+		return nil
+	}
+	db.task.PendingWebhook.Attempts = delivery.Attempt
+	db.task.PendingWebhook.NextAttemptAt = next
+	return nil
+}
+
+// pending 返回当前的待投递回调
+func (db *fakeWebhookDB) pending() *PendingWebhook {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.task.PendingWebhook == nil {
+		return nil
+	}
+	p := *db.task.PendingWebhook
+	return &p
+}
+
+// deliveries 返回投递记录
+func (db *fakeWebhookDB) deliveries() []WebhookDelivery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]WebhookDelivery(nil), db.task.WebhookDeliveries...)
+}
+
+// testWebhookConfig 允许回调 httptest 服务（127.0.0.1）的配置
+func testWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		MaxAttempts:       3,
+		BackoffSeconds:    1,
+		MaxBackoffSeconds: 4,
+		TimeoutSeconds:    5,
+		AllowedHosts:      []string{"127.0.0.1"},
+	}
+}
+
+// newTestNotifier 创建回调到 callbackURL 的已完成任务，并记录待投递的回调
+func newTestNotifier(t *testing.T, config WebhookConfig, callbackURL, secret string) (*WebhookNotifier, *fakeWebhookDB) {
+	t.Helper()
+	db := &fakeWebhookDB{task: Task{
+		ID:             "task1",
+		Name:           "小红帽",
+		Status:         TaskStatusDone,
+		CallbackURL:    callbackURL,
+		CallbackSecret: secret,
+	}}
+	n := NewWebhookNotifier(db, config)
+	n.Notify(&db.task)
+	if db.pending() == nil {
+		t.Fatal("Notify did not record a pending delivery")
+	}
+	return n, db
+}
+
+func TestWebhookSignature(t *testing.T) {
+	var (
+		header http.Header
+		body   []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	n, db := newTestNotifier(t, testWebhookConfig(), srv.URL, "s3cret")
+	want := db.pending()
+	if claimed, err := n.deliverNext("worker1"); !claimed || err != nil {
+		t.Fatalf("deliverNext = %v, %v", claimed, err)
+	}
+
+	if string(body) != want.Body {
+		t.Errorf("body = %s, want %s", body, want.Body)
+	}
+	timestamp := header.Get(webhookHeaderTimestamp)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	if got, want := header.Get(webhookHeaderSignature), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if got := header.Get(webhookHeaderEvent); got != "task.done" {
+		t.Errorf("event header = %s", got)
+	}
+	if got := header.Get(webhookHeaderDelivery); got != want.DeliveryID {
+		t.Errorf("delivery header = %s, want %s", got, want.DeliveryID)
+	}
+
+	if db.pending() != nil {
+		t.Error("pending delivery not removed after success")
+	}
+	deliveries := db.deliveries()
+	if len(deliveries) != 1 || !deliveries[0].Success || deliveries[0].StatusCode != http.StatusOK || deliveries[0].Attempt != 1 {
+		t.Errorf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestWebhookNoSignatureWithoutSecret(t *testing.T) {
+	var signature atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature.Store(r.Header.Get(webhookHeaderSignature))
+	}))
+	defer srv.Close()
+
+	n, _ := newTestNotifier(t, testWebhookConfig(), srv.URL, "")
+	if _, err := n.deliverNext("worker1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := signature.Load(); got != "" {
+		t.Errorf("signature = %v, want none", got)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadRequest, false},
+		{http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			var hits atomic.Int32
+			deliveryIDs := make(chan string, 10)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				deliveryIDs <- r.Header.Get(webhookHeaderDelivery)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			config := testWebhookConfig()
+			n, db := newTestNotifier(t, config, srv.URL, "")
+
+			for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+				before := time.Now()
+				if claimed, err := n.deliverNext("worker1"); !claimed || err != nil {
+					t.Fatalf("attempt %d: deliverNext = %v, %v", attempt, claimed, err)
+				}
+				pending := db.pending()
+				if !tt.retryable || attempt == config.MaxAttempts {
+					if pending != nil {
+						t.Fatalf("attempt %d: delivery still pending", attempt)
+					}
+					break
+				}
+				if pending == nil {
+					t.Fatalf("attempt %d: retryable failure was not rescheduled", attempt)
+				}
+				if pending.Attempts != attempt {
+					t.Errorf("attempt %d: attempts = %d", attempt, pending.Attempts)
+				}
+				// 第 attempt 次失败后等待 base*2^(attempt-1) 的一半到全部
+				base := time.Duration(config.BackoffSeconds) * time.Second << (attempt - 1)
+				delay := pending.NextAttemptAt.Sub(before)
+				if delay < base/2 || delay > base+time.Second {
+					t.Errorf("attempt %d: backoff = %v, want between %v and %v", attempt, delay, base/2, base)
+				}
+			}
+
+			wantHits := 1
+			if tt.retryable {
+				wantHits = config.MaxAttempts
+			}
+			if got := int(hits.Load()); got != wantHits {
+				t.Errorf("server hit %d times, want %d", got, wantHits)
+			}
+			close(deliveryIDs)
+			first := <-deliveryIDs
+			for id := range deliveryIDs {
+				if id != first {
+					t.Errorf("delivery ID changed between retries: %s, %s", first, id)
+				}
+			}
+			deliveries := db.deliveries()
+			if len(deliveries) != wantHits {
+				t.Fatalf("got %d delivery records, want %d", len(deliveries), wantHits)
+			}
+			for i, d := range deliveries {
+				if d.Success || d.StatusCode != tt.status || d.Attempt != i+1 {
+					t.Errorf("delivery %d = %+v", i, d)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookDeliveryLogCapped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	config := testWebhookConfig()
+	config.MaxAttempts = maxWebhookDeliveries + 5
+	n, db := newTestNotifier(t, config, srv.URL, "")
+	for i := 0; i < config.MaxAttempts; i++ {
+		if _, err := n.deliverNext("worker1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries := db.deliveries()
+	if len(deliveries) != maxWebhookDeliveries {
+		t.Fatalf("got %d delivery records, want %d", len(deliveries), maxWebhookDeliveries)
+	}
+	if first, last := deliveries[0].Attempt, deliveries[len(deliveries)-1].Attempt; first != 6 || last != config.MaxAttempts {
+		t.Errorf("kept attempts %d..%d, want 6..%d", first, last, config.MaxAttempts)
+	}
+}
+
+func TestWebhookBuildPayloadAbsoluteURLs(t *testing.T) {
+	n := NewWebhookNotifier(&fakeWebhookDB{}, WebhookConfig{PublicBaseURL: "https://comic.example.com/"})
+	task := &Task{
+		ID:     "task1",
+		Status: TaskStatusDone,
+		Scenes: []Scene{{
+			SceneID:           1,
+			ImageURL:          "/artifacts/task1/images/scene_001.png",
+			NarrationVoiceURL: "https://cdn.example.com/task1/audios/scene_001_narration.mp3",
+			Dialogues: []Dialogue{
+				{LineID: 1, VoiceURL: "/artifacts/task1/audios/scene_001_dialogue_001.mp3"},
+				{LineID: 2},
+			},
+		}},
+	}
+
+	payload := n.buildPayload(task)
+	if payload.Event != "task.done" || payload.TaskID != "task1" || payload.Error != nil {
+		t.Errorf("payload = %+v", payload)
+	}
+	if len(payload.Scenes) != 1 || len(payload.Scenes[0].Dialogues) != 2 {
+		t.Fatalf("scenes = %+v", payload.Scenes)
+	}
+	scene := payload.Scenes[0]
+	checks := []struct {
+		name, got, want string
+	}{
+		{"image", scene.ImageURL, "https://comic.example.com/artifacts/task1/images/scene_001.png"},
+		{"narration", scene.NarrationVoiceURL, "https://cdn.example.com/task1/audios/scene_001_narration.mp3"},
+		{"line 1", scene.Dialogues[0].VoiceURL, "https://comic.example.com/artifacts/task1/audios/scene_001_dialogue_001.mp3"},
+		{"line 2", scene.Dialogues[1].VoiceURL, ""},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s URL = %q, want %q", c.name, c.got, c.want)
+		}
+	}
+	if task.Scenes[0].ImageURL != "/artifacts/task1/images/scene_001.png" || task.Scenes[0].Dialogues[0].VoiceURL != "/artifacts/task1/audios/scene_001_dialogue_001.mp3" {
+		t.Error("buildPayload modified the task scenes")
+	}
+
+	failed := n.buildPayload(&Task{ID: "task2", Status: TaskStatusFailed, ErrorStage: "images", LastError: "boom"})
+	if failed.Event != "task.failed" || failed.Scenes != nil || failed.Error == nil || failed.Error.Stage != "images" || failed.Error.Message != "boom" {
+		t.Errorf("failed payload = %+v", failed)
+	}
+	if data, _ := json.Marshal(failed); strings.Contains(string(data), `"scenes"`) {
+		t.Errorf("failed payload contains scenes: %s", data)
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	allowlist := WebhookConfig{AllowedHosts: []string{"hooks.internal", "127.0.0.1"}}
+	tests := []struct {
+		url    string
+		config WebhookConfig
+		ok     bool
+	}{
+		{"https://example.com/hook", WebhookConfig{}, true},
+		{"http://93.184.216.34:8080/hook", WebhookConfig{}, true},
+		{"ftp://example.com/hook", WebhookConfig{}, false},
+		{"https:///hook", WebhookConfig{}, false},
+		{"http://localhost:8080/hook", WebhookConfig{}, false},
+		{"http://api.localhost/hook", WebhookConfig{}, false},
+		{"http://127.0.0.1/hook", WebhookConfig{}, false},
+		{"http://10.0.0.8/hook", WebhookConfig{}, false},
+		{"http://192.168.1.10/hook", WebhookConfig{}, false},
+		{"http://169.254.169.254/latest/meta-data", WebhookConfig{}, false},
+		{"http://100.64.0.1/hook", WebhookConfig{}, false},
+		{"http://0.0.0.0/hook", WebhookConfig{}, false},
+		{"http://[::1]/hook", WebhookConfig{}, false},
+		{"http://[fd00::1]/hook", WebhookConfig{}, false},
+		{"http://[::ffff:127.0.0.1]/hook", WebhookConfig{}, false},
+		{"http://127.0.0.1:9000/hook", allowlist, true},
+		{"http://HOOKS.internal/hook", allowlist, true},
+		{"http://10.0.0.8/hook", allowlist, false},
+	}
+	for _, tt := range tests {
+		err := validateCallbackURL(tt.url, tt.config)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateCallbackURL(%s) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestWebhookClientRejectsInternalAddresses(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	// 未加入 allowed_hosts 时，IP 和解析到回环地址的域名都在连接时被拒绝
+	config := testWebhookConfig()
+	config.AllowedHosts = nil
+	urls := []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)}
+	for _, u := range urls {
+		n, db := newTestNotifier(t, config, u, "")
+		if _, err := n.deliverNext("worker1"); err != nil {
+			t.Fatal(err)
+		}
+		deliveries := db.deliveries()
+		if len(deliveries) != 1 || deliveries[0].Success || !strings.Contains(deliveries[0].Error, "内网地址") {
+			t.Errorf("%s: deliveries = %+v", u, deliveries)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Errorf("internal server was hit %d times", hits.Load())
+	}
+}
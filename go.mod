@@ -9,6 +9,8 @@ require (
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common v1.1.27
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tts v1.1.27
 	go.mongodb.org/mongo-driver v1.17.4
+	golang.org/x/image v0.20.0
+	golang.org/x/time v0.6.0
 )
 
 require (
@@ -26,6 +28,6 @@ require (
 	golang.org/x/crypto v0.26.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
 	golang.org/x/sys v0.23.0 // indirect
-	golang.org/x/text v0.17.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
 	modernc.org/fileutil v1.0.0 // indirect
 )
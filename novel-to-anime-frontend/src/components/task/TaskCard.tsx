@@ -1,5 +1,6 @@
 import { useState, useRef, useEffect } from 'react';
 import type { Task } from '../../types';
+import { getTaskProgressPercent, isTaskActive } from '../../utils';
 
 interface TaskCardProps {
   task: Task;
@@ -27,7 +28,8 @@ export const TaskCard = ({ task, onSelect, onDelete, isSelected = false }: TaskC
   }, [showMenu]);
   const getStatusConfig = (status: string) => {
     switch (status) {
-      case 'doing':
+      case 'queued':
+      case 'running':
         return {
           barColor: 'linear-gradient(90deg, #f59e0b, #ea580c)',
           bgColor: '#fef3c7',
@@ -41,6 +43,20 @@ export const TaskCard = ({ task, onSelect, onDelete, isSelected = false }: TaskC
           ),
           label: 'Processing'
         };
+      case 'awaiting_review':
+        return {
+          barColor: 'linear-gradient(90deg, #3b82f6, #6366f1)',
+          bgColor: '#dbeafe',
+          textColor: '#1e40af',
+          borderColor: '#bfdbfe',
+          icon: (
+            <svg style={{ width: '16px', height: '16px', color: '#2563eb' }} fill="none" stroke="currentColor" viewBox="0 0 24 24">
+              <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M15 12a3 3 0 11-6 0 3 3 0 016 0z" />
+              <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M2.458 12C3.732 7.943 7.523 5 12 5c4.478 0 8.268 2.943 9.542 7-1.274 4.057-5.064 7-9.542 7-4.477 0-8.268-2.943-9.542-7z" />
+            </svg>
+          ),
+          label: 'Awaiting review'
+        };
       case 'done':
         return {
           barColor: 'linear-gradient(90deg, #10b981, #059669)',
@@ -54,6 +70,19 @@ export const TaskCard = ({ task, onSelect, onDelete, isSelected = false }: TaskC
           ),
           label: 'Complete'
         };
+      case 'failed':
+        return {
+          barColor: 'linear-gradient(90deg, #ef4444, #dc2626)',
+          bgColor: '#fee2e2',
+          textColor: '#991b1b',
+          borderColor: '#fecaca',
+          icon: (
+            <svg style={{ width: '16px', height: '16px', color: '#dc2626' }} fill="currentColor" viewBox="0 0 20 20">
+              <path fillRule="evenodd" d="M4.293 4.293a1 1 0 011.414 0L10 8.586l4.293-4.293a1 1 0 111.414 1.414L11.414 10l4.293 4.293a1 1 0 01-1.414 1.414L10 11.414l-4.293 4.293a1 1 0 01-1.414-1.414L8.586 10 4.293 5.707a1 1 0 010-1.414z" clipRule="evenodd" />
+            </svg>
+          ),
+          label: 'Failed'
+        };
       default:
         return {
           barColor: 'linear-gradient(90deg, #9ca3af, #6b7280)',
@@ -140,24 +169,37 @@ export const TaskCard = ({ task, onSelect, onDelete, isSelected = false }: TaskC
         </div>
         
         {/* Progress section for processing tasks */}
-        {task.status === 'doing' && (
+        {isTaskActive(task.status) && (
           <div style={{ marginTop: '8px' }}>
             <div style={{ display: 'flex', alignItems: 'center', justifyContent: 'space-between', marginBottom: '6px' }}>
               <span style={{ fontSize: '12px', color: '#4b5563' }}>
                 {task.statusDesc || 'Generation in progress'}
               </span>
+              <span style={{ fontSize: '12px', color: '#6b7280' }}>
+                {getTaskProgressPercent(task.progress)}%
+              </span>
             </div>
             <div style={{ width: '100%', backgroundColor: '#e5e7eb', borderRadius: '9999px', height: '6px' }}>
               <div style={{ 
                 height: '100%', 
                 background: 'linear-gradient(90deg, #fbbf24, #f59e0b)', 
                 borderRadius: '9999px',
-                width: '60%'
+                width: `${getTaskProgressPercent(task.progress)}%`,
+                transition: 'width 0.3s ease'
               }}></div>
             </div>
           </div>
         )}
 
+        {/* Error section for failed tasks */}
+        {task.status === 'failed' && (
+          <div style={{ marginTop: '8px' }}>
+            <span style={{ fontSize: '12px', color: '#991b1b' }}>
+              {task.lastError || task.statusDesc || 'Generation failed'}
+            </span>
+          </div>
+        )}
+
         {/* Action section for completed tasks */}
         {task.status === 'done' && (
           <div style={{ 
@@ -259,7 +301,7 @@ export const TaskCard = ({ task, onSelect, onDelete, isSelected = false }: TaskC
         )}
 
         {/* Menu button for processing tasks - positioned at bottom right */}
-        {task.status === 'doing' && (
+        {isTaskActive(task.status) && (
           <div style={{ 
             display: 'flex', 
             justifyContent: 'flex-end', 
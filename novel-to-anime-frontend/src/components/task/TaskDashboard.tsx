@@ -2,6 +2,7 @@ import { useEffect } from 'react';
 import { TaskList } from './TaskList';
 import { useTasks } from '../../hooks/useTasks';
 import type { Task } from '../../types';
+import { isTaskActive } from '../../utils';
 
 interface TaskDashboardProps {
   onTaskSelect?: (task: Task) => void;
@@ -16,26 +17,27 @@ export const TaskDashboard = ({
   autoRefresh = true,
   refreshInterval = 5000 
 }: TaskDashboardProps) => {
-  const { tasks, startPolling } = useTasks();
+  const { tasks, startPolling, subscribeTaskEvents } = useTasks();
 
-  // Auto-refresh for tasks in progress
-  useEffect(() => {
-    if (!autoRefresh) return;
+  // Only resubscribe when the set of active tasks changes, not on every progress update
+  const activeTaskIds = tasks.filter(task => isTaskActive(task.status)).map(task => task.id).join(',');
 
-    const activeTasks = tasks.filter(task => task.status === 'doing');
-    const cleanupFunctions: (() => void)[] = [];
+  // Live updates for tasks in progress, falling back to polling without EventSource support
+  useEffect(() => {
+    if (!autoRefresh || !activeTaskIds) return;
 
-    activeTasks.forEach(task => {
-      const cleanup = startPolling(task.id, refreshInterval);
-      cleanupFunctions.push(cleanup);
-    });
+    const cleanupFunctions = activeTaskIds.split(',').map(taskId =>
+      typeof EventSource !== 'undefined'
+        ? subscribeTaskEvents(taskId)
+        : startPolling(taskId, refreshInterval)
+    );
 
     return () => {
       cleanupFunctions.forEach(cleanup => cleanup());
     };
-  }, [tasks, autoRefresh, refreshInterval, startPolling]);
+  }, [activeTaskIds, autoRefresh, refreshInterval, startPolling, subscribeTaskEvents]);
 
-  const inProgressCount = tasks.filter(t => t.status === 'doing').length;
+  const inProgressCount = tasks.filter(t => isTaskActive(t.status)).length;
   const completedCount = tasks.filter(t => t.status === 'done').length;
 
   return (
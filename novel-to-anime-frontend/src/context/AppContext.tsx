@@ -48,7 +48,8 @@ const appReducer = (state: AppState, action: AppAction): AppState => {
             ? { 
                 ...task, 
                 status: action.payload.status,
-                ...(action.payload.statusDesc && { statusDesc: action.payload.statusDesc })
+                ...(action.payload.statusDesc && { statusDesc: action.payload.statusDesc }),
+                ...(action.payload.progress && { progress: action.payload.progress })
               }
             : task
         ),
@@ -61,6 +62,23 @@ const appReducer = (state: AppState, action: AppAction): AppState => {
           : state.currentTask,
       };
 
+    case 'UPDATE_TASK_PROGRESS':
+      return {
+        ...state,
+        tasks: state.tasks.map(task =>
+          task.id === action.payload.id
+            ? {
+                ...task,
+                statusDesc: action.payload.statusDesc,
+                progress: {
+                  stage: action.payload.stage,
+                  stages: { ...task.progress?.stages, [action.payload.stage]: action.payload.progress },
+                },
+              }
+            : task
+        ),
+      };
+
     case 'DELETE_TASK':
       return {
         ...state,
@@ -1,8 +1,8 @@
 import { useCallback, useEffect } from 'react';
 import { useAppContext } from '../context/AppContext';
 import { TaskService, handleApiError } from '../services/api';
-import { storage, STORAGE_KEYS } from '../utils';
-import type { Task } from '../types';
+import { storage, STORAGE_KEYS, isTaskTerminal } from '../utils';
+import type { GetTaskResponse, Task, TaskProgressEvent } from '../types';
 
 export const useTasks = () => {
   const { state, dispatch } = useAppContext();
@@ -13,7 +13,7 @@ export const useTasks = () => {
       dispatch({ type: 'SET_LOADING', payload: true });
       dispatch({ type: 'SET_ERROR', payload: null });
 
-      const response = await TaskService.getTasks();
+      const response = await TaskService.getTasks({ sort: '-createdAt', limit: 200 });
       const tasks = response.tasks.map(task => ({
         ...task,
         name: task.name || `Project ${task.id.substring(0, 8)}`,
@@ -21,10 +21,8 @@ export const useTasks = () => {
         createdAt: new Date(), // API doesn't provide creation time, using current time
       }));
 
-      // Reverse the array to show the last task (newest) first
-      const sortedTasks = tasks.reverse();
-
-      dispatch({ type: 'SET_TASKS', payload: sortedTasks });
+      // Tasks come back newest first
+      dispatch({ type: 'SET_TASKS', payload: tasks });
       
       // Save to localStorage
       storage.setItem(STORAGE_KEYS.TASKS, tasks.map(t => t.id));
@@ -46,7 +44,7 @@ export const useTasks = () => {
       const newTask: Task = {
         id: response.id,
         name,
-        status: 'doing',
+        status: 'queued',
         statusDesc: '任务创建中',
         createdAt: new Date(),
       };
@@ -78,7 +76,7 @@ export const useTasks = () => {
         createdAt: new Date(), // API doesn't provide creation time
       };
 
-      dispatch({ type: 'UPDATE_TASK', payload: { id, status: response.status, statusDesc: response.statusDesc } });
+      dispatch({ type: 'UPDATE_TASK', payload: { id, status: response.status, statusDesc: response.statusDesc, progress: response.progress } });
       return task;
     } catch (error) {
       const apiError = handleApiError(error);
@@ -132,7 +130,7 @@ export const useTasks = () => {
     const pollTask = async () => {
       try {
         const task = await getTask(taskId);
-        if (task.status === 'done') {
+        if (isTaskTerminal(task.status)) {
           return true; // Stop polling
         }
       } catch (error) {
@@ -151,6 +149,33 @@ export const useTasks = () => {
     return () => clearInterval(intervalId);
   }, [getTask]);
 
+  // Subscribe to live task events, the browser reconnects with Last-Event-ID on its own
+  const subscribeTaskEvents = useCallback((taskId: string) => {
+    const source = new EventSource(TaskService.getTaskEventsUrl(taskId));
+
+    const onStatus = (event: MessageEvent) => {
+      const task: GetTaskResponse = JSON.parse(event.data);
+      dispatch({
+        type: 'UPDATE_TASK',
+        payload: { id: taskId, status: task.status, statusDesc: task.statusDesc, progress: task.progress },
+      });
+      if (isTaskTerminal(task.status)) {
+        source.close();
+      }
+    };
+
+    const onProgress = (event: MessageEvent) => {
+      const progress: TaskProgressEvent = JSON.parse(event.data);
+      dispatch({ type: 'UPDATE_TASK_PROGRESS', payload: { id: taskId, ...progress } });
+    };
+
+    source.addEventListener('snapshot', onStatus);
+    source.addEventListener('status', onStatus);
+    source.addEventListener('progress', onProgress);
+
+    return () => source.close();
+  }, [dispatch]);
+
   // Load tasks on mount
   useEffect(() => {
     loadTasks();
@@ -167,5 +192,6 @@ export const useTasks = () => {
     deleteTask,
     setCurrentTask,
     startPolling,
+    subscribeTaskEvents,
   };
 };
\ No newline at end of file
@@ -3,10 +3,31 @@ import type { AxiosInstance, AxiosResponse } from 'axios';
 import type {
   CreateTaskRequest,
   CreateTaskResponse,
+  CreateProjectResponse,
+  GetProjectResponse,
+  ParseChaptersResponse,
+  ListStylesResponse,
+  SplitOptions,
   GetTaskResponse,
   GetTasksResponse,
+  GetTasksParams,
   DeleteTaskResponse,
   AnimeArtifacts,
+  ComposePagesResponse,
+  ExportStripRequest,
+  ExportStripResponse,
+  TaskScript,
+  SceneEdit,
+  InsertSceneRequest,
+  DialogueEdit,
+  InsertDialogueRequest,
+  CharacterEdit,
+  LocationEdit,
+  RegenerateImageRequest,
+  RegenerateAudioRequest,
+  AudioTarget,
+  ArtifactVersion,
+  ArtifactVersions,
 } from '../types';
 
 // API configuration
@@ -58,13 +79,23 @@ class ApiClient {
     );
   }
 
-  async get<T>(url: string): Promise<T> {
-    const response: AxiosResponse<T> = await this.client.get(url);
+  async get<T>(url: string, params?: Record<string, unknown>): Promise<T> {
+    const response: AxiosResponse<T> = await this.client.get(url, { params });
     return response.data;
   }
 
-  async post<T, D = any>(url: string, data?: D): Promise<T> {
-    const response: AxiosResponse<T> = await this.client.post(url, data);
+  async post<T, D = any>(url: string, data?: D, timeout?: number): Promise<T> {
+    const response: AxiosResponse<T> = await this.client.post(url, data, timeout ? { timeout } : undefined);
+    return response.data;
+  }
+
+  async put<T, D = any>(url: string, data?: D): Promise<T> {
+    const response: AxiosResponse<T> = await this.client.put(url, data);
+    return response.data;
+  }
+
+  async patch<T, D = any>(url: string, data?: D): Promise<T> {
+    const response: AxiosResponse<T> = await this.client.patch(url, data);
     return response.data;
   }
 
@@ -74,6 +105,12 @@ class ApiClient {
   }
 }
 
+// Path of a scene audio artifact, without the action suffix
+const audioPath = (id: string, sceneId: number, target: AudioTarget): string =>
+  target.kind === 'narration'
+    ? `/v1/tasks/${id}/scenes/${sceneId}/narration`
+    : `/v1/tasks/${id}/scenes/${sceneId}/dialogues/${target.lineId}/audio`;
+
 // Create API client instance
 const apiClient = new ApiClient(API_BASE_URL);
 
@@ -82,11 +119,40 @@ export class TaskService {
   /**
    * Create a new conversion task
    */
-  static async createTask(name: string, novel: string): Promise<CreateTaskResponse> {
-    const request: CreateTaskRequest = { name, novel };
+  static async createTask(name: string, novel: string, style?: string): Promise<CreateTaskResponse> {
+    const request: CreateTaskRequest = { name, novel, style };
     return apiClient.post<CreateTaskResponse>('/v1/tasks/', request);
   }
 
+  /**
+   * Split a novel by chapters into a project of sequential tasks
+   */
+  static async createProject(name: string, novel: string, split: SplitOptions): Promise<CreateProjectResponse> {
+    const request: CreateTaskRequest = { name, novel, split };
+    return apiClient.post<CreateProjectResponse>('/v1/tasks/', request);
+  }
+
+  /**
+   * List the art style presets that can be chosen when creating a task
+   */
+  static async listStyles(): Promise<ListStylesResponse> {
+    return apiClient.get<ListStylesResponse>('/v1/styles');
+  }
+
+  /**
+   * Preview the chapters detected in a novel without creating tasks
+   */
+  static async parseChapters(novel: string): Promise<ParseChaptersResponse> {
+    return apiClient.post<ParseChaptersResponse>('/v1/chapters', { novel });
+  }
+
+  /**
+   * Get a project with its chapters, shared characters and tasks
+   */
+  static async getProject(id: string): Promise<GetProjectResponse> {
+    return apiClient.get<GetProjectResponse>(`/v1/projects/${id}`);
+  }
+
   /**
    * Get task details by ID
    */
@@ -95,10 +161,14 @@ export class TaskService {
   }
 
   /**
-   * Get all tasks
+   * List tasks with optional filters, sorting and pagination
    */
-  static async getTasks(): Promise<GetTasksResponse> {
-    return apiClient.get<GetTasksResponse>('/v1/tasks/');
+  static async getTasks(params: GetTasksParams = {}): Promise<GetTasksResponse> {
+    const { status, ...rest } = params;
+    return apiClient.get<GetTasksResponse>('/v1/tasks/', {
+      ...rest,
+      ...(status && status.length > 0 && { status: status.join(',') }),
+    });
   }
 
   /**
@@ -108,6 +178,174 @@ export class TaskService {
     return apiClient.get<AnimeArtifacts>(`/v1/tasks/${id}/artifacts`);
   }
 
+  /**
+   * Re-compose the comic pages from the current script and scene images
+   */
+  static async composePages(id: string): Promise<ComposePagesResponse> {
+    return apiClient.post<ComposePagesResponse>(`/v1/tasks/${id}/pages`, undefined, 180000);
+  }
+
+  /**
+   * Export the scene images as a vertical webtoon strip sliced into segments
+   */
+  static async exportStrip(id: string, request: ExportStripRequest = {}): Promise<ExportStripResponse> {
+    return apiClient.post<ExportStripResponse>(`/v1/tasks/${id}/strip`, request, 180000);
+  }
+
+  /**
+   * Regenerate the image of a single scene; waits for the image to be generated
+   */
+  static async regenerateSceneImage(id: string, sceneId: number, request: RegenerateImageRequest = {}): Promise<ArtifactVersion> {
+    return apiClient.post<ArtifactVersion>(`/v1/tasks/${id}/scenes/${sceneId}/image:regenerate`, request, 180000);
+  }
+
+  /**
+   * List the image versions of a scene
+   */
+  static async getSceneImageVersions(id: string, sceneId: number): Promise<ArtifactVersions> {
+    return apiClient.get<ArtifactVersions>(`/v1/tasks/${id}/scenes/${sceneId}/image/versions`);
+  }
+
+  /**
+   * Select which image version of a scene is returned in the artifacts
+   */
+  static async activateSceneImageVersion(id: string, sceneId: number, version: number): Promise<ArtifactVersions> {
+    return apiClient.post<ArtifactVersions>(`/v1/tasks/${id}/scenes/${sceneId}/image:activate`, { version });
+  }
+
+  /**
+   * Regenerate a single narration or dialogue clip; waits for the audio to be generated
+   */
+  static async regenerateSceneAudio(id: string, sceneId: number, target: AudioTarget, request: RegenerateAudioRequest = {}): Promise<ArtifactVersion> {
+    return apiClient.post<ArtifactVersion>(`${audioPath(id, sceneId, target)}:regenerate`, request, 60000);
+  }
+
+  /**
+   * List the takes of a narration or dialogue clip
+   */
+  static async getSceneAudioVersions(id: string, sceneId: number, target: AudioTarget): Promise<ArtifactVersions> {
+    return apiClient.get<ArtifactVersions>(`${audioPath(id, sceneId, target)}/versions`);
+  }
+
+  /**
+   * Roll a narration or dialogue clip back (or forward) to another take
+   */
+  static async activateSceneAudioVersion(id: string, sceneId: number, target: AudioTarget, version: number): Promise<ArtifactVersions> {
+    return apiClient.post<ArtifactVersions>(`${audioPath(id, sceneId, target)}:activate`, { version });
+  }
+
+  /**
+   * Get the generated script of a task
+   */
+  static async getTaskScript(id: string): Promise<TaskScript> {
+    return apiClient.get<TaskScript>(`/v1/tasks/${id}/script`);
+  }
+
+  /**
+   * Replace the script of a task that is awaiting review or done
+   */
+  static async updateTaskScript(id: string, script: TaskScript): Promise<TaskScript> {
+    return apiClient.put<TaskScript>(`/v1/tasks/${id}/script`, script);
+  }
+
+  /**
+   * Insert a scene into the script
+   */
+  static async insertScriptScene(id: string, scene: InsertSceneRequest): Promise<TaskScript> {
+    return apiClient.post<TaskScript>(`/v1/tasks/${id}/script/scenes`, scene);
+  }
+
+  /**
+   * Change fields of a scene
+   */
+  static async updateScriptScene(id: string, sceneId: number, edit: SceneEdit): Promise<TaskScript> {
+    return apiClient.patch<TaskScript>(`/v1/tasks/${id}/script/scenes/${sceneId}`, edit);
+  }
+
+  /**
+   * Delete a scene
+   */
+  static async deleteScriptScene(id: string, sceneId: number): Promise<TaskScript> {
+    return apiClient.delete<TaskScript>(`/v1/tasks/${id}/script/scenes/${sceneId}`);
+  }
+
+  /**
+   * Reorder scenes; order must contain every scene ID exactly once
+   */
+  static async reorderScriptScenes(id: string, order: number[]): Promise<TaskScript> {
+    return apiClient.put<TaskScript>(`/v1/tasks/${id}/script/scenes/order`, { order });
+  }
+
+  /**
+   * Insert a dialogue line into a scene
+   */
+  static async insertScriptDialogue(id: string, sceneId: number, line: InsertDialogueRequest): Promise<TaskScript> {
+    return apiClient.post<TaskScript>(`/v1/tasks/${id}/script/scenes/${sceneId}/dialogues`, line);
+  }
+
+  /**
+   * Change fields of a dialogue line
+   */
+  static async updateScriptDialogue(id: string, sceneId: number, lineId: number, edit: DialogueEdit): Promise<TaskScript> {
+    return apiClient.patch<TaskScript>(`/v1/tasks/${id}/script/scenes/${sceneId}/dialogues/${lineId}`, edit);
+  }
+
+  /**
+   * Delete a dialogue line
+   */
+  static async deleteScriptDialogue(id: string, sceneId: number, lineId: number): Promise<TaskScript> {
+    return apiClient.delete<TaskScript>(`/v1/tasks/${id}/script/scenes/${sceneId}/dialogues/${lineId}`);
+  }
+
+  /**
+   * Reorder the dialogue lines of a scene; order must contain every line ID exactly once
+   */
+  static async reorderScriptDialogues(id: string, sceneId: number, order: number[]): Promise<TaskScript> {
+    return apiClient.put<TaskScript>(`/v1/tasks/${id}/script/scenes/${sceneId}/dialogues/order`, { order });
+  }
+
+  /**
+   * Add a character or replace its character sheet
+   */
+  static async updateScriptCharacter(id: string, name: string, character: CharacterEdit): Promise<TaskScript> {
+    return apiClient.put<TaskScript>(`/v1/tasks/${id}/script/characters/${encodeURIComponent(name)}`, character);
+  }
+
+  /**
+   * Delete a character
+   */
+  static async deleteScriptCharacter(id: string, name: string): Promise<TaskScript> {
+    return apiClient.delete<TaskScript>(`/v1/tasks/${id}/script/characters/${encodeURIComponent(name)}`);
+  }
+
+  /**
+   * Add a location or replace its setting
+   */
+  static async updateScriptLocation(id: string, locationId: string, location: LocationEdit): Promise<TaskScript> {
+    return apiClient.put<TaskScript>(`/v1/tasks/${id}/script/locations/${encodeURIComponent(locationId)}`, location);
+  }
+
+  /**
+   * Delete a location; fails while scenes still reference it
+   */
+  static async deleteScriptLocation(id: string, locationId: string): Promise<TaskScript> {
+    return apiClient.delete<TaskScript>(`/v1/tasks/${id}/script/locations/${encodeURIComponent(locationId)}`);
+  }
+
+  /**
+   * Approve the script and continue with image and audio generation
+   */
+  static async approveTaskScript(id: string): Promise<GetTaskResponse> {
+    return apiClient.post<GetTaskResponse>(`/v1/tasks/${id}/script/approve`);
+  }
+
+  /**
+   * URL of the Server-Sent Events stream for a task
+   */
+  static getTaskEventsUrl(id: string): string {
+    return `${API_BASE_URL}/v1/tasks/${id}/events`;
+  }
+
   /**
    * Delete a task by ID
    */
@@ -1,48 +1,336 @@
 // Core data types based on API specification
+export type TaskStatus = 'queued' | 'running' | 'awaiting_review' | 'failed' | 'done' | 'cancelled';
+
+export type TaskStage = 'script' | 'references' | 'images' | 'audios' | 'pages' | 'assemble';
+
+export interface StageProgress {
+  completed: number;
+  total: number;
+  startedAt?: string;
+  finishedAt?: string;
+}
+
+export interface TaskProgress {
+  stage: TaskStage;
+  stages: Partial<Record<TaskStage, StageProgress>>;
+}
+
 export interface Task {
   id: string;
   name: string;
-  status: 'doing' | 'done';
+  status: TaskStatus;
   statusDesc: string;
+  retryCount?: number;
+  maxRetries?: number;
+  nextRetryAt?: string;
+  lastError?: string;
+  errorStage?: string;
+  progress?: TaskProgress;
+  reviewScript?: boolean;
+  scriptApproved?: boolean;
+  style?: string;
+  scriptRevision?: number;
+  stale?: StaleArtifacts;
+  projectId?: string;
+  sequence?: number;
+  chapters?: TaskChapters;
+  unresolvedNames?: UnresolvedName[];
   createdAt?: Date;
 }
 
+// A name used in the script that does not match any character in the bible
+export interface UnresolvedName {
+  name: string;
+  scenes: number[];
+  lines: number;
+}
+
+// Artifact filenames whose inputs changed after a script edit and should be regenerated
+export interface StaleArtifacts {
+  images: string[];
+  audios: string[];
+}
+
 export interface Dialogue {
+  lineId: number; // stable ID within the scene
   character: string;
   line: string;
-  voiceURL: string; // URL to audio file
+  voiceURL: string; // URL to audio file, empty until generated
 }
 
 export interface AnimeScene {
-  imageURL: string; // URL to image file
+  sceneId: number; // stable ID, matches scene_id in the script
+  imageURL: string; // URL to image file, empty until generated
   narration: string;
   narrationVoiceURL?: string; // URL to narration audio file (optional)
   dialogues: Dialogue[];
 }
 
+// Character reference sheet sent as a reference image when generating scene images
+export interface CharacterReference {
+  character: string;
+  filename: string; // changes when the character's visual description is edited
+  imageURL: string;
+}
+
+// Comic page with scene images laid out in panels, speech bubbles and captions drawn in
+export interface ComicPage {
+  page: number; // 1-based
+  filename: string;
+  layout: string;
+  sceneIds: number[]; // scenes in panel order
+  imageURL: string;
+}
+
+export interface ComposePagesResponse {
+  pages: ComicPage[];
+}
+
+// Segment of a vertical-scroll webtoon strip; segments stack seamlessly in order
+export interface StripSegment {
+  segment: number; // 1-based
+  filename: string;
+  width: number;
+  height: number;
+  sceneIds: number[]; // scenes fully or partly in this segment
+  imageURL: string;
+}
+
+export interface ExportStripRequest {
+  width?: number; // 200-2048, server default 800
+  maxHeight?: number; // at least 400, server default 1280
+}
+
+export interface ExportStripResponse {
+  segments: StripSegment[];
+}
+
 export interface AnimeArtifacts {
   scenes: AnimeScene[];
+  references?: CharacterReference[];
+  pages?: ComicPage[];
+  strip?: StripSegment[];
+  stale?: StaleArtifacts;
+}
+
+// Version history of a regenerated artifact
+export interface ArtifactVersion {
+  version: number;
+  filename: string;
+  url: string;
+  prompt?: string;
+  instructions?: string;
+  voice?: string;
+  emotion?: string;
+  text?: string;
+  createdAt: string;
+  active: boolean;
+}
+
+export interface ArtifactVersions {
+  sceneId: number;
+  kind: 'image' | 'audio';
+  filename: string;
+  currentUrl: string;
+  active: number; // 0 until the artifact is regenerated for the first time
+  versions: ArtifactVersion[];
+}
+
+export interface RegenerateImageRequest {
+  prompt?: string; // replaces the default prompt
+  instructions?: string; // appended to the prompt
 }
 
+export interface RegenerateAudioRequest {
+  voice?: string; // qiniu voice name or tencent voice ID
+  emotion?: string; // tencent only
+  text?: string; // replaces the spoken text
+}
+
+// Narration of a scene, or the dialogue line with the given line ID
+export type AudioTarget = { kind: 'narration' } | { kind: 'dialogue'; lineId: number };
+
 // API request/response types
 export interface CreateTaskRequest {
   name: string;
   novel: string;
+  reviewScript?: boolean;
+  style?: string; // art style preset name, see GET /v1/styles
+  split?: SplitOptions;
+}
+
+// Art style preset for scene images
+export interface StylePreset {
+  name: string;
+  label: string;
+}
+
+export interface ListStylesResponse {
+  styles: StylePreset[];
+  default: string;
+}
+
+// Chapter detected in a novel; start/end are UTF-8 byte offsets into the novel
+export interface Chapter {
+  index: number;
+  title: string;
+  volume?: string;
+  start: number;
+  end: number;
+}
+
+// Inclusive range of 1-based chapter numbers
+export interface ChapterRange {
+  from: number;
+  to: number;
+}
+
+// Split a novel into sequential tasks of a project, either every N chapters or by explicit ranges
+export interface SplitOptions {
+  chaptersPerTask?: number;
+  ranges?: ChapterRange[];
+}
+
+// Chapters covered by a task of a project
+export interface TaskChapters {
+  from: number;
+  to: number;
+  titles: string[];
+}
+
+// Script as generated by novel2script, editable while a task is awaiting review or done
+export interface ScriptDialogue {
+  line_id?: number; // assigned by the server when omitted
+  character: string;
+  line: string;
+  emotion?: string;
+}
+
+export interface ScriptScene {
+  scene_id: number;
+  location: string;
+  location_id?: string;
+  time_of_day: string;
+  characters_present: string[];
+  scene_description: string;
+  dialogue: ScriptDialogue[];
+  narration_vo: string;
+}
+
+// Fields of a scene to change; omitted fields are left as is
+export type SceneEdit = Partial<Omit<ScriptScene, 'scene_id' | 'dialogue'>>;
+
+// New scene inserted after the scene `after` (0 = first, omitted = last)
+export type InsertSceneRequest = Omit<ScriptScene, 'scene_id'> & { after?: number };
+
+// Fields of a dialogue line to change; omitted fields are left as is
+export type DialogueEdit = Partial<Omit<ScriptDialogue, 'line_id'>>;
+
+// New dialogue line inserted after the line `after` (0 = first, omitted = last)
+export type InsertDialogueRequest = Omit<ScriptDialogue, 'line_id'> & { after?: number };
+
+// Structured character sheet; every field except name may be empty
+export interface Character {
+  name?: string;
+  aliases?: string[];
+  age?: string;
+  gender?: 'male' | 'female' | '';
+  hair?: string;
+  eyes?: string;
+  build?: string;
+  outfit?: string;
+  personality?: string;
+  voice?: string;
+  description?: string;
+}
+
+// Replaces the whole character; the name comes from the URL
+export type CharacterEdit = Omit<Character, 'name'>;
+
+// Recurring setting shared by every scene that references its ID
+export interface Location {
+  id?: string;
+  name: string;
+  aliases?: string[];
+  description: string;
+}
+
+// Replaces the whole location; the ID comes from the URL
+export type LocationEdit = Omit<Location, 'id'>;
+
+export interface TaskScript {
+  script: ScriptScene[];
+  characters: Record<string, Character>;
+  locations?: Record<string, Location>;
 }
 
 export interface CreateTaskResponse {
   id: string;
 }
 
+export interface CreateProjectResponse {
+  projectId: string;
+  taskIds: string[];
+}
+
+export interface ParseChaptersResponse {
+  chapters: Chapter[];
+}
+
+export interface GetProjectResponse {
+  id: string;
+  name: string;
+  chapters: Chapter[];
+  characters: Record<string, Character>;
+  locations: Record<string, Location>;
+  tasks: GetTaskResponse[];
+  createdAt: string;
+}
+
 export interface GetTaskResponse {
   id: string;
   name: string;
-  status: 'doing' | 'done';
+  status: TaskStatus;
+  statusDesc: string;
+  retryCount: number;
+  maxRetries: number;
+  nextRetryAt?: string;
+  lastError?: string;
+  errorStage?: string;
+  progress?: TaskProgress;
+  reviewScript: boolean;
+  scriptApproved: boolean;
+  style: string;
+  scriptRevision: number;
+  stale?: StaleArtifacts;
+  projectId?: string;
+  sequence?: number;
+  chapters?: TaskChapters;
+  unresolvedNames?: UnresolvedName[];
+}
+
+// Server-Sent Events payloads from GET /v1/tasks/:id/events
+export interface TaskProgressEvent {
+  stage: TaskStage;
+  progress: StageProgress;
   statusDesc: string;
 }
 
 export interface GetTasksResponse {
   tasks: Task[];
+  total: number;
+  offset: number;
+  limit: number;
+}
+
+export interface GetTasksParams {
+  status?: TaskStatus[];
+  createdAfter?: string;
+  createdBefore?: string;
+  q?: string;
+  sort?: string; // createdAt | updatedAt | name | status, prefix with '-' for descending
+  offset?: number;
+  limit?: number;
 }
 
 export interface DeleteTaskResponse {
@@ -66,7 +354,8 @@ export type AppAction =
   | { type: 'SET_ERROR'; payload: string | null }
   | { type: 'SET_TASKS'; payload: Task[] }
   | { type: 'ADD_TASK'; payload: Task }
-  | { type: 'UPDATE_TASK'; payload: { id: string; status: 'doing' | 'done'; statusDesc?: string } }
+  | { type: 'UPDATE_TASK'; payload: { id: string; status: TaskStatus; statusDesc?: string; progress?: TaskProgress } }
+  | { type: 'UPDATE_TASK_PROGRESS'; payload: { id: string } & TaskProgressEvent }
   | { type: 'DELETE_TASK'; payload: string }
   | { type: 'SET_CURRENT_TASK'; payload: Task | null }
   | { type: 'SET_ANIME_DATA'; payload: AnimeArtifacts | null }
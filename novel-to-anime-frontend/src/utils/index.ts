@@ -1,3 +1,5 @@
+import type { TaskProgress, TaskStage, TaskStatus } from '../types';
+
 /**
  * Convert base64 string to blob for audio playback
  */
@@ -28,6 +30,45 @@ export const cleanupAudioUrl = (url: string): void => {
   URL.revokeObjectURL(url);
 };
 
+/**
+ * Whether a task is still being processed (queued, running or waiting for a retry)
+ */
+export const isTaskActive = (status: TaskStatus): boolean => {
+  return status === 'queued' || status === 'running';
+};
+
+/**
+ * Whether a task has reached a terminal state
+ */
+export const isTaskTerminal = (status: TaskStatus): boolean => {
+  return status === 'done' || status === 'failed' || status === 'cancelled';
+};
+
+const TASK_STAGES: TaskStage[] = ['script', 'images', 'audios', 'assemble'];
+
+/**
+ * Overall task progress in percent, each pipeline stage weighted equally
+ */
+export const getTaskProgressPercent = (progress?: TaskProgress): number => {
+  if (!progress) {
+    return 0;
+  }
+
+  let done = 0;
+  for (const stage of TASK_STAGES) {
+    const sp = progress.stages?.[stage];
+    if (!sp) {
+      continue;
+    }
+    if (sp.finishedAt) {
+      done += 1;
+    } else if (sp.total > 0) {
+      done += Math.min(sp.completed / sp.total, 1);
+    }
+  }
+  return Math.round((done / TASK_STAGES.length) * 100);
+};
+
 /**
  * Format task creation time for display
  */
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,13 +14,15 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/llmjson"
 	openai "github.com/sashabaranov/go-openai"
 )
 
 // 数据结构
 type ScriptData struct {
-	Script     []Scene           `json:"script"`
-	Characters map[string]string `json:"characters"`
+	Script     []Scene         `json:"script"`
+	Characters character.Bible `json:"characters"`
 }
 
 type Scene struct {
@@ -33,6 +36,7 @@ type Scene struct {
 }
 
 type DialogueLine struct {
+	LineID    int    `json:"line_id,omitempty"` // 对话 ID，用于音频文件名；为 0 时使用从 1 开始的序号
 	Character string `json:"character"`
 	Line      string `json:"line"`
 	Emotion   string `json:"emotion,omitempty"`
@@ -80,16 +84,45 @@ type VoiceMatchResponse struct {
 	Reasoning    string            `json:"reasoning,omitempty"`
 }
 
+// Progress 单条音频（旁白或对话）的处理进度
+type Progress struct {
+	Done      int    // 已处理的条目数（包括本条）
+	Total     int    // 总条目数
+	SceneID   int    // 所属场景
+	Character string // 说话角色，旁白为 "旁白"
+	Emotion   string // 情感（可选）
+	Text      string // 朗读文本
+	Filename  string // 音频文件名
+	Size      int    // 音频字节数
+	Skipped   bool   // 文件已存在，未重新生成
+	Err       error  // 生成或保存失败时的错误
+}
+
+// NarratorCharacter 旁白在音色匹配结果中的角色名
+const NarratorCharacter = "旁白"
+
+// DefaultVoiceType 没有匹配到音色时使用的默认音色
+const DefaultVoiceType = "qiniu_zh_female_wwxkjx"
+
 // Config 配置
 type Config struct {
 	BaseURL    string
 	APIKey     string
 	LLMModel   string
 	VoiceModel string
+
+	// ResponseFormat 音色匹配时通过 response_format 要求模型输出 JSON，为空时只靠提示词，见 llmjson.Format
+	ResponseFormat llmjson.Format
+
+	// SkipExisting 为 true 时跳过输出目录中已存在的音频文件，并复用已保存的音色匹配结果（用于断点续跑）
+	SkipExisting bool
+	// OnProgress 每条旁白或对话处理完成（生成、跳过或失败）后回调，为空时输出到标准输出
+	OnProgress func(Progress)
 }
 
 // Process 处理整个音频生成流程
-func Process(scriptData ScriptData, outputDir string, cfg Config) error {
+// ctx 被取消时，进行中的请求会被中止，并返回 ctx 的错误
+func Process(ctx context.Context, scriptData ScriptData, outputDir string, cfg Config) error {
 	fmt.Println("🎤 步骤四: 音频合成")
 	fmt.Println("=====================================")
 	fmt.Println()
@@ -101,41 +134,47 @@ func Process(scriptData ScriptData, outputDir string, cfg Config) error {
 		return fmt.Errorf("创建输出目录失败: %v", err)
 	}
 
-	// 获取音色列表
-	fmt.Println("🎵 获取可用音色列表...")
-	voices, err := getVoiceList(cfg)
-	if err != nil {
-		fmt.Printf("⚠️  获取音色列表失败: %v，使用内置列表\n", err)
-		voices = getBuiltinVoiceList()
-	}
-	fmt.Printf("✅ 共有 %d 种音色可用\n\n", len(voices))
-
-	// 为角色（包括旁白）匹配音色
-	fmt.Println("🤖 为角色和旁白匹配音色...")
-	voiceMatches, err := matchVoicesForCharacters(scriptData, voices, cfg)
-	if err != nil {
-		fmt.Printf("⚠️  AI匹配失败: %v，使用规则匹配\n", err)
-		voiceMatches = simpleVoiceMatch(scriptData.Characters)
-	}
+	// 为角色（包括旁白）匹配音色；断点续跑时复用已保存的匹配结果，保证前后音色一致
+	matchesFile := filepath.Join(outputDir, "voice_matches.json")
+	voiceMatches, err := loadVoiceMatches(matchesFile)
+	if cfg.SkipExisting && err == nil {
+		fmt.Printf("✅ 复用已保存的音色匹配: %s\n\n", matchesFile)
+	} else {
+		// 获取音色列表
+		fmt.Println("🎵 获取可用音色列表...")
+		voices, err := getVoiceList(ctx, cfg)
+		if err != nil {
+			fmt.Printf("⚠️  获取音色列表失败: %v，使用内置列表\n", err)
+			voices = getBuiltinVoiceList()
+		}
+		fmt.Printf("✅ 共有 %d 种音色可用\n\n", len(voices))
+
+		// 为角色（包括旁白）匹配音色
+		fmt.Println("🤖 为角色和旁白匹配音色...")
+		voiceMatches, err = matchVoicesForCharacters(ctx, scriptData, voices, cfg)
+		if err != nil {
+			fmt.Printf("⚠️  AI匹配失败: %v，使用规则匹配\n", err)
+			voiceMatches = simpleVoiceMatch(scriptData.Characters)
+		}
 
-	fmt.Println("✅ 音色匹配完成:")
-	for char, voiceType := range voiceMatches {
-		voiceName := "未知音色"
-		for _, v := range voices {
-			if v.VoiceType == voiceType {
-				voiceName = v.VoiceName
-				break
+		fmt.Println("✅ 音色匹配完成:")
+		for char, voiceType := range voiceMatches {
+			voiceName := "未知音色"
+			for _, v := range voices {
+				if v.VoiceType == voiceType {
+					voiceName = v.VoiceName
+					break
+				}
 			}
+			fmt.Printf("  - %s: %s (%s)\n", char, voiceName, voiceType)
 		}
-		fmt.Printf("  - %s: %s (%s)\n", char, voiceName, voiceType)
-	}
-	fmt.Println()
+		fmt.Println()
 
-	// 保存音色匹配信息
-	matchesJSON, _ := json.MarshalIndent(voiceMatches, "", "  ")
-	matchesFile := filepath.Join(outputDir, "voice_matches.json")
-	if err := os.WriteFile(matchesFile, matchesJSON, 0o644); err != nil {
-		fmt.Printf("⚠️  保存音色匹配信息失败: %v\n", err)
+		// 保存音色匹配信息
+		matchesJSON, _ := json.MarshalIndent(voiceMatches, "", "  ")
+		if err := writeFileAtomic(matchesFile, matchesJSON); err != nil {
+			fmt.Printf("⚠️  保存音色匹配信息失败: %v\n", err)
+		}
 	}
 
 	// 统计总对话数和旁白数
@@ -159,77 +198,85 @@ func Process(scriptData ScriptData, outputDir string, cfg Config) error {
 	fmt.Printf("🎙️  生成语音文件...\n")
 	currentIdx := 0
 	totalItems := totalDialogues + totalNarrations
+	report := cfg.OnProgress
+	if report == nil {
+		report = printProgress
+	}
 
 	for _, scene := range scriptData.Script {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// 1. 先生成旁白音频（如果有）
 		if scene.NarrationVO != "" {
 			currentIdx++
 
 			// 获取旁白音色
-			voiceType, ok := voiceMatches["旁白"]
+			voiceType, ok := voiceMatches[NarratorCharacter]
 			if !ok {
 				// 如果没有匹配到旁白音色，使用默认音色
-				voiceType = "qiniu_zh_female_wwxkjx"
+				voiceType = DefaultVoiceType
 			}
 
-			// 显示进度
-			narrationPreview := scene.NarrationVO
-			if len(narrationPreview) > 40 {
-				narrationPreview = narrationPreview[:40] + "..."
+			filename := fmt.Sprintf("scene_%03d_narration.mp3", scene.SceneID)
+			item := Progress{
+				Done:      currentIdx,
+				Total:     totalItems,
+				SceneID:   scene.SceneID,
+				Character: NarratorCharacter,
+				Text:      scene.NarrationVO,
+				Filename:  filename,
 			}
-			fmt.Printf("[%d/%d] 场景%d - 旁白: %s\n",
-				currentIdx, totalItems, scene.SceneID, narrationPreview)
-
-			// 生成音频
-			audioData, err := generateAudio(scene.NarrationVO, voiceType, cfg)
-			if err != nil {
-				fmt.Printf("  ❌ 生成失败: %v\n", err)
+			audioPath := filepath.Join(outputDir, filename)
+			if cfg.SkipExisting && fileExists(audioPath) {
+				item.Skipped = true
 			} else {
-				// 保存文件
-				filename := fmt.Sprintf("scene_%03d_narration.mp3", scene.SceneID)
-				filepath := filepath.Join(outputDir, filename)
-				if err := os.WriteFile(filepath, audioData, 0o644); err != nil {
-					fmt.Printf("  ❌ 保存失败: %v\n", err)
-				} else {
-					fmt.Printf("  ✅ 已保存: %s (%.1f KB)\n", filename, float64(len(audioData))/1024)
-				}
+				item.Size, item.Err = synthesizeTo(ctx, audioPath, scene.NarrationVO, voiceType, cfg)
 			}
+			report(item)
 		}
 
 		// 2. 再生成对话音频
 		for dialogueIdx, dialogue := range scene.Dialogue {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			currentIdx++
 
 			// 获取角色对应的音色
 			voiceType, ok := voiceMatches[dialogue.Character]
 			if !ok {
-				voiceType = "qiniu_zh_female_wwxkjx" // 使用默认音色
+				// 旧版剧本中的说话人可能是别名，按角色设定找到规范角色名
+				if name, found := scriptData.Characters.Resolve(dialogue.Character); found {
+					voiceType, ok = voiceMatches[name]
+				}
+			}
+			if !ok {
+				voiceType = DefaultVoiceType // 使用默认音色
 			}
 
-			// 显示进度
-			linePreview := dialogue.Line
-			if len(linePreview) > 30 {
-				linePreview = linePreview[:30] + "..."
+			lineID := dialogue.LineID
+			if lineID == 0 {
+				lineID = dialogueIdx + 1
 			}
-			fmt.Printf("[%d/%d] 场景%d - %s: %s\n",
-				currentIdx, totalItems, scene.SceneID, dialogue.Character, linePreview)
-
-			// 生成音频
-			audioData, err := generateAudio(dialogue.Line, voiceType, cfg)
-			if err != nil {
-				fmt.Printf("  ❌ 生成失败: %v\n", err)
-				continue
+			filename := fmt.Sprintf("scene_%03d_dialogue_%03d.mp3", scene.SceneID, lineID)
+			item := Progress{
+				Done:      currentIdx,
+				Total:     totalItems,
+				SceneID:   scene.SceneID,
+				Character: dialogue.Character,
+				Emotion:   dialogue.Emotion,
+				Text:      dialogue.Line,
+				Filename:  filename,
 			}
-
-			// 保存文件
-			filename := fmt.Sprintf("scene_%03d_dialogue_%03d.mp3", scene.SceneID, dialogueIdx+1)
-			filepath := filepath.Join(outputDir, filename)
-			if err := os.WriteFile(filepath, audioData, 0o644); err != nil {
-				fmt.Printf("  ❌ 保存失败: %v\n", err)
-				continue
+			audioPath := filepath.Join(outputDir, filename)
+			if cfg.SkipExisting && fileExists(audioPath) {
+				item.Skipped = true
+			} else {
+				item.Size, item.Err = synthesizeTo(ctx, audioPath, dialogue.Line, voiceType, cfg)
 			}
-
-			fmt.Printf("  ✅ 已保存: %s (%.1f KB)\n", filename, float64(len(audioData))/1024)
+			report(item)
 		}
 	}
 
@@ -240,9 +287,23 @@ func Process(scriptData ScriptData, outputDir string, cfg Config) error {
 	return nil
 }
 
+// GenerateLine 生成单条旁白或对话的音频，用于单独重新生成某一句
+// voiceType 为空时使用 DefaultVoiceType
+func GenerateLine(ctx context.Context, text, voiceType string, cfg Config) ([]byte, error) {
+	if voiceType == "" {
+		voiceType = DefaultVoiceType
+	}
+	return generateAudio(ctx, text, voiceType, cfg)
+}
+
+// LoadVoiceMatches 读取 Process 保存在 outputDir 中的音色匹配结果（角色名 -> 音色）
+func LoadVoiceMatches(outputDir string) (map[string]string, error) {
+	return loadVoiceMatches(filepath.Join(outputDir, "voice_matches.json"))
+}
+
 // getVoiceList 获取音色列表（尝试从API，失败则返回错误）
-func getVoiceList(cfg Config) ([]VoiceInfo, error) {
-	voices, err := getVoiceListFromAPI(cfg)
+func getVoiceList(ctx context.Context, cfg Config) ([]VoiceInfo, error) {
+	voices, err := getVoiceListFromAPI(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -250,9 +311,9 @@ func getVoiceList(cfg Config) ([]VoiceInfo, error) {
 }
 
 // getVoiceListFromAPI 从API获取音色列表
-func getVoiceListFromAPI(cfg Config) ([]VoiceInfo, error) {
+func getVoiceListFromAPI(ctx context.Context, cfg Config) ([]VoiceInfo, error) {
 	url := cfg.BaseURL + "/voice/list"
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -335,7 +396,7 @@ func getBuiltinVoiceList() []VoiceInfo {
 }
 
 // matchVoicesForCharacters 使用AI为角色匹配音色
-func matchVoicesForCharacters(scriptData ScriptData, voices []VoiceInfo, cfg Config) (map[string]string, error) {
+func matchVoicesForCharacters(ctx context.Context, scriptData ScriptData, voices []VoiceInfo, cfg Config) (map[string]string, error) {
 	config := openai.DefaultConfig(cfg.APIKey)
 	config.BaseURL = cfg.BaseURL
 	config.HTTPClient = &http.Client{
@@ -348,41 +409,60 @@ func matchVoicesForCharacters(scriptData ScriptData, voices []VoiceInfo, cfg Con
 
 	prompt := buildVoiceMatchPrompt(scriptData, voices)
 
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: cfg.LLMModel,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: "你是一个专业的配音导演，擅长根据角色特征选择最合适的声音。",
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
+	req := openai.ChatCompletionRequest{
+		Model: cfg.LLMModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "你是一个专业的配音导演，擅长根据角色特征选择最合适的声音。",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
 			},
 		},
-	)
-	if err != nil {
-		return nil, err
 	}
 
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("LLM返回空响应")
+	var matchResp VoiceMatchResponse
+	err := llmjson.Complete(ctx, client, req, &matchResp, llmjson.Options{
+		Format:   cfg.ResponseFormat,
+		Provider: cfg.BaseURL,
+		Check: func() error {
+			return checkVoiceMatches(matchResp.VoiceMatches, scriptData.Characters, voices)
+		},
+		OnRepair: func(attempt int, err error) {
+			fmt.Printf("⚠️  音色匹配校验失败，请模型修正(第 %d 次): %v\n", attempt, err)
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	content := resp.Choices[0].Message.Content
-
-	// 尝试提取JSON
-	content = extractJSON(content)
+	return matchResp.VoiceMatches, nil
+}
 
-	var matchResp VoiceMatchResponse
-	if err := json.Unmarshal([]byte(content), &matchResp); err != nil {
-		return nil, fmt.Errorf("解析AI响应失败: %v", err)
+// checkVoiceMatches 校验音色匹配结果：必须包含旁白和所有角色，音色必须在可用音色列表中
+func checkVoiceMatches(matches map[string]string, characters character.Bible, voices []VoiceInfo) error {
+	available := make(map[string]bool, len(voices))
+	for _, v := range voices {
+		available[v.VoiceType] = true
 	}
 
-	return matchResp.VoiceMatches, nil
+	var errs []error
+	if _, ok := matches["旁白"]; !ok {
+		errs = append(errs, fmt.Errorf("voice_matches 缺少「旁白」"))
+	}
+	for name := range characters {
+		if _, ok := matches[name]; !ok {
+			errs = append(errs, fmt.Errorf("voice_matches 缺少角色「%s」", name))
+		}
+	}
+	for name, voiceType := range matches {
+		if !available[voiceType] {
+			errs = append(errs, fmt.Errorf("角色「%s」的音色 %v 不在可用音色列表中", name, voiceType))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // buildVoiceMatchPrompt 构建音色匹配提示词
@@ -398,8 +478,8 @@ func buildVoiceMatchPrompt(scriptData ScriptData, voices []VoiceInfo) string {
 	sb.WriteString("**旁白**: 故事的叙述者，负责讲述场景和氛围\n\n")
 
 	// 其他角色
-	for char, desc := range scriptData.Characters {
-		sb.WriteString(fmt.Sprintf("**%s**: %s\n\n", char, desc))
+	for char, c := range scriptData.Characters {
+		sb.WriteString(fmt.Sprintf("**%s**: %s\n\n", char, c.Profile()))
 	}
 
 	// 场景和对话样本（前3个场景）
@@ -473,7 +553,8 @@ func buildVoiceMatchPrompt(scriptData ScriptData, voices []VoiceInfo) string {
 }
 
 // simpleVoiceMatch 简单规则匹配
-func simpleVoiceMatch(characters map[string]string) map[string]string {
+// 按角色设定中的性别和年龄段选择音色，机器人角色使用磁性男声
+func simpleVoiceMatch(characters character.Bible) map[string]string {
 	matches := make(map[string]string)
 	usedVoices := make(map[string]bool)
 
@@ -481,23 +562,20 @@ func simpleVoiceMatch(characters map[string]string) map[string]string {
 	matches["旁白"] = "qiniu_zh_male_tyygjs" // 通用阳光讲师 - 适合旁白
 	usedVoices["qiniu_zh_male_tyygjs"] = true
 
-	for char, desc := range characters {
-		descLower := strings.ToLower(desc)
+	for char, c := range characters {
+		young := c.AgeGroup() == character.Child || c.AgeGroup() == character.Teen
 
 		var voiceType string
-
-		// 机器人
-		if strings.Contains(descLower, "机器人") || strings.Contains(descLower, "robot") ||
-			strings.Contains(descLower, "ai") || strings.Contains(descLower, "人工智能") {
+		switch {
+		case c.Mentions("机器人", "robot", "人工智能", "机械音"):
 			voiceType = "qiniu_zh_male_cxkjns" // 磁性课件男声
-		} else if strings.Contains(descLower, "小女孩") || strings.Contains(descLower, "女童") ||
-			(strings.Contains(descLower, "女") && (strings.Contains(descLower, "岁") || strings.Contains(descLower, "儿童"))) {
+		case c.IsFemale() && young:
 			voiceType = "qiniu_zh_female_dmytwz" // 动漫樱桃丸子
-		} else if strings.Contains(descLower, "少年") || strings.Contains(descLower, "男孩") {
+		case young:
 			voiceType = "qiniu_zh_male_hlsnkk" // 火力少年凯凯
-		} else if strings.Contains(descLower, "女") || strings.Contains(descLower, "female") {
+		case c.IsFemale():
 			voiceType = "qiniu_zh_female_wwxkjx" // 温婉学科讲师
-		} else {
+		default:
 			voiceType = "qiniu_zh_male_ljfdxz" // 邻家辅导学长
 		}
 
@@ -536,8 +614,20 @@ func findAlternativeVoice(originalVoice string, usedVoices map[string]bool) stri
 	return originalVoice
 }
 
+// synthesizeTo 生成音频并保存到 path，返回音频字节数
+func synthesizeTo(ctx context.Context, path, text, voiceType string, cfg Config) (int, error) {
+	audioData, err := generateAudio(ctx, text, voiceType, cfg)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomic(path, audioData); err != nil {
+		return 0, fmt.Errorf("保存失败: %v", err)
+	}
+	return len(audioData), nil
+}
+
 // generateAudio 生成音频
-func generateAudio(text, voiceType string, cfg Config) ([]byte, error) {
+func generateAudio(ctx context.Context, text, voiceType string, cfg Config) ([]byte, error) {
 	url := cfg.BaseURL + "/voice/tts"
 
 	reqBody := TTSRequest{
@@ -556,7 +646,7 @@ func generateAudio(text, voiceType string, cfg Config) ([]byte, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
 	}
@@ -599,32 +689,51 @@ func generateAudio(text, voiceType string, cfg Config) ([]byte, error) {
 	return audioData, nil
 }
 
-// extractJSON 提取JSON内容
-func extractJSON(content string) string {
-	content = strings.TrimSpace(content)
-
-	// 移除markdown代码块标记
-	if strings.HasPrefix(content, "```json") {
-		content = strings.TrimPrefix(content, "```json")
-		content = strings.TrimPrefix(content, "```")
-	}
-	if strings.HasPrefix(content, "```") {
-		content = strings.TrimPrefix(content, "```")
+// loadVoiceMatches 读取已保存的音色匹配结果
+func loadVoiceMatches(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
-	if strings.HasSuffix(content, "```") {
-		content = strings.TrimSuffix(content, "```")
+	var matches map[string]string
+	if err := json.Unmarshal(data, &matches); err != nil {
+		return nil, err
 	}
+	return matches, nil
+}
 
-	content = strings.TrimSpace(content)
+// printProgress 默认的进度输出
+func printProgress(p Progress) {
+	emotionInfo := ""
+	if p.Emotion != "" {
+		emotionInfo = fmt.Sprintf(" [%s]", p.Emotion)
+	}
+	fmt.Printf("[%d/%d] 场景%d - %s%s: %s\n",
+		p.Done, p.Total, p.SceneID, p.Character, emotionInfo, truncateText(p.Text, 40))
 
-	// 尝试提取JSON对象
-	start := strings.Index(content, "{")
-	end := strings.LastIndex(content, "}")
-	if start != -1 && end != -1 && end > start {
-		content = content[start : end+1]
+	switch {
+	case p.Skipped:
+		fmt.Printf("  ⏭️  已存在，跳过: %s\n", p.Filename)
+	case p.Err != nil:
+		fmt.Printf("  ❌ 生成失败: %v\n", p.Err)
+	default:
+		fmt.Printf("  ✅ 已保存: %s (%.1f KB)\n", p.Filename, float64(p.Size)/1024)
 	}
+}
+
+// fileExists 判断文件是否存在且非空
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Size() > 0
+}
 
-	return content
+// writeFileAtomic 先写临时文件再重命名，避免中断时留下不完整的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 // truncateText 截断文本
@@ -5,12 +5,15 @@ import (
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/llmjson"
 	"github.com/google/uuid"
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
@@ -20,8 +23,8 @@ import (
 
 // 数据结构
 type ScriptData struct {
-	Script     []Scene           `json:"script"`
-	Characters map[string]string `json:"characters"`
+	Script     []Scene         `json:"script"`
+	Characters character.Bible `json:"characters"`
 }
 
 type Scene struct {
@@ -35,6 +38,7 @@ type Scene struct {
 }
 
 type DialogueLine struct {
+	LineID    int    `json:"line_id,omitempty"` // 对话 ID，用于音频文件名；为 0 时使用从 1 开始的序号
 	Character string `json:"character"`
 	Line      string `json:"line"`
 	Emotion   string `json:"emotion,omitempty"`
@@ -54,22 +58,54 @@ type VoiceMatchResponse struct {
 	Reasoning    string           `json:"reasoning,omitempty"`
 }
 
+// Progress 单条音频（旁白或对话）的处理进度
+type Progress struct {
+	Done      int    // 已处理的条目数（包括本条）
+	Total     int    // 总条目数
+	SceneID   int    // 所属场景
+	Character string // 说话角色，旁白为 "旁白"
+	Emotion   string // 情感（可选）
+	Text      string // 朗读文本
+	Filename  string // 音频文件名
+	Size      int    // 音频字节数
+	Skipped   bool   // 文件已存在，未重新生成
+	Err       error  // 生成或保存失败时的错误
+}
+
+// NarratorCharacter 旁白在音色匹配结果中的角色名
+const NarratorCharacter = "旁白"
+
+// 没有匹配到音色时使用的默认音色
+const (
+	DefaultNarrationVoice int64 = 601001 // 爱小洛，阅读女声
+	DefaultDialogueVoice  int64 = 601000 // 爱小溪，聊天女声
+)
+
 // Config 配置
 type Config struct {
 	SecretID  string
 	SecretKey string
 	Region    string
 	LLMConfig LLMConfig
+
+	// SkipExisting 为 true 时跳过输出目录中已存在的音频文件，并复用已保存的音色匹配结果（用于断点续跑）
+	SkipExisting bool
+	// OnProgress 每条旁白或对话处理完成（生成、跳过或失败）后回调，为空时输出到标准输出
+	OnProgress func(Progress)
 }
 
 type LLMConfig struct {
 	BaseURL string
 	APIKey  string
 	Model   string
+
+	// ResponseFormat 通过 response_format 要求模型输出 JSON，为空时只靠提示词，见 llmjson.Format
+	ResponseFormat llmjson.Format
 }
 
 // Process 处理整个音频生成流程
-func Process(scriptData ScriptData, outputDir string, cfg Config) error {
+// ctx 被取消时，进行中的请求会被中止，并返回 ctx 的错误
+func Process(ctx context.Context, scriptData ScriptData, outputDir string, cfg Config) error {
 	fmt.Println("🎤 步骤四: 音频合成 (腾讯云TTS)")
 	fmt.Println("=====================================")
 	fmt.Println()
@@ -81,37 +117,43 @@ func Process(scriptData ScriptData, outputDir string, cfg Config) error {
 		return fmt.Errorf("创建输出目录失败: %v", err)
 	}
 
-	// 获取支持多情感的音色列表
-	voices := getMultiEmotionVoices()
-	fmt.Printf("✅ 共有 %d 种多情感音色可用\n\n", len(voices))
+	// 为角色（包括旁白）匹配音色；断点续跑时复用已保存的匹配结果，保证前后音色一致
+	matchesFile := filepath.Join(outputDir, "voice_matches.json")
+	voiceMatches, err := loadVoiceMatches(matchesFile)
+	if cfg.SkipExisting && err == nil {
+		fmt.Printf("✅ 复用已保存的音色匹配: %s\n\n", matchesFile)
+	} else {
+		// 获取支持多情感的音色列表
+		voices := getMultiEmotionVoices()
+		fmt.Printf("✅ 共有 %d 种多情感音色可用\n\n", len(voices))
+
+		// 为角色（包括旁白）匹配音色
+		fmt.Println("🤖 为角色和旁白匹配音色...")
+		voiceMatches, err = matchVoicesForCharacters(ctx, scriptData, voices, cfg.LLMConfig)
+		if err != nil {
+			fmt.Printf("⚠️  AI匹配失败: %v，使用规则匹配\n", err)
+			voiceMatches = simpleVoiceMatch(scriptData.Characters)
+		}
 
-	// 为角色（包括旁白）匹配音色
-	fmt.Println("🤖 为角色和旁白匹配音色...")
-	voiceMatches, err := matchVoicesForCharacters(scriptData, voices, cfg.LLMConfig)
-	if err != nil {
-		fmt.Printf("⚠️  AI匹配失败: %v，使用规则匹配\n", err)
-		voiceMatches = simpleVoiceMatch(scriptData.Characters)
-	}
-
-	fmt.Println("✅ 音色匹配完成:")
-	for char, voiceType := range voiceMatches {
-		voiceName := "未知音色"
-		for _, v := range voices {
-			voiceID := parseVoiceType(v.VoiceType)
-			if voiceID == voiceType {
-				voiceName = v.VoiceName
-				break
+		fmt.Println("✅ 音色匹配完成:")
+		for char, voiceType := range voiceMatches {
+			voiceName := "未知音色"
+			for _, v := range voices {
+				voiceID := parseVoiceType(v.VoiceType)
+				if voiceID == voiceType {
+					voiceName = v.VoiceName
+					break
+				}
 			}
+			fmt.Printf("  - %s: %s (VoiceType=%d)\n", char, voiceName, voiceType)
 		}
-		fmt.Printf("  - %s: %s (VoiceType=%d)\n", char, voiceName, voiceType)
-	}
-	fmt.Println()
+		fmt.Println()
 
-	// 保存音色匹配信息
-	matchesJSON, _ := json.MarshalIndent(voiceMatches, "", "  ")
-	matchesFile := filepath.Join(outputDir, "voice_matches.json")
-	if err := os.WriteFile(matchesFile, matchesJSON, 0o644); err != nil {
-		fmt.Printf("⚠️  保存音色匹配信息失败: %v\n", err)
+		// 保存音色匹配信息
+		matchesJSON, _ := json.MarshalIndent(voiceMatches, "", "  ")
+		if err := writeFileAtomic(matchesFile, matchesJSON); err != nil {
+			fmt.Printf("⚠️  保存音色匹配信息失败: %v\n", err)
+		}
 	}
 
 	// 统计总对话数和旁白数
@@ -132,92 +174,94 @@ func Process(scriptData ScriptData, outputDir string, cfg Config) error {
 	fmt.Printf("📊 需要生成 %d 个对话音频和 %d 个旁白音频\n\n", totalDialogues, totalNarrations)
 
 	// 创建腾讯云TTS客户端
-	credential := common.NewCredential(cfg.SecretID, cfg.SecretKey)
-	cpf := profile.NewClientProfile()
-	client, err := tts.NewClient(credential, cfg.Region, cpf)
+	client, err := newClient(cfg)
 	if err != nil {
-		return fmt.Errorf("创建腾讯云TTS客户端失败: %v", err)
+		return err
 	}
 
 	// 生成语音文件
 	fmt.Printf("🎙️  生成语音文件...\n")
 	currentIdx := 0
 	totalItems := totalDialogues + totalNarrations
+	report := cfg.OnProgress
+	if report == nil {
+		report = printProgress
+	}
 
 	for _, scene := range scriptData.Script {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// 1. 先生成旁白音频（如果有）
 		if scene.NarrationVO != "" {
 			currentIdx++
 
 			// 获取旁白音色
-			voiceType, ok := voiceMatches["旁白"]
+			voiceType, ok := voiceMatches[NarratorCharacter]
 			if !ok {
 				// 如果没有匹配到旁白音色，使用默认音色
-				voiceType = 601001 // 爱小洛，阅读女声
+				voiceType = DefaultNarrationVoice
 			}
 
-			// 显示进度
-			narrationPreview := scene.NarrationVO
-			if len(narrationPreview) > 40 {
-				narrationPreview = narrationPreview[:40] + "..."
+			filename := fmt.Sprintf("scene_%03d_narration.mp3", scene.SceneID)
+			item := Progress{
+				Done:      currentIdx,
+				Total:     totalItems,
+				SceneID:   scene.SceneID,
+				Character: NarratorCharacter,
+				Text:      scene.NarrationVO,
+				Filename:  filename,
 			}
-			fmt.Printf("[%d/%d] 场景%d - 旁白: %s\n",
-				currentIdx, totalItems, scene.SceneID, narrationPreview)
-
-			// 生成音频
-			audioData, err := generateAudio(client, scene.NarrationVO, voiceType, "")
-			if err != nil {
-				fmt.Printf("  ❌ 生成失败: %v\n", err)
+			audioPath := filepath.Join(outputDir, filename)
+			if cfg.SkipExisting && fileExists(audioPath) {
+				item.Skipped = true
 			} else {
-				// 保存文件
-				filename := fmt.Sprintf("scene_%03d_narration.mp3", scene.SceneID)
-				filepath := filepath.Join(outputDir, filename)
-				if err := os.WriteFile(filepath, audioData, 0o644); err != nil {
-					fmt.Printf("  ❌ 保存失败: %v\n", err)
-				} else {
-					fmt.Printf("  ✅ 已保存: %s (%.1f KB)\n", filename, float64(len(audioData))/1024)
-				}
+				item.Size, item.Err = synthesizeTo(ctx, client, audioPath, scene.NarrationVO, voiceType, "")
 			}
+			report(item)
 		}
 
 		// 2. 再生成对话音频
 		for dialogueIdx, dialogue := range scene.Dialogue {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			currentIdx++
 
 			// 获取角色对应的音色
 			voiceType, ok := voiceMatches[dialogue.Character]
 			if !ok {
-				voiceType = 601000 // 使用默认音色（爱小溪，聊天女声）
+				// 旧版剧本中的说话人可能是别名，按角色设定找到规范角色名
+				if name, found := scriptData.Characters.Resolve(dialogue.Character); found {
+					voiceType, ok = voiceMatches[name]
+				}
 			}
-
-			// 显示进度
-			linePreview := dialogue.Line
-			if len(linePreview) > 30 {
-				linePreview = linePreview[:30] + "..."
+			if !ok {
+				voiceType = DefaultDialogueVoice // 使用默认音色
 			}
-			emotionInfo := ""
-			if dialogue.Emotion != "" {
-				emotionInfo = fmt.Sprintf(" [%s]", dialogue.Emotion)
+
+			lineID := dialogue.LineID
+			if lineID == 0 {
+				lineID = dialogueIdx + 1
 			}
-			fmt.Printf("[%d/%d] 场景%d - %s%s: %s\n",
-				currentIdx, totalItems, scene.SceneID, dialogue.Character, emotionInfo, linePreview)
-
-			// 生成音频
-			audioData, err := generateAudio(client, dialogue.Line, voiceType, dialogue.Emotion)
-			if err != nil {
-				fmt.Printf("  ❌ 生成失败: %v\n", err)
-				continue
+			filename := fmt.Sprintf("scene_%03d_dialogue_%03d.mp3", scene.SceneID, lineID)
+			item := Progress{
+				Done:      currentIdx,
+				Total:     totalItems,
+				SceneID:   scene.SceneID,
+				Character: dialogue.Character,
+				Emotion:   dialogue.Emotion,
+				Text:      dialogue.Line,
+				Filename:  filename,
 			}
-
-			// 保存文件
-			filename := fmt.Sprintf("scene_%03d_dialogue_%03d.mp3", scene.SceneID, dialogueIdx+1)
-			filepath := filepath.Join(outputDir, filename)
-			if err := os.WriteFile(filepath, audioData, 0o644); err != nil {
-				fmt.Printf("  ❌ 保存失败: %v\n", err)
-				continue
+			audioPath := filepath.Join(outputDir, filename)
+			if cfg.SkipExisting && fileExists(audioPath) {
+				item.Skipped = true
+			} else {
+				item.Size, item.Err = synthesizeTo(ctx, client, audioPath, dialogue.Line, voiceType, dialogue.Emotion)
 			}
-
-			fmt.Printf("  ✅ 已保存: %s (%.1f KB)\n", filename, float64(len(audioData))/1024)
+			report(item)
 		}
 	}
 
@@ -228,6 +272,32 @@ func Process(scriptData ScriptData, outputDir string, cfg Config) error {
 	return nil
 }
 
+// GenerateLine 生成单条旁白或对话的音频，用于单独重新生成某一句
+// emotion 为空时不指定情感
+func GenerateLine(ctx context.Context, text string, voiceType int64, emotion string, cfg Config) ([]byte, error) {
+	client, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return generateAudio(ctx, client, text, voiceType, emotion)
+}
+
+// LoadVoiceMatches 读取 Process 保存在 outputDir 中的音色匹配结果（角色名 -> 音色 ID）
+func LoadVoiceMatches(outputDir string) (map[string]int64, error) {
+	return loadVoiceMatches(filepath.Join(outputDir, "voice_matches.json"))
+}
+
+// newClient 创建腾讯云TTS客户端
+func newClient(cfg Config) (*tts.Client, error) {
+	credential := common.NewCredential(cfg.SecretID, cfg.SecretKey)
+	cpf := profile.NewClientProfile()
+	client, err := tts.NewClient(credential, cfg.Region, cpf)
+	if err != nil {
+		return nil, fmt.Errorf("创建腾讯云TTS客户端失败: %v", err)
+	}
+	return client, nil
+}
+
 // getMultiEmotionVoices 返回支持多情感的腾讯云音色列表
 // 根据腾讯云文档：https://cloud.tencent.com/document/product/1073/92668
 // 只选择"音色情感"列中支持多种情感的大模型音色
@@ -323,7 +393,7 @@ func getMultiEmotionVoices() []VoiceInfo {
 }
 
 // matchVoicesForCharacters 使用AI为角色匹配音色
-func matchVoicesForCharacters(scriptData ScriptData, voices []VoiceInfo, llmCfg LLMConfig) (map[string]int64, error) {
+func matchVoicesForCharacters(ctx context.Context, scriptData ScriptData, voices []VoiceInfo, llmCfg LLMConfig) (map[string]int64, error) {
 	config := openai.DefaultConfig(llmCfg.APIKey)
 	config.BaseURL = llmCfg.BaseURL
 	config.HTTPClient = &http.Client{
@@ -336,41 +406,60 @@ func matchVoicesForCharacters(scriptData ScriptData, voices []VoiceInfo, llmCfg
 
 	prompt := buildVoiceMatchPrompt(scriptData, voices)
 
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: llmCfg.Model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: "你是一个专业的配音导演，擅长根据角色特征选择最合适的声音。",
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
+	req := openai.ChatCompletionRequest{
+		Model: llmCfg.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "你是一个专业的配音导演，擅长根据角色特征选择最合适的声音。",
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
 			},
 		},
-	)
-	if err != nil {
-		return nil, err
 	}
 
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("LLM返回空响应")
+	var matchResp VoiceMatchResponse
+	err := llmjson.Complete(ctx, client, req, &matchResp, llmjson.Options{
+		Format:   llmCfg.ResponseFormat,
+		Provider: llmCfg.BaseURL,
+		Check: func() error {
+			return checkVoiceMatches(matchResp.VoiceMatches, scriptData.Characters, voices)
+		},
+		OnRepair: func(attempt int, err error) {
+			fmt.Printf("⚠️  音色匹配校验失败，请模型修正(第 %d 次): %v\n", attempt, err)
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	content := resp.Choices[0].Message.Content
-
-	// 尝试提取JSON
-	content = extractJSON(content)
+	return matchResp.VoiceMatches, nil
+}
 
-	var matchResp VoiceMatchResponse
-	if err := json.Unmarshal([]byte(content), &matchResp); err != nil {
-		return nil, fmt.Errorf("解析AI响应失败: %v", err)
+// checkVoiceMatches 校验音色匹配结果：必须包含旁白和所有角色，音色必须在可用音色列表中
+func checkVoiceMatches(matches map[string]int64, characters character.Bible, voices []VoiceInfo) error {
+	available := make(map[int64]bool, len(voices))
+	for _, v := range voices {
+		available[parseVoiceType(v.VoiceType)] = true
 	}
 
-	return matchResp.VoiceMatches, nil
+	var errs []error
+	if _, ok := matches["旁白"]; !ok {
+		errs = append(errs, fmt.Errorf("voice_matches 缺少「旁白」"))
+	}
+	for name := range characters {
+		if _, ok := matches[name]; !ok {
+			errs = append(errs, fmt.Errorf("voice_matches 缺少角色「%s」", name))
+		}
+	}
+	for name, voiceType := range matches {
+		if !available[voiceType] {
+			errs = append(errs, fmt.Errorf("角色「%s」的音色 %v 不在可用音色列表中", name, voiceType))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // buildVoiceMatchPrompt 构建音色匹配提示词
@@ -386,8 +475,8 @@ func buildVoiceMatchPrompt(scriptData ScriptData, voices []VoiceInfo) string {
 	sb.WriteString("**旁白**: 故事的叙述者，负责讲述场景和氛围\n\n")
 
 	// 其他角色
-	for char, desc := range scriptData.Characters {
-		sb.WriteString(fmt.Sprintf("**%s**: %s\n\n", char, desc))
+	for char, c := range scriptData.Characters {
+		sb.WriteString(fmt.Sprintf("**%s**: %s\n\n", char, c.Profile()))
 	}
 
 	// 场景和对话样本（前3个场景）
@@ -452,7 +541,8 @@ func buildVoiceMatchPrompt(scriptData ScriptData, voices []VoiceInfo) string {
 }
 
 // simpleVoiceMatch 简单规则匹配
-func simpleVoiceMatch(characters map[string]string) map[string]int64 {
+// 按角色设定中的性别和年龄段选择音色
+func simpleVoiceMatch(characters character.Bible) map[string]int64 {
 	matches := make(map[string]int64)
 	usedVoices := make(map[int64]bool)
 
@@ -460,20 +550,18 @@ func simpleVoiceMatch(characters map[string]string) map[string]int64 {
 	matches["旁白"] = 601001 // 爱小洛，阅读女声 - 适合旁白
 	usedVoices[601001] = true
 
-	for char, desc := range characters {
-		descLower := strings.ToLower(desc)
+	for char, c := range characters {
+		young := c.AgeGroup() == character.Child || c.AgeGroup() == character.Teen
 
 		var voiceType int64
-
-		// 儿童
-		if strings.Contains(descLower, "小女孩") || strings.Contains(descLower, "女童") ||
-			(strings.Contains(descLower, "女") && (strings.Contains(descLower, "岁") || strings.Contains(descLower, "儿童"))) {
+		switch {
+		case c.IsFemale() && young:
 			voiceType = 101016 // 智甜，女童声
-		} else if strings.Contains(descLower, "少年") || strings.Contains(descLower, "男孩") || strings.Contains(descLower, "男童") {
+		case young:
 			voiceType = 601015 // 爱小童，男童声
-		} else if strings.Contains(descLower, "女") || strings.Contains(descLower, "female") {
+		case c.IsFemale():
 			voiceType = 601000 // 爱小溪，聊天女声
-		} else {
+		default:
 			voiceType = 601002 // 爱小辰，聊天男声
 		}
 
@@ -513,8 +601,20 @@ func findAlternativeVoice(originalVoice int64, usedVoices map[int64]bool) int64
 	return originalVoice
 }
 
+// synthesizeTo 生成音频并保存到 path，返回音频字节数
+func synthesizeTo(ctx context.Context, client *tts.Client, path, text string, voiceType int64, emotion string) (int, error) {
+	audioData, err := generateAudio(ctx, client, text, voiceType, emotion)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomic(path, audioData); err != nil {
+		return 0, fmt.Errorf("保存失败: %v", err)
+	}
+	return len(audioData), nil
+}
+
 // generateAudio 生成音频
-func generateAudio(client *tts.Client, text string, voiceType int64, emotion string) ([]byte, error) {
+func generateAudio(ctx context.Context, client *tts.Client, text string, voiceType int64, emotion string) ([]byte, error) {
 	request := tts.NewTextToVoiceRequest()
 
 	// 生成UUID作为SessionId
@@ -532,7 +632,7 @@ func generateAudio(client *tts.Client, text string, voiceType int64, emotion str
 	}
 
 	// 调用腾讯云API
-	response, err := client.TextToVoice(request)
+	response, err := client.TextToVoiceWithContext(ctx, request)
 	if err != nil {
 		return nil, fmt.Errorf("调用腾讯云TTS API失败: %v", err)
 	}
@@ -557,25 +657,51 @@ func parseVoiceType(voiceType string) int64 {
 	return result
 }
 
-// extractJSON 提取JSON内容
-func extractJSON(content string) string {
-	content = strings.TrimSpace(content)
+// loadVoiceMatches 读取已保存的音色匹配结果
+func loadVoiceMatches(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var matches map[string]int64
+	if err := json.Unmarshal(data, &matches); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
 
-	// 移除markdown代码块标记
-	content = strings.TrimPrefix(content, "```json")
-	content = strings.TrimPrefix(content, "```")
-	content = strings.TrimSuffix(content, "```")
+// printProgress 默认的进度输出
+func printProgress(p Progress) {
+	emotionInfo := ""
+	if p.Emotion != "" {
+		emotionInfo = fmt.Sprintf(" [%s]", p.Emotion)
+	}
+	fmt.Printf("[%d/%d] 场景%d - %s%s: %s\n",
+		p.Done, p.Total, p.SceneID, p.Character, emotionInfo, truncateText(p.Text, 40))
+
+	switch {
+	case p.Skipped:
+		fmt.Printf("  ⏭️  已存在，跳过: %s\n", p.Filename)
+	case p.Err != nil:
+		fmt.Printf("  ❌ 生成失败: %v\n", p.Err)
+	default:
+		fmt.Printf("  ✅ 已保存: %s (%.1f KB)\n", p.Filename, float64(p.Size)/1024)
+	}
+}
 
-	content = strings.TrimSpace(content)
+// fileExists 判断文件是否存在且非空
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Size() > 0
+}
 
-	// 尝试提取JSON对象
-	start := strings.Index(content, "{")
-	end := strings.LastIndex(content, "}")
-	if start != -1 && end != -1 && end > start {
-		content = content[start : end+1]
+// writeFileAtomic 先写临时文件再重命名，避免中断时留下不完整的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
 	}
-
-	return content
+	return os.Rename(tmp, path)
 }
 
 // truncateText 截断文本
@@ -0,0 +1,18 @@
+// Package backoff 失败重试的等待时间，图片生成限流重试和 webhook 回调重试共用
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Jittered 第 attempt 次失败后的等待时间：base 按失败次数翻倍，不超过 maxDelay，再在后一半范围内随机抖动
+// 抖动使同时失败的请求错开重试时间，避免一起再次触发限流
+func Jittered(base, maxDelay time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,180 @@
+package chapter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Chapter 小说中的一个章节
+// Start、End 为章节（含标题行）在原文中的 UTF-8 字节偏移，原文[Start:End] 即章节内容
+type Chapter struct {
+	Index  int    `json:"index"`            // 章节序号，从 0 开始
+	Title  string `json:"title"`            // 标题行，如 "第十二章 归来"；第一个标题之前的内容（或整部小说没有标题时）为空
+	Volume string `json:"volume,omitempty"` // 所属分卷的标题，如 "卷一 风起"
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+// Text 返回章节内容（含标题行）
+func (c Chapter) Text(novel string) string {
+	return strings.TrimSpace(novel[c.Start:c.End])
+}
+
+// maxHeadingRunes 标题行的最大长度，更长的行视为正文（如以"第一章"开头的句子）
+const maxHeadingRunes = 40
+
+// 中文数字（含全角阿拉伯数字）
+const numerals = `0-9０-９零〇一二三四五六七八九十百千万两壹贰叁肆伍陆柒捌玖拾佰仟`
+
+var (
+	// volumeHeading 分卷标题，如 "第一卷 风起"、"卷一"、"卷之三"、"Volume 2"、"Book II"
+	volumeHeading = regexp.MustCompile(`^(第[` + numerals + `]+[卷部]|卷[之]?[` + numerals + `]+|(?i:volume|vol\.|book)[ \t]*[0-9ivxlc]+\b)`)
+
+	// chapterHeading 章节标题，如 "第十二章 归来"、"第3回"、"Chapter 7"、"楔子"
+	chapterHeading = regexp.MustCompile(`^(第[` + numerals + `]+[章回节话集篇]|(?i:chapter)[ \t]*[0-9ivxlc]+\b|序章|序幕|序言|楔子|引子|尾声|后记|番外)`)
+)
+
+// headingKind 标题行的类型
+type headingKind int
+
+const (
+	notHeading headingKind = iota
+	volumeLine
+	chapterLine
+)
+
+// classify 判断一行是否为分卷或章节标题
+func classify(line string) headingKind {
+	line = strings.TrimSpace(line)
+	if line == "" || utf8.RuneCountInString(line) > maxHeadingRunes {
+		return notHeading
+	}
+	// 以句号、逗号等结尾时更像正文，如 "第一章就这样结束了。"
+	if strings.ContainsAny(lastRune(line), "。；，,;") {
+		return notHeading
+	}
+	switch {
+	case chapterHeading.MatchString(line):
+		return chapterLine
+	case volumeHeading.MatchString(line):
+		return volumeLine
+	}
+	return notHeading
+}
+
+// lastRune 返回字符串的最后一个字符
+func lastRune(s string) string {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return string(r)
+}
+
+// Parse 按章节标题切分小说
+// 章节标题必须单独成行；分卷标题不单独成章，而是记录在其后各章节的 Volume 中
+// （分卷标题之后、第一个章节标题之前如果有正文，这段正文作为一个以分卷标题为标题的章节）。
+// 第一个标题之前的内容（如作品简介）作为标题为空的章节；没有识别到任何标题时整部小说为一个章节。
+// 只有标题行、没有正文的章节会被忽略
+func Parse(novel string) []Chapter {
+	var chapters []Chapter
+	volume := ""
+	title := ""
+	start := 0
+
+	// emit 结束当前章节，正文只有空白时丢弃
+	emit := func(end int, body string) {
+		if strings.TrimSpace(body) == "" {
+			return
+		}
+		chapters = append(chapters, Chapter{
+			Index:  len(chapters),
+			Title:  title,
+			Volume: volume,
+			Start:  start,
+			End:    end,
+		})
+	}
+
+	offset := 0
+	bodyStart := 0 // 当前章节正文（标题行之后）的起始位置
+	for offset < len(novel) {
+		lineEnd := strings.IndexByte(novel[offset:], '\n')
+		next := len(novel)
+		if lineEnd >= 0 {
+			next = offset + lineEnd + 1
+		}
+		line := strings.TrimRight(novel[offset:next], "\r\n")
+
+		switch classify(line) {
+		case chapterLine:
+			emit(offset, novel[bodyStart:offset])
+			title = strings.TrimSpace(line)
+			start, bodyStart = offset, next
+		case volumeLine:
+			emit(offset, novel[bodyStart:offset])
+			volume = strings.TrimSpace(line)
+			title = volume
+			start, bodyStart = offset, next
+		}
+		offset = next
+	}
+	emit(len(novel), novel[bodyStart:])
+
+	// 整部小说只有标题行时作为一个章节
+	if len(chapters) == 0 && strings.TrimSpace(novel) != "" {
+		chapters = append(chapters, Chapter{Title: firstLineHeading(novel), Start: 0, End: len(novel)})
+	}
+	return chapters
+}
+
+// firstLineHeading 整部小说只有标题行时，返回第一个标题
+func firstLineHeading(novel string) string {
+	for _, line := range strings.Split(novel, "\n") {
+		if classify(line) != notHeading {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// Range 连续的章节范围，From、To 为从 1 开始的章节编号（含两端）
+type Range struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// Group 每 size 个章节分为一组，最后一组可能不足 size 个
+func Group(total, size int) []Range {
+	if size <= 0 {
+		size = 1
+	}
+	var ranges []Range
+	for from := 1; from <= total; from += size {
+		ranges = append(ranges, Range{From: from, To: min(from+size-1, total)})
+	}
+	return ranges
+}
+
+// ValidateRanges 校验章节范围：按顺序排列、互不重叠，且都在 1..total 之内
+// 范围之间允许有间隔（跳过某些章节，如作品简介）
+func ValidateRanges(ranges []Range, total int) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("章节范围不能为空")
+	}
+	last := 0
+	for i, r := range ranges {
+		if r.From < 1 || r.To < r.From || r.To > total {
+			return fmt.Errorf("ranges[%d] 无效: %d-%d（共 %d 章）", i, r.From, r.To, total)
+		}
+		if r.From <= last {
+			return fmt.Errorf("ranges[%d] 与前一个范围重叠或顺序错误: %d-%d", i, r.From, r.To)
+		}
+		last = r.To
+	}
+	return nil
+}
+
+// Slice 返回范围内章节的原文，从第一个章节的开头到最后一个章节的结尾
+func Slice(novel string, chapters []Chapter, r Range) string {
+	return strings.TrimSpace(novel[chapters[r.From-1].Start:chapters[r.To-1].End])
+}
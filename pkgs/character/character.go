@@ -0,0 +1,310 @@
+// Package character 结构化的角色设定（角色圣经）
+// 剧本、图片提示词和音色匹配共用同一份设定，旧版剧本中角色名到描述字符串的格式仍可读取
+package character
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TxtAnime/txt-anime/pkgs/namekey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// 性别
+const (
+	Male   = "male"
+	Female = "female"
+)
+
+// 年龄段，见 AgeGroup
+const (
+	Child = "child" // 儿童（12 岁及以下）
+	Teen  = "teen"  // 少年（13~17 岁）
+	Adult = "adult" // 成年
+	Elder = "elder" // 老年（60 岁及以上）
+)
+
+// Character 角色设定
+// 旧版剧本中角色的值是一个描述字符串，读取时保存在 Description 中
+type Character struct {
+	Name        string   `json:"name,omitempty" bson:"name,omitempty"`               // 规范角色名，与 Bible 的 key 一致
+	Aliases     []string `json:"aliases,omitempty" bson:"aliases,omitempty"`         // 小说中的其他称呼，如 "小红"、"红帽子"
+	Age         string   `json:"age,omitempty" bson:"age,omitempty"`                 // 年龄，如 "8岁"、"十七八岁"、"中年"
+	Gender      string   `json:"gender,omitempty" bson:"gender,omitempty"`           // "male" 或 "female"，不确定时为空
+	Hair        string   `json:"hair,omitempty" bson:"hair,omitempty"`               // 发型、发色
+	Eyes        string   `json:"eyes,omitempty" bson:"eyes,omitempty"`               // 眼睛
+	Build       string   `json:"build,omitempty" bson:"build,omitempty"`             // 身材、身高
+	Outfit      string   `json:"outfit,omitempty" bson:"outfit,omitempty"`           // 默认服装
+	Personality string   `json:"personality,omitempty" bson:"personality,omitempty"` // 性格、气质
+	Voice       string   `json:"voice,omitempty" bson:"voice,omitempty"`             // 声音特点，如 "清脆稚嫩"、"低沉沙哑"
+	Description string   `json:"description,omitempty" bson:"description,omitempty"` // 其他显著的视觉特征
+}
+
+// Bible 剧本中的全部角色，key 为规范角色名
+type Bible map[string]Character
+
+// plain 不带自定义解码方法的 Character，避免递归
+type plain Character
+
+// UnmarshalJSON 解析角色设定，值为字符串时作为 Description（旧版 script.json）
+func (c *Character) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var description string
+		if err := json.Unmarshal(data, &description); err != nil {
+			return err
+		}
+		*c = Character{Description: description}
+		return nil
+	}
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*c = Character(p)
+	return nil
+}
+
+// UnmarshalBSONValue 解析 MongoDB 中的角色设定，值为字符串时作为 Description（旧版任务和项目文档）
+func (c *Character) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.String:
+		description, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return fmt.Errorf("角色描述不是合法的字符串")
+		}
+		*c = Character{Description: description}
+		return nil
+	case bsontype.EmbeddedDocument:
+		var p plain
+		if err := bson.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		*c = Character(p)
+		return nil
+	case bsontype.Null, bsontype.Undefined:
+		*c = Character{}
+		return nil
+	}
+	return fmt.Errorf("无法把 BSON 类型 %s 解析为角色设定", t)
+}
+
+// IsEmpty 角色没有任何设定
+func (c Character) IsEmpty() bool {
+	return strings.TrimSpace(c.Visual()+c.Personality+c.Voice) == ""
+}
+
+// Visual 返回角色的视觉描述，用于图片提示词
+// 由年龄、性别、发型、眼睛、身材、服装和其他特征组成；旧版角色只有 Description
+func (c Character) Visual() string {
+	parts := []string{c.Age, genderLabel(c.Gender), c.Hair, c.Eyes, c.Build, c.Outfit, c.Description}
+	return joinNonEmpty(parts, "，")
+}
+
+// Profile 返回角色的完整设定，用于音色匹配等需要性格和声音特点的场合
+func (c Character) Profile() string {
+	fields := []struct{ label, value string }{
+		{"性别", genderLabel(c.Gender)},
+		{"年龄", c.Age},
+		{"性格", c.Personality},
+		{"声音", c.Voice},
+		{"外貌", joinNonEmpty([]string{c.Hair, c.Eyes, c.Build, c.Outfit, c.Description}, "，")},
+	}
+	var parts []string
+	for _, f := range fields {
+		if f.value != "" {
+			parts = append(parts, f.label+": "+f.value)
+		}
+	}
+	return strings.Join(parts, "；")
+}
+
+// IsFemale 角色是否为女性；Gender 为空时从描述中推断
+func (c Character) IsFemale() bool {
+	return c.gender() == Female
+}
+
+// IsMale 角色是否为男性；Gender 为空时从描述中推断
+func (c Character) IsMale() bool {
+	return c.gender() == Male
+}
+
+// gender 返回规范化的性别，无法判断时为空
+func (c Character) gender() string {
+	if g := NormalizeGender(c.Gender); g != "" {
+		return g
+	}
+	text := strings.ToLower(c.Age + " " + c.Description)
+	switch {
+	case containsAny(text, "女", "female", "girl", "woman"):
+		return Female
+	case containsAny(text, "男", "male", "boy", "man"):
+		return Male
+	}
+	return ""
+}
+
+// NormalizeGender 把 "女"、"F"、"Female" 等写法统一为 Female / Male，无法识别时返回空
+func NormalizeGender(gender string) string {
+	switch strings.ToLower(strings.TrimSpace(gender)) {
+	case "female", "f", "woman", "girl", "女", "女性":
+		return Female
+	case "male", "m", "man", "boy", "男", "男性":
+		return Male
+	}
+	return ""
+}
+
+var (
+	// ageNumber Age 中的数字，如 "8"、"约17岁"
+	ageNumber = regexp.MustCompile(`\d+`)
+	// describedAge 描述中的年龄，如 "8岁女孩"
+	describedAge = regexp.MustCompile(`(\d+)\s*岁`)
+)
+
+// AgeGroup 返回角色的年龄段（Child / Teen / Adult / Elder），无法判断时为空
+// 优先使用年龄中的数字，其次按 "孩童"、"少年"、"老人" 等关键词判断；Age 为空时从描述中推断
+func (c Character) AgeGroup() string {
+	age, number := c.Age, ageNumber.FindString(c.Age)
+	if age == "" {
+		age = c.Description
+		if m := describedAge.FindStringSubmatch(age); m != nil {
+			number = m[1]
+		}
+	}
+	if number != "" {
+		if n, err := strconv.Atoi(number); err == nil {
+			switch {
+			case n <= 12:
+				return Child
+			case n <= 17:
+				return Teen
+			case n >= 60:
+				return Elder
+			}
+			return Adult
+		}
+	}
+	switch {
+	case containsAny(age, "儿童", "孩童", "小女孩", "小男孩", "女童", "男童", "幼", "child", "kid"):
+		return Child
+	case containsAny(age, "少年", "少女", "男孩", "女孩", "中学", "高中", "teen"):
+		return Teen
+	case containsAny(age, "老人", "老年", "年迈", "老妇", "老翁", "奶奶", "爷爷", "外婆", "外公", "花甲", "古稀", "elder"):
+		return Elder
+	case containsAny(age, "青年", "中年", "成年", "adult"):
+		return Adult
+	}
+	return ""
+}
+
+// Mentions 角色的任一设定中是否包含某个关键词（不区分大小写）
+func (c Character) Mentions(keywords ...string) bool {
+	text := strings.ToLower(strings.Join([]string{c.Age, c.Hair, c.Eyes, c.Build, c.Outfit, c.Personality, c.Voice, c.Description}, " "))
+	return containsAny(text, keywords...)
+}
+
+// Normalize 规范化全部角色：Name 与 key 一致、性别统一写法、去掉重复和与角色名相同的别名
+func (b Bible) Normalize() {
+	for name, c := range b {
+		c.Name = name
+		c.Gender = NormalizeGender(c.Gender)
+
+		seen := map[string]bool{name: true}
+		var aliases []string
+		for _, alias := range c.Aliases {
+			alias = strings.TrimSpace(alias)
+			if alias != "" && !seen[alias] {
+				seen[alias] = true
+				aliases = append(aliases, alias)
+			}
+		}
+		c.Aliases = aliases
+		b[name] = c
+	}
+}
+
+// genderLabel 性别的中文写法，用于提示词
+func genderLabel(gender string) string {
+	switch NormalizeGender(gender) {
+	case Female:
+		return "女"
+	case Male:
+		return "男"
+	}
+	return strings.TrimSpace(gender)
+}
+
+// joinNonEmpty 用 sep 连接非空的部分
+func joinNonEmpty(parts []string, sep string) string {
+	var out []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, sep)
+}
+
+// containsAny s 是否包含任一子串
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve 返回名字对应的规范角色名
+// 依次按角色名、别名、去掉空白和标点后的写法、包含关系（如 "红帽" 是 "小红帽" 的一部分）匹配，
+// 每一步只有唯一的候选角色时才采用；都匹配不到或有多个候选时返回 false
+func (b Bible) Resolve(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", false
+	}
+	if _, ok := b[name]; ok {
+		return name, true
+	}
+
+	key := namekey.Compact(name)
+	rules := []func(candidate string) bool{
+		func(candidate string) bool { return strings.TrimSpace(candidate) == name },
+		func(candidate string) bool { return namekey.Compact(candidate) == key },
+		// 包含关系只在名字至少两个字时使用，避免 "小" 之类的单字误匹配
+		func(candidate string) bool {
+			return utf8.RuneCountInString(key) >= 2 && strings.Contains(namekey.Compact(candidate), key)
+		},
+	}
+	for _, rule := range rules {
+		canonical, matches := b.find(rule)
+		switch {
+		case matches == 1:
+			return canonical, true
+		case matches > 1:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// find 返回角色名或任一别名满足 match 的角色，以及满足条件的角色数
+func (b Bible) find(match func(candidate string) bool) (string, int) {
+	found, matches := "", 0
+	for canonical, c := range b {
+		if match(canonical) || slices.ContainsFunc(c.Aliases, match) {
+			found = canonical
+			matches++
+		}
+	}
+	return found, matches
+}
@@ -0,0 +1,131 @@
+package comicpage
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/vector"
+)
+
+var (
+	inkColor     = color.RGBA{0x11, 0x11, 0x11, 0xff}
+	paperColor   = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	captionColor = color.RGBA{0xff, 0xf6, 0xd5, 0xff}
+	speakerColor = color.RGBA{0x66, 0x66, 0x66, 0xff}
+	emptyColor   = color.RGBA{0xe6, 0xe6, 0xe6, 0xff}
+)
+
+// point 浮点坐标
+type point struct{ x, y float64 }
+
+// fillPolygon 在 clip 范围内填充多边形
+func fillPolygon(dst *image.RGBA, clip image.Rectangle, pts []point, col color.Color) {
+	if len(pts) < 3 {
+		return
+	}
+	minX, minY, maxX, maxY := pts[0].x, pts[0].y, pts[0].x, pts[0].y
+	for _, p := range pts[1:] {
+		minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+		minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+	}
+	r := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+	r = r.Intersect(clip).Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	// 光栅化器的原点对应 r.Min，超出范围的路径会被裁剪
+	z := vector.NewRasterizer(r.Dx(), r.Dy())
+	ox, oy := float64(r.Min.X), float64(r.Min.Y)
+	z.MoveTo(float32(pts[0].x-ox), float32(pts[0].y-oy))
+	for _, p := range pts[1:] {
+		z.LineTo(float32(p.x-ox), float32(p.y-oy))
+	}
+	z.ClosePath()
+	z.Draw(dst, r, image.NewUniform(col), image.Point{})
+}
+
+// ellipsePoints 用多边形近似椭圆
+func ellipsePoints(cx, cy, rx, ry float64) []point {
+	const segments = 72
+	pts := make([]point, segments)
+	for i := range pts {
+		a := 2 * math.Pi * float64(i) / segments
+		pts[i] = point{cx + rx*math.Cos(a), cy + ry*math.Sin(a)}
+	}
+	return pts
+}
+
+// balloon 对话气泡：椭圆加指向说话人方向的尾巴
+type balloon struct {
+	cx, cy, rx, ry float64
+	tail           [3]point // 尾巴的两个底角（在椭圆内）和尖端
+	speaker        textBlock
+	text           textBlock
+}
+
+// newBalloon 以 (cx, cy) 为中心、rx 和 ry 为半径创建气泡，尾巴向 tailDir（-1 向左，1 向右）斜下方伸出
+func newBalloon(cx, cy, rx, ry float64, speaker, text textBlock, tailLen, tailDir float64) balloon {
+	b := balloon{cx: cx, cy: cy, rx: rx, ry: ry, speaker: speaker, text: text}
+	base := b.rx * 0.18
+	b.tail = [3]point{
+		{cx - base + tailDir*b.rx*0.2, cy + b.ry*0.6},
+		{cx + base + tailDir*b.rx*0.2, cy + b.ry*0.6},
+		{cx + tailDir*b.rx*0.55, cy + b.ry + tailLen},
+	}
+	return b
+}
+
+// balloonSize 返回容纳说话人和台词的气泡半径
+// 文字外接矩形的四角恰好落在放大 √2 倍的椭圆上，再留出 pad 的边距
+func balloonSize(speaker, text textBlock, pad float64) (rx, ry float64) {
+	w, h := textSize(speaker, text)
+	return w/2*math.Sqrt2 + pad, h/2*math.Sqrt2 + pad
+}
+
+// textSize 说话人和台词合起来的宽高
+func textSize(speaker, text textBlock) (float64, float64) {
+	return float64(max(speaker.width, text.width)), float64(speaker.height() + text.height())
+}
+
+// draw 绘制气泡：先用墨色画出轮廓，再用白色填充内部，尾巴与椭圆连成一体
+func (b balloon) draw(dst *image.RGBA, clip image.Rectangle, stroke float64) {
+	tailOuter := []point{
+		{b.tail[0].x - stroke, b.tail[0].y},
+		{b.tail[1].x + stroke, b.tail[1].y},
+		{b.tail[2].x, b.tail[2].y + stroke*1.5},
+	}
+	fillPolygon(dst, clip, ellipsePoints(b.cx, b.cy, b.rx+stroke, b.ry+stroke), inkColor)
+	fillPolygon(dst, clip, tailOuter, inkColor)
+	fillPolygon(dst, clip, ellipsePoints(b.cx, b.cy, b.rx, b.ry), paperColor)
+	fillPolygon(dst, clip, b.tail[:], paperColor)
+
+	sub := dst.SubImage(clip).(*image.RGBA)
+	w, h := textSize(b.speaker, b.text)
+	x := int(math.Round(b.cx - w/2))
+	y := int(math.Round(b.cy - h/2))
+	b.speaker.draw(sub, x, y, int(w), true, speakerColor)
+	b.text.draw(sub, x, y+b.speaker.height(), int(w), true, inkColor)
+}
+
+// bottom 气泡（含尾巴）的底边
+func (b balloon) bottom() float64 {
+	return b.tail[2].y
+}
+
+// caption 旁白框：带边框的矩形
+type caption struct {
+	rect image.Rectangle
+	text textBlock
+	pad  int
+}
+
+// draw 绘制旁白框
+func (c caption) draw(dst *image.RGBA, clip image.Rectangle, stroke int) {
+	sub := dst.SubImage(clip).(*image.RGBA)
+	draw.Draw(sub, c.rect.Inset(-stroke), image.NewUniform(inkColor), image.Point{}, draw.Src)
+	draw.Draw(sub, c.rect, image.NewUniform(captionColor), image.Point{}, draw.Src)
+	c.text.draw(sub, c.rect.Min.X+c.pad, c.rect.Min.Y+c.pad, c.text.width, false, inkColor)
+}
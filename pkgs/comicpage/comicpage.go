@@ -0,0 +1,276 @@
+// Package comicpage 漫画页排版
+// 把多张场景图片按版式模板排进一页的分格中，在分格上绘制对话气泡和旁白框，输出完整的漫画页图片
+package comicpage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font/opentype"
+)
+
+// Line 一句对话
+type Line struct {
+	Speaker string // 说话人，以小字显示在台词上方，为空时不显示
+	Text    string // 台词
+}
+
+// Panel 一个分格的内容
+type Panel struct {
+	Image     image.Image // 场景图片，按分格比例居中裁剪；为 nil 时分格留灰
+	Narration string      // 旁白，绘制在分格左上角的旁白框中
+	Dialogue  []Line      // 对话，按顺序自上而下、左右交替绘制为气泡
+}
+
+// Config 排版配置，为零值的字段使用默认值
+type Config struct {
+	Width    int            // 页面宽度，默认 1600
+	Height   int            // 页面高度，默认 2400
+	Margin   int            // 页边距，默认为页面宽度的 3%
+	Gutter   int            // 分格间距，默认为页面宽度的 1.5%
+	Border   int            // 分格边框宽度，默认为页面宽度的 0.25%
+	FontSize float64        // 台词字号（像素），默认为页面宽度的 1/50；分格中放不下时自动缩小
+	Font     *opentype.Font // 字体，默认为 DefaultFont 找到的中文字体
+	Layouts  []Layout       // 依次使用的版式，默认为 DefaultLayouts
+}
+
+// withDefaults 返回填充了默认值的配置
+func (c Config) withDefaults() (Config, error) {
+	if c.Width <= 0 {
+		c.Width = 1600
+	}
+	if c.Height <= 0 {
+		c.Height = 2400
+	}
+	if c.Margin <= 0 {
+		c.Margin = c.Width * 3 / 100
+	}
+	if c.Gutter <= 0 {
+		c.Gutter = c.Width * 15 / 1000
+	}
+	if c.Border <= 0 {
+		c.Border = max(c.Width/400, 1)
+	}
+	if c.FontSize <= 0 {
+		c.FontSize = float64(c.Width) / 50
+	}
+	if c.Font == nil {
+		c.Font = DefaultFont()
+	}
+	if len(c.Layouts) == 0 {
+		for _, name := range DefaultLayouts {
+			l, _ := LookupLayout(name)
+			c.Layouts = append(c.Layouts, l)
+		}
+	}
+	for _, l := range c.Layouts {
+		if err := l.Validate(); err != nil {
+			return c, err
+		}
+	}
+	if 2*c.Margin >= c.Width || 2*c.Margin >= c.Height {
+		return c, fmt.Errorf("页边距 %d 超出页面尺寸 %dx%d", c.Margin, c.Width, c.Height)
+	}
+	return c, nil
+}
+
+// Page 排好的一页
+type Page struct {
+	Layout string      // 使用的版式名
+	Panels []int       // 该页包含的分格在输入中的下标
+	Image  *image.RGBA // 页面图片
+}
+
+// Compose 按版式把分格依次排成若干页，见 Paginate
+func Compose(panels []Panel, cfg Config) ([]Page, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	faces := &faceCache{font: cfg.Font}
+	defer faces.close()
+
+	var pages []Page
+	next := 0
+	for _, layout := range Paginate(len(panels), cfg.Layouts) {
+		end := min(next+len(layout.Panels), len(panels))
+		img, err := composePage(panels[next:end], layout, cfg, faces)
+		if err != nil {
+			return nil, fmt.Errorf("排版第 %d 页失败: %w", len(pages)+1, err)
+		}
+		page := Page{Layout: layout.Name, Image: img}
+		for i := next; i < end; i++ {
+			page.Panels = append(page.Panels, i)
+		}
+		pages = append(pages, page)
+		next = end
+	}
+	return pages, nil
+}
+
+// ComposePage 按指定版式排一页，分格多于版式的分格数时多出的不排，少于时多出的分格留空
+func ComposePage(panels []Panel, layout Layout, cfg Config) (*image.RGBA, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if err := layout.Validate(); err != nil {
+		return nil, err
+	}
+	faces := &faceCache{font: cfg.Font}
+	defer faces.close()
+	return composePage(panels, layout, cfg, faces)
+}
+
+// composePage 排一页：白色页面，每个分格画边框、场景图片、旁白框和对话气泡
+func composePage(panels []Panel, layout Layout, cfg Config, faces *faceCache) (*image.RGBA, error) {
+	page := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	draw.Draw(page, page.Bounds(), image.NewUniform(paperColor), image.Point{}, draw.Src)
+
+	content := page.Bounds().Inset(cfg.Margin)
+	for i, rect := range layout.panelRects(content, cfg.Gutter) {
+		if i >= len(panels) {
+			break
+		}
+		if err := drawPanel(page, rect, panels[i], cfg, faces); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// drawPanel 绘制一个分格
+func drawPanel(page *image.RGBA, rect image.Rectangle, panel Panel, cfg Config, faces *faceCache) error {
+	draw.Draw(page, rect.Inset(-cfg.Border), image.NewUniform(inkColor), image.Point{}, draw.Src)
+	if panel.Image != nil {
+		xdraw.CatmullRom.Scale(page, rect, panel.Image, coverRect(panel.Image.Bounds(), rect), draw.Src, nil)
+	} else {
+		draw.Draw(page, rect, image.NewUniform(emptyColor), image.Point{}, draw.Src)
+	}
+
+	overlay, err := layoutOverlay(rect, panel, cfg.FontSize, faces)
+	if err != nil {
+		return err
+	}
+	overlay.draw(page, rect, cfg.Border)
+	return nil
+}
+
+// coverRect 返回 src 中与 dst 宽高比相同、居中的最大区域，缩放后铺满分格
+func coverRect(src, dst image.Rectangle) image.Rectangle {
+	sw, sh := src.Dx(), src.Dy()
+	if sw == 0 || sh == 0 || dst.Dx() == 0 || dst.Dy() == 0 {
+		return src
+	}
+	if sw*dst.Dy() > sh*dst.Dx() {
+		w := sh * dst.Dx() / dst.Dy()
+		x := src.Min.X + (sw-w)/2
+		return image.Rect(x, src.Min.Y, x+w, src.Max.Y)
+	}
+	h := sw * dst.Dy() / dst.Dx()
+	y := src.Min.Y + (sh-h)/2
+	return image.Rect(src.Min.X, y, src.Max.X, y+h)
+}
+
+// overlay 分格上的文字：旁白框和对话气泡
+type overlay struct {
+	caption  *caption
+	balloons []balloon
+	bottom   float64 // 最后一个元素的底边
+}
+
+// draw 绘制旁白框和气泡，超出分格的部分被裁掉
+func (o overlay) draw(page *image.RGBA, clip image.Rectangle, stroke int) {
+	if o.caption != nil {
+		o.caption.draw(page, clip, stroke)
+	}
+	for _, b := range o.balloons {
+		b.draw(page, clip, float64(stroke))
+	}
+}
+
+// fontScales 文字放不下时依次尝试的字号缩放比例
+var fontScales = []float64{1, 0.85, 0.7, 0.55}
+
+// layoutOverlay 排布分格中的旁白框和气泡
+// 旁白框在左上角，气泡自上而下、左右交替排列，尾巴指向分格中部；放不下时缩小字号，最小一档仍放不下时超出部分被裁掉
+func layoutOverlay(rect image.Rectangle, panel Panel, fontSize float64, faces *faceCache) (overlay, error) {
+	var o overlay
+	for _, scale := range fontScales {
+		var err error
+		o, err = layoutOverlayAt(rect, panel, fontSize*scale, faces)
+		if err != nil {
+			return o, err
+		}
+		if o.bottom <= float64(rect.Max.Y) {
+			break
+		}
+	}
+	return o, nil
+}
+
+// layoutOverlayAt 以指定字号排布分格中的旁白框和气泡
+func layoutOverlayAt(rect image.Rectangle, panel Panel, size float64, faces *faceCache) (overlay, error) {
+	var o overlay
+	inset := size * 0.6
+	pad := size * 0.5
+	y := float64(rect.Min.Y) + inset
+
+	if panel.Narration != "" {
+		face, err := faces.face(size * 0.9)
+		if err != nil {
+			return o, err
+		}
+		p := int(pad)
+		text := newTextBlock(face, panel.Narration, int(float64(rect.Dx())*0.85)-2*p)
+		x0, y0 := rect.Min.X+int(inset), int(y)
+		o.caption = &caption{
+			rect: image.Rect(x0, y0, x0+text.width+2*p, y0+text.height()+2*p),
+			text: text,
+			pad:  p,
+		}
+		y = float64(o.caption.rect.Max.Y) + inset
+	}
+
+	textFace, err := faces.face(size)
+	if err != nil {
+		return o, err
+	}
+	speakerFace, err := faces.face(size * 0.7)
+	if err != nil {
+		return o, err
+	}
+	maxTextWidth := int(float64(rect.Dx()) * 0.42)
+	for i, line := range panel.Dialogue {
+		var speaker textBlock
+		if line.Speaker != "" {
+			speaker = newTextBlock(speakerFace, line.Speaker, maxTextWidth)
+		}
+		text := newTextBlock(textFace, line.Text, maxTextWidth)
+
+		rx, ry := balloonSize(speaker, text, pad)
+		rx = min(rx, float64(rect.Dx())/2-inset)
+		cx, tailDir := float64(rect.Min.X)+inset+rx, 1.0
+		if i%2 == 1 {
+			cx, tailDir = float64(rect.Max.X)-inset-rx, -1.0
+		}
+		b := newBalloon(cx, y+ry, rx, ry, speaker, text, size, tailDir)
+		o.balloons = append(o.balloons, b)
+		y = b.bottom() + inset*0.5
+	}
+	o.bottom = y
+	return o, nil
+}
+
+// EncodePNG 将页面编码为 PNG
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("编码 PNG 失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,172 @@
+package comicpage
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+)
+
+// DefaultLayouts 未指定版式时依次使用的版式
+var DefaultLayouts = []string{"feature-top", "staggered", "grid-2x2"}
+
+// Rect 分格在页面内容区中的位置，取值为 0~1 的比例
+type Rect struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+// Layout 版式模板：一页中各分格的位置，按阅读顺序排列
+type Layout struct {
+	Name   string `json:"name"`
+	Panels []Rect `json:"panels"`
+}
+
+// edgeEpsilon 判断分格是否贴着内容区边缘时允许的误差
+const edgeEpsilon = 0.001
+
+// Validate 校验版式：至少一个分格，分格宽高为正且不超出内容区
+func (l Layout) Validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("版式名不能为空")
+	}
+	if len(l.Panels) == 0 {
+		return fmt.Errorf("版式 %s 至少需要一个分格", l.Name)
+	}
+	for i, r := range l.Panels {
+		if r.W <= 0 || r.H <= 0 {
+			return fmt.Errorf("版式 %s 的第 %d 个分格宽高必须为正数", l.Name, i+1)
+		}
+		if r.X < 0 || r.Y < 0 || r.X+r.W > 1+edgeEpsilon || r.Y+r.H > 1+edgeEpsilon {
+			return fmt.Errorf("版式 %s 的第 %d 个分格超出页面", l.Name, i+1)
+		}
+	}
+	return nil
+}
+
+// panelRects 计算各分格在页面上的像素位置
+// 分格之间留出 gutter 的间距，贴着内容区边缘的一侧不留
+func (l Layout) panelRects(content image.Rectangle, gutter int) []image.Rectangle {
+	half := float64(gutter) / 2
+	w, h := float64(content.Dx()), float64(content.Dy())
+
+	rects := make([]image.Rectangle, 0, len(l.Panels))
+	for _, r := range l.Panels {
+		x0, y0 := r.X*w, r.Y*h
+		x1, y1 := (r.X+r.W)*w, (r.Y+r.H)*h
+		if r.X > edgeEpsilon {
+			x0 += half
+		}
+		if r.Y > edgeEpsilon {
+			y0 += half
+		}
+		if r.X+r.W < 1-edgeEpsilon {
+			x1 -= half
+		}
+		if r.Y+r.H < 1-edgeEpsilon {
+			y1 -= half
+		}
+		rects = append(rects, image.Rect(
+			content.Min.X+int(math.Round(x0)), content.Min.Y+int(math.Round(y0)),
+			content.Min.X+int(math.Round(x1)), content.Min.Y+int(math.Round(y1)),
+		))
+	}
+	return rects
+}
+
+//go:embed layouts.json
+var layoutsJSON []byte
+
+// layouts 内置的版式，保持 layouts.json 中的顺序
+var layouts = mustParseLayouts(layoutsJSON)
+
+// mustParseLayouts 解析内置版式，数据文件有误时 panic
+func mustParseLayouts(data []byte) []Layout {
+	list, err := ParseLayouts(data)
+	if err != nil {
+		panic(fmt.Sprintf("解析内置版式失败: %v", err))
+	}
+	for _, name := range DefaultLayouts {
+		if _, ok := findLayout(list, name); !ok {
+			panic(fmt.Sprintf("缺少默认版式 %s", name))
+		}
+	}
+	return list
+}
+
+// ParseLayouts 解析 JSON 格式的版式列表并校验，版式名不能重复
+// 格式与内置的 layouts.json 相同，用于加载自定义版式
+func ParseLayouts(data []byte) ([]Layout, error) {
+	var list []Layout
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("解析版式失败: %w", err)
+	}
+	seen := make(map[string]bool, len(list))
+	for _, l := range list {
+		if err := l.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[l.Name] {
+			return nil, fmt.Errorf("版式名重复: %s", l.Name)
+		}
+		seen[l.Name] = true
+	}
+	return list, nil
+}
+
+// Layouts 返回全部内置版式
+func Layouts() []Layout {
+	return append([]Layout(nil), layouts...)
+}
+
+// LookupLayout 按名称查找内置版式
+func LookupLayout(name string) (Layout, bool) {
+	return findLayout(layouts, name)
+}
+
+// findLayout 在 list 中按名称查找版式
+func findLayout(list []Layout, name string) (Layout, bool) {
+	for _, l := range list {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return Layout{}, false
+}
+
+// Paginate 将 n 个分格分配到各页
+// 依次循环使用 sequence 中的版式；剩余分格不足一页时，改用 sequence 或内置版式中分格数恰好相等的版式，
+// 没有这样的版式时仍使用原版式，多出的分格留空
+func Paginate(n int, sequence []Layout) []Layout {
+	if len(sequence) == 0 {
+		return nil
+	}
+
+	var pages []Layout
+	for i := 0; n > 0; i++ {
+		l := sequence[i%len(sequence)]
+		if n < len(l.Panels) {
+			if fit, ok := layoutWithPanels(sequence, n); ok {
+				l = fit
+			} else if fit, ok := layoutWithPanels(layouts, n); ok {
+				l = fit
+			}
+		}
+		pages = append(pages, l)
+		n -= len(l.Panels)
+	}
+	return pages
+}
+
+// layoutWithPanels 返回 list 中第一个分格数为 n 的版式
+func layoutWithPanels(list []Layout, n int) (Layout, bool) {
+	for _, l := range list {
+		if len(l.Panels) == n {
+			return l, true
+		}
+	}
+	return Layout{}, false
+}
@@ -0,0 +1,221 @@
+package comicpage
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font/opentype"
+)
+
+// StripConfig 条漫排版配置，为零值的字段使用默认值
+type StripConfig struct {
+	Width     int            // 条漫宽度，默认 800
+	MaxHeight int            // 每段的最大高度，默认 1280；超过时切分为多段
+	Gutter    int            // 分格间距，默认为宽度的 1/8
+	FontSize  float64        // 台词字号（像素），默认为宽度的 1/32；放不下时自动缩小
+	Font      *opentype.Font // 字体，默认为 DefaultFont 找到的中文字体
+}
+
+// withDefaults 返回填充了默认值的配置
+func (c StripConfig) withDefaults() StripConfig {
+	if c.Width <= 0 {
+		c.Width = 800
+	}
+	if c.MaxHeight <= 0 {
+		c.MaxHeight = 1280
+	}
+	if c.Gutter <= 0 {
+		c.Gutter = c.Width / 8
+	}
+	if c.FontSize <= 0 {
+		c.FontSize = float64(c.Width) / 32
+	}
+	if c.Font == nil {
+		c.Font = DefaultFont()
+	}
+	return c
+}
+
+// Segment 切分后的一段条漫
+type Segment struct {
+	Panels []int       // 该段包含（或部分包含）的分格在输入中的下标
+	Image  *image.RGBA // 该段图片，高度不超过 MaxHeight
+}
+
+// stripBlock 一个分格排好的图片块（旁白框、场景图片、对话气泡和下方的间距）中的 [y0, y1) 部分
+// 块高于 MaxHeight 时被切成多个部分，分别输出到相邻的段中
+type stripBlock struct {
+	panel  int
+	img    *image.RGBA
+	y0, y1 int
+}
+
+// height 该部分的高度
+func (b stripBlock) height() int {
+	return b.y1 - b.y0
+}
+
+// StripWriter 逐个追加分格，纵向拼接为条漫，累计高度达到 MaxHeight 时切出一段交给回调
+// 同一时刻只保留不到一段的图片，适合场景很多的任务。分格下方的间距保留在段中，各段依次相接时与未切分时一致
+type StripWriter struct {
+	cfg     StripConfig
+	faces   *faceCache
+	emit    func(Segment) error
+	pending []stripBlock
+	height  int
+	next    int // 下一个分格的下标
+}
+
+// NewStripWriter 创建条漫写入器，每切出一段调用一次 emit；用完后需调用 Close 输出最后一段
+func NewStripWriter(cfg StripConfig, emit func(Segment) error) (*StripWriter, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Gutter >= cfg.MaxHeight {
+		return nil, fmt.Errorf("分格间距 %d 不能超过每段最大高度 %d", cfg.Gutter, cfg.MaxHeight)
+	}
+	return &StripWriter{cfg: cfg, faces: &faceCache{font: cfg.Font}, emit: emit}, nil
+}
+
+// Add 追加一个分格
+// 放不进当前段时先输出当前段；单个分格高于 MaxHeight 时在分格中间切开
+func (w *StripWriter) Add(panel Panel) error {
+	img, err := w.renderBlock(panel)
+	if err != nil {
+		return fmt.Errorf("排版第 %d 个分格失败: %w", w.next+1, err)
+	}
+	block := stripBlock{panel: w.next, img: img, y1: img.Bounds().Dy()}
+	w.next++
+
+	if w.height > 0 && w.height+block.height() > w.cfg.MaxHeight {
+		if err := w.flush(0); err != nil {
+			return err
+		}
+	}
+	if h := block.height(); h > w.cfg.MaxHeight {
+		// 切成高度相近的几部分，避免最后剩下很窄的一条；最后一部分与后面的分格拼在同一段
+		n := (h + w.cfg.MaxHeight - 1) / w.cfg.MaxHeight
+		size := (h + n - 1) / n
+		for block.height() > size {
+			part := block
+			part.y1 = block.y0 + size
+			w.pending = append(w.pending, part)
+			w.height = part.height()
+			if err := w.flush(0); err != nil {
+				return err
+			}
+			block.y0 = part.y1
+		}
+	}
+	w.pending = append(w.pending, block)
+	w.height += block.height()
+	return nil
+}
+
+// Close 输出最后一段（去掉末尾的分格间距）并释放字体
+func (w *StripWriter) Close() error {
+	defer w.faces.close()
+	trim := 0
+	if len(w.pending) > 0 && w.pending[len(w.pending)-1].height() > w.cfg.Gutter {
+		trim = w.cfg.Gutter
+	}
+	return w.flush(trim)
+}
+
+// Discard 丢弃尚未输出的部分并释放字体，出错中止时代替 Close 调用
+func (w *StripWriter) Discard() {
+	w.faces.close()
+	w.pending, w.height = nil, 0
+}
+
+// flush 把累计的图片块拼成一段输出，末尾去掉 trim 像素
+func (w *StripWriter) flush(trim int) error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	seg := Segment{Image: image.NewRGBA(image.Rect(0, 0, w.cfg.Width, w.height-trim))}
+	y := 0
+	for _, b := range w.pending {
+		r := image.Rect(0, y, w.cfg.Width, y+b.height())
+		draw.Draw(seg.Image, r, b.img, image.Pt(0, b.y0), draw.Src)
+		y += b.height()
+		if len(seg.Panels) == 0 || seg.Panels[len(seg.Panels)-1] != b.panel {
+			seg.Panels = append(seg.Panels, b.panel)
+		}
+	}
+	w.pending, w.height = nil, 0
+	return w.emit(seg)
+}
+
+// renderBlock 排一个分格：旁白框居中放在场景图片上方的留白中，对话气泡叠加在图片上，图片下方留出分格间距
+func (w *StripWriter) renderBlock(panel Panel) (*image.RGBA, error) {
+	width, gutter := w.cfg.Width, w.cfg.Gutter
+
+	var capt *caption
+	top := 0
+	if panel.Narration != "" {
+		size := w.cfg.FontSize * 0.9
+		face, err := w.faces.face(size)
+		if err != nil {
+			return nil, err
+		}
+		pad := int(size * 0.6)
+		text := newTextBlock(face, panel.Narration, width*4/5-2*pad)
+		cw, ch := text.width+2*pad, text.height()+2*pad
+		x0 := (width - cw) / 2
+		capt = &caption{rect: image.Rect(x0, gutter/2, x0+cw, gutter/2+ch), text: text, pad: pad}
+		top = ch + gutter
+	}
+
+	imgHeight := width
+	if panel.Image != nil {
+		b := panel.Image.Bounds()
+		if b.Dx() > 0 {
+			imgHeight = b.Dy() * width / b.Dx()
+		}
+	}
+	block := image.NewRGBA(image.Rect(0, 0, width, top+imgHeight+gutter))
+	draw.Draw(block, block.Bounds(), image.NewUniform(paperColor), image.Point{}, draw.Src)
+
+	stroke := max(width/400, 1)
+	if capt != nil {
+		capt.draw(block, block.Bounds(), stroke)
+	}
+
+	rect := image.Rect(0, top, width, top+imgHeight)
+	if panel.Image != nil {
+		xdraw.CatmullRom.Scale(block, rect, panel.Image, panel.Image.Bounds(), draw.Src, nil)
+	} else {
+		draw.Draw(block, rect, image.NewUniform(emptyColor), image.Point{}, draw.Src)
+	}
+
+	o, err := layoutOverlay(rect, Panel{Dialogue: panel.Dialogue}, w.cfg.FontSize, w.faces)
+	if err != nil {
+		return nil, err
+	}
+	o.draw(block, rect, stroke)
+	return block, nil
+}
+
+// ComposeStrip 把分格纵向拼接为条漫，按 MaxHeight 切分为若干段，见 StripWriter
+func ComposeStrip(panels []Panel, cfg StripConfig) ([]Segment, error) {
+	var segments []Segment
+	w, err := NewStripWriter(cfg, func(seg Segment) error {
+		segments = append(segments, seg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, panel := range panels {
+		if err := w.Add(panel); err != nil {
+			w.Discard()
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
@@ -0,0 +1,249 @@
+package comicpage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// systemFontPaths 未指定字体时依次查找的中文字体
+var systemFontPaths = []string{
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/noto/NotoSansCJK-Regular.ttc", // alpine font-noto-cjk
+	"/usr/share/fonts/noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/google-noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-microhei.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-zenhei.ttc",
+	"/usr/share/fonts/wqy-microhei/wqy-microhei.ttc",
+	"/System/Library/Fonts/PingFang.ttc",
+	"/System/Library/Fonts/STHeiti Medium.ttc",
+	`C:\Windows\Fonts\msyh.ttc`,
+	`C:\Windows\Fonts\simhei.ttf`,
+}
+
+// LoadFont 加载 TrueType / OpenType 字体文件，字体集合（.ttc）使用其中的第一个字体
+func LoadFont(path string) (*opentype.Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取字体文件失败: %w", err)
+	}
+	if bytes.HasPrefix(data, []byte("ttcf")) {
+		collection, err := opentype.ParseCollection(data)
+		if err != nil {
+			return nil, fmt.Errorf("解析字体集合失败: %w", err)
+		}
+		f, err := collection.Font(0)
+		if err != nil {
+			return nil, fmt.Errorf("解析字体集合失败: %w", err)
+		}
+		return f, nil
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析字体失败: %w", err)
+	}
+	return f, nil
+}
+
+// FindFont 查找系统中的中文字体（Noto Sans CJK、文泉驿、苹方、微软雅黑等），返回字体及其路径，都找不到时返回错误
+func FindFont() (*opentype.Font, string, error) {
+	for _, path := range systemFontPaths {
+		if f, err := LoadFont(path); err == nil && HasCJK(f) {
+			return f, path, nil
+		}
+	}
+	return nil, "", fmt.Errorf("未找到中文字体，已查找: %s", strings.Join(systemFontPaths, ", "))
+}
+
+// HasCJK 字体是否包含常用汉字，不包含时中文会显示为方框
+func HasCJK(f *opentype.Font) bool {
+	var buf sfnt.Buffer
+	for _, r := range "中文漫画" {
+		idx, err := f.GlyphIndex(&buf, r)
+		if err != nil || idx == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultFont DefaultFont 的查找结果，只查找一次
+var defaultFont = sync.OnceValue(func() *opentype.Font {
+	if f, _, err := FindFont(); err == nil {
+		return f
+	}
+	fmt.Printf("⚠️  未找到中文字体，对话气泡中的中文将无法显示，请配置字体文件路径\n")
+	f, _ := opentype.Parse(goregular.TTF)
+	return f
+})
+
+// DefaultFont 查找系统中的中文字体，见 FindFont
+// 都找不到时使用内置的 Go 字体，此时中文无法显示，会打印警告；服务端应在启动时用 FindFont 检查
+func DefaultFont() *opentype.Font {
+	return defaultFont()
+}
+
+// faceCache 按字号缓存字体 face
+type faceCache struct {
+	font  *opentype.Font
+	faces map[float64]font.Face
+}
+
+// face 返回指定字号的 face
+func (c *faceCache) face(size float64) (font.Face, error) {
+	if face, ok := c.faces[size]; ok {
+		return face, nil
+	}
+	face, err := opentype.NewFace(c.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("创建字体失败: %w", err)
+	}
+	if c.faces == nil {
+		c.faces = make(map[float64]font.Face)
+	}
+	c.faces[size] = face
+	return face, nil
+}
+
+// close 释放缓存的 face
+func (c *faceCache) close() {
+	for _, face := range c.faces {
+		face.Close()
+	}
+}
+
+// textBlock 换行后的一段文字
+type textBlock struct {
+	face       font.Face
+	lines      []string
+	width      int // 最宽一行的宽度
+	lineHeight int
+}
+
+// height 文字块的高度
+func (b textBlock) height() int {
+	return len(b.lines) * b.lineHeight
+}
+
+// newTextBlock 按最大宽度对文字换行
+func newTextBlock(face font.Face, text string, maxWidth int) textBlock {
+	b := textBlock{face: face, lineHeight: face.Metrics().Height.Ceil()}
+	for _, paragraph := range strings.Split(strings.TrimSpace(text), "\n") {
+		for _, line := range wrapText(face, paragraph, maxWidth) {
+			b.lines = append(b.lines, line)
+			if w := font.MeasureString(face, line).Ceil(); w > b.width {
+				b.width = w
+			}
+		}
+	}
+	return b
+}
+
+// draw 以 (x, y) 为左上角绘制文字；center 为 true 时每行在宽度 width 内居中
+func (b textBlock) draw(dst *image.RGBA, x, y, width int, center bool, col color.Color) {
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(col), Face: b.face}
+	ascent := b.face.Metrics().Ascent.Ceil()
+	for i, line := range b.lines {
+		lx := x
+		if center {
+			lx += (width - font.MeasureString(b.face, line).Ceil()) / 2
+		}
+		d.Dot = fixed.P(lx, y+i*b.lineHeight+ascent)
+		d.DrawString(line)
+	}
+}
+
+// noLineStart 不能出现在行首的标点（避头）
+const noLineStart = "，。、；：！？）》」』】〕”’…—,.;:!?)]}%"
+
+// noLineEnd 不能出现在行尾的标点（避尾）
+const noLineEnd = "（《「『【〔“‘([{"
+
+// wrapText 按最大宽度换行
+// 中日韩文字可以在任意两个字之间断行，连续的英文字母和数字作为一个单词不拆开，超宽的单词单独成行；
+// 行首的句末标点挤回上一行，行尾的开括号移到下一行
+func wrapText(face font.Face, text string, maxWidth int) []string {
+	var lines []string
+	var line []string
+	lineWidth := 0
+	flush := func() {
+		lines = append(lines, strings.TrimRight(strings.Join(line, ""), " "))
+		line, lineWidth = nil, 0
+	}
+
+	for _, token := range tokenize(text) {
+		w := font.MeasureString(face, token).Ceil()
+		if len(line) == 0 && token == " " {
+			continue
+		}
+		if lineWidth+w <= maxWidth || len(line) == 0 {
+			line = append(line, token)
+			lineWidth += w
+			continue
+		}
+
+		switch {
+		case strings.Contains(noLineStart, token):
+			// 避头：标点跟在上一行末尾，允许稍微超出
+			line = append(line, token)
+			lineWidth += w
+			continue
+		case strings.Contains(noLineEnd, line[len(line)-1]) && len(line) > 1:
+			// 避尾：开括号移到下一行
+			last := line[len(line)-1]
+			line = line[:len(line)-1]
+			flush()
+			line = append(line, last)
+			lineWidth = font.MeasureString(face, last).Ceil()
+		default:
+			flush()
+		}
+		if token == " " {
+			continue
+		}
+		line = append(line, token)
+		lineWidth += w
+	}
+	if len(line) > 0 {
+		flush()
+	}
+	return lines
+}
+
+// tokenize 将文字拆分为换行的最小单位：单个中日韩字符或标点、单个空格、连续的字母数字
+func tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flushWord := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '-'):
+			word.WriteRune(r)
+		case unicode.IsSpace(r):
+			flushWord()
+			tokens = append(tokens, " ")
+		default:
+			flushWord()
+			tokens = append(tokens, string(r))
+		}
+	}
+	flushWord()
+	return tokens
+}
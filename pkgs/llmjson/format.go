@@ -0,0 +1,147 @@
+package llmjson
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Format 请求模型输出 JSON 的方式
+type Format string
+
+const (
+	FormatPrompt     Format = ""            // 只在提示词中要求返回 JSON（默认）
+	FormatJSONObject Format = "json_object" // response_format 为 json_object（JSON 模式）
+	FormatJSONSchema Format = "json_schema" // response_format 为 json_schema，Schema 由结果的 Go 类型生成
+)
+
+// ParseFormat 解析配置中的输出方式，"" 和 "prompt" 表示只靠提示词
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatPrompt, "prompt":
+		return FormatPrompt, nil
+	case FormatJSONObject, FormatJSONSchema:
+		return Format(s), nil
+	}
+	return FormatPrompt, fmt.Errorf("不支持的 JSON 输出方式 %q，可选值: prompt、json_object、json_schema", s)
+}
+
+// level 输出方式的等级，服务商拒绝时逐级降低
+func (f Format) level() int {
+	switch f {
+	case FormatJSONSchema:
+		return 2
+	case FormatJSONObject:
+		return 1
+	}
+	return 0
+}
+
+// fallback 返回降低一级后的输出方式
+func (f Format) fallback() Format {
+	if f == FormatJSONSchema {
+		return FormatJSONObject
+	}
+	return FormatPrompt
+}
+
+// supported 记录每个服务商和模型实际支持的输出方式，key 为 "<Provider>|<Model>"
+// 降级后的请求成功时写入，之后的调用直接使用降级后的方式
+var supported sync.Map
+
+// effectiveFormat 返回请求的方式和已知支持的方式中较低的一个
+func effectiveFormat(key string, requested Format) Format {
+	if known, ok := supported.Load(key); ok && known.(Format).level() < requested.level() {
+		return known.(Format)
+	}
+	return requested
+}
+
+// responseFormat 构建 response_format 参数，FormatPrompt 时为 nil
+func responseFormat(format Format, schema *Schema, v any) *openai.ChatCompletionResponseFormat {
+	switch format {
+	case FormatJSONObject:
+		return &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	case FormatJSONSchema:
+		// 映射类型（如角色名到描述）无法满足 strict 模式的要求，只按 Schema 引导输出，结果仍由 Validate 校验
+		return &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   schemaName(v),
+				Schema: schema,
+			},
+		}
+	}
+	return nil
+}
+
+// schemaName 返回结果类型的名称，用作 json_schema 的 name
+func schemaName(v any) string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return "response"
+	}
+	return t.Name()
+}
+
+// create 按 opts.Format 设置 response_format 调用模型
+// 服务商拒绝该参数（400、422、501）时逐级降级重试：json_schema → json_object → 只靠提示词；
+// 降级后的请求成功时记住该服务商和模型支持的方式，之后的调用不再尝试更高的方式
+func create(ctx context.Context, client *openai.Client, req openai.ChatCompletionRequest, schema *Schema, v any, opts Options) (openai.ChatCompletionResponse, error) {
+	key := opts.Provider + "|" + req.Model
+	requested := effectiveFormat(key, opts.Format)
+
+	format := requested
+	for {
+		req.ResponseFormat = responseFormat(format, schema, v)
+		resp, err := client.CreateChatCompletion(ctx, req)
+		if err == nil {
+			if format != requested {
+				supported.Store(key, format)
+			}
+			return resp, nil
+		}
+		if format == FormatPrompt || !isFormatRejected(err) {
+			return resp, err
+		}
+
+		next := format.fallback()
+		fmt.Printf("⚠️  模型 %s 拒绝 response_format=%s，改用 %s 重试: %v\n", req.Model, format, formatName(next), err)
+		format = next
+	}
+}
+
+// formatName 返回输出方式的名称，用于日志
+func formatName(f Format) string {
+	if f == FormatPrompt {
+		return "prompt"
+	}
+	return string(f)
+}
+
+// isFormatRejected 判断错误是否可能是服务商不支持 response_format 造成的
+// 服务商的错误信息各不相同，这里按状态码判断；误判时降级后的请求仍会失败，不会被记住
+func isFormatRejected(err error) bool {
+	status := 0
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
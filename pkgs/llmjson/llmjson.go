@@ -0,0 +1,162 @@
+// Package llmjson 调用模型生成 JSON，并按结果类型的 Schema 和业务规则校验；
+// 校验失败时把错误发回给模型修正，最多重试 MaxRepairs 次
+package llmjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// DefaultMaxRepairs 默认的修正次数
+const DefaultMaxRepairs = 2
+
+// Options 调用选项
+type Options struct {
+	// Format 请求模型输出 JSON 的方式，服务商不支持时自动降级，见 create
+	Format Format
+	// Provider 服务商标识（如 BaseURL），与模型名一起记录服务商支持的输出方式
+	Provider string
+	// MaxRepairs 校验失败后最多让模型修正的次数；0 使用 DefaultMaxRepairs，负数表示不修正
+	MaxRepairs int
+	// Check 结果解析到 v 之后的业务校验（如场景编号连续、说话人已知），返回的错误会发回给模型修正
+	Check func() error
+	// OnRepair 每次请求模型修正前回调，attempt 从 1 开始，为空时不输出
+	OnRepair func(attempt int, err error)
+}
+
+// maxRepairs 返回修正次数
+func (o Options) maxRepairs() int {
+	switch {
+	case o.MaxRepairs < 0:
+		return 0
+	case o.MaxRepairs == 0:
+		return DefaultMaxRepairs
+	}
+	return o.MaxRepairs
+}
+
+// ValidationError 模型输出没有通过校验
+type ValidationError struct {
+	Problems []string // 每条为一个不符合的地方，如 "$.characters.小红帽: 应为 string，实际为 object"
+	Content  string   // 模型的原始输出（已去掉代码块标记）
+}
+
+func (e *ValidationError) Error() string {
+	return "模型输出校验失败: " + strings.Join(e.Problems, "; ")
+}
+
+// Complete 调用模型并把返回的 JSON 解析到 v（指针）
+// opts.Format 不为空时通过 response_format 要求模型输出 JSON；
+// 依次进行：提取 JSON、按 SchemaOf(v) 校验结构和类型、解析、opts.Check 业务校验；
+// 任一步失败时把模型的输出和错误列表追加到对话中请模型修正，超过修正次数后返回最后一次的 *ValidationError。
+// 模型调用本身失败（网络、限流等）时直接返回错误，不计入修正次数
+func Complete(ctx context.Context, client *openai.Client, req openai.ChatCompletionRequest, v any, opts Options) error {
+	schema := SchemaOf(v)
+	messages := append([]openai.ChatCompletionMessage(nil), req.Messages...)
+
+	var lastErr *ValidationError
+	for attempt := 0; attempt <= opts.maxRepairs(); attempt++ {
+		if attempt > 0 {
+			if opts.OnRepair != nil {
+				opts.OnRepair(attempt, lastErr)
+			}
+			messages = append(messages,
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: lastErr.Content},
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: repairPrompt(lastErr.Problems)},
+			)
+		}
+
+		req.Messages = messages
+		resp, err := create(ctx, client, req, schema, v, opts)
+		if err != nil {
+			return fmt.Errorf("API调用失败: %w", err)
+		}
+		if len(resp.Choices) == 0 {
+			return fmt.Errorf("API返回空响应")
+		}
+
+		content := Extract(resp.Choices[0].Message.Content)
+		problems := Decode([]byte(content), schema, v)
+		if len(problems) == 0 && opts.Check != nil {
+			problems = checkProblems(opts.Check())
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		lastErr = &ValidationError{Problems: problems, Content: content}
+	}
+	return lastErr
+}
+
+// Decode 按 schema 校验 data，通过后解析到 v；返回不符合的地方，全部通过时返回 nil
+func Decode(data []byte, schema *Schema, v any) []string {
+	if problems := schema.Validate(data); len(problems) > 0 {
+		return problems
+	}
+	// 清空上一次尝试解析的结果，避免映射中残留旧的键
+	reflect.ValueOf(v).Elem().SetZero()
+	if err := json.Unmarshal(data, v); err != nil {
+		return []string{fmt.Sprintf("解析失败: %v", err)}
+	}
+	return nil
+}
+
+// checkProblems 把业务校验的错误展开为问题列表，errors.Join 合并的错误逐条列出
+func checkProblems(err error) []string {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []string{err.Error()}
+	}
+	var problems []string
+	for _, e := range joined.Unwrap() {
+		problems = append(problems, e.Error())
+	}
+	return problems
+}
+
+// repairPrompt 构建请模型修正的提示词
+func repairPrompt(problems []string) string {
+	var sb strings.Builder
+	sb.WriteString("你上一次返回的JSON没有通过校验，存在以下问题($ 表示JSON根对象):\n")
+	for _, p := range problems {
+		sb.WriteString("- ")
+		sb.WriteString(p)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n请修正以上问题，按原来的要求重新返回完整的JSON。不要省略未修改的部分，不要添加其他说明文字。")
+	return sb.String()
+}
+
+// Extract 从模型输出中提取 JSON：去掉 markdown 代码块标记和 JSON 前后的说明文字
+func Extract(content string) string {
+	content = strings.TrimSpace(content)
+
+	// 移除markdown代码块标记（```json 或 ```）
+	if strings.HasPrefix(content, "```") {
+		if i := strings.IndexByte(content, '\n'); i >= 0 {
+			content = content[i+1:]
+		} else {
+			content = strings.TrimPrefix(content, "```")
+		}
+		if i := strings.LastIndex(content, "```"); i >= 0 {
+			content = content[:i]
+		}
+	}
+	content = strings.TrimSpace(content)
+
+	// 取第一个 { 到最后一个 } 之间的对象
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start != -1 && end > start {
+		content = content[start : end+1]
+	}
+	return content
+}
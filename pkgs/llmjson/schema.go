@@ -0,0 +1,214 @@
+package llmjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Schema JSON Schema 的一个子集，足以描述模型输出的结构体、数组、映射和基本类型
+// 由 SchemaOf 根据 Go 类型生成：字段名取 json tag，带 omitempty 的字段可选，其余字段必填；
+// 结构体不允许未知字段，映射的值类型放在 AdditionalProperties 中
+type Schema struct {
+	Type                 string             `json:"type"` // object / array / string / integer / number / boolean
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"-"` // 映射的值类型
+
+	Closed   bool `json:"-"` // 结构体：不允许 Properties 之外的字段
+	Nullable bool `json:"-"` // 允许 null（切片、映射和指针）
+}
+
+// MarshalJSON 输出标准的 JSON Schema
+// Closed 输出为 "additionalProperties": false，Nullable 输出为 "type": ["<type>", "null"]
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	out := struct {
+		*plain
+		Type                 any `json:"type"`
+		AdditionalProperties any `json:"additionalProperties,omitempty"`
+	}{plain: (*plain)(s), Type: s.Type}
+
+	if s.Nullable {
+		out.Type = []string{s.Type, "null"}
+	}
+	switch {
+	case s.AdditionalProperties != nil:
+		out.AdditionalProperties = s.AdditionalProperties
+	case s.Closed:
+		out.AdditionalProperties = false
+	}
+	return json.Marshal(out)
+}
+
+// SchemaOf 根据 v 的类型生成 Schema，v 通常为指向结果结构体的指针
+func SchemaOf(v any) *Schema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return schemaOf(t)
+}
+
+// schemaOf 递归生成类型的 Schema
+func schemaOf(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaOf(t.Elem())
+		s.Nullable = true
+		return s
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, Closed: true}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitempty, ok := jsonField(field)
+			if !ok {
+				continue
+			}
+			s.Properties[name] = schemaOf(field.Type)
+			if !omitempty {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem()), Nullable: true}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	// interface{} 等无法描述的类型不做限制
+	return &Schema{}
+}
+
+// jsonField 返回结构体字段的 JSON 名称，以及是否带 omitempty；未导出或 json:"-" 的字段返回 ok=false
+func jsonField(field reflect.StructField) (name string, omitempty bool, ok bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "omitempty" || opt == "omitzero" {
+			omitempty = true
+		}
+	}
+	return name, omitempty, true
+}
+
+// maxErrors 一次校验最多报告的错误数，避免修正提示过长
+const maxErrors = 20
+
+// Validate 按 Schema 校验 JSON 数据，返回所有不符合的地方（最多 maxErrors 条）
+func (s *Schema) Validate(data []byte) []string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("不是合法的 JSON: %v", err)}
+	}
+	if decoder.More() {
+		return []string{"JSON 之后存在多余内容"}
+	}
+
+	var errs []string
+	s.validate("$", value, &errs)
+	return errs
+}
+
+// validate 递归校验 value，错误追加到 errs
+func (s *Schema) validate(path string, value any, errs *[]string) {
+	if len(*errs) >= maxErrors || s.Type == "" {
+		return
+	}
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if value == nil {
+		if !s.Nullable {
+			fail("应为 %s，实际为 null", s.Type)
+		}
+		return
+	}
+	if actual := jsonType(value); !typeMatches(s.Type, actual, value) {
+		fail("应为 %s，实际为 %s", s.Type, actual)
+		return
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("缺少必填字段 %q", name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "." + key
+			if prop, ok := s.Properties[key]; ok {
+				prop.validate(child, v[key], errs)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(child, v[key], errs)
+			} else if s.Closed {
+				fail("存在未定义的字段 %q", key)
+			}
+		}
+	case []any:
+		if s.Items == nil {
+			return
+		}
+		for i, item := range v {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+	}
+}
+
+// jsonType 返回解码后的值对应的 JSON 类型名
+func jsonType(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	}
+	return "unknown"
+}
+
+// typeMatches 判断实际类型是否符合期望类型，integer 要求数字没有小数部分
+func typeMatches(expected, actual string, value any) bool {
+	if expected == "integer" && actual == "number" {
+		_, err := value.(json.Number).Int64()
+		return err == nil
+	}
+	return expected == actual
+}
@@ -0,0 +1,81 @@
+// Package location 结构化的地点设定（地点圣经）
+// 同一地点在不同场景中使用同一份视觉描述生成图片，保证反复出现的场景前后一致
+package location
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/TxtAnime/txt-anime/pkgs/namekey"
+)
+
+// Location 地点设定
+type Location struct {
+	ID          string   `json:"id,omitempty" bson:"id,omitempty"`           // 地点 ID，与 Bible 的 key 一致
+	Name        string   `json:"name" bson:"name"`                           // 地点名称，如 "外婆家"
+	Aliases     []string `json:"aliases,omitempty" bson:"aliases,omitempty"` // 小说中的其他称呼，如 "林中小屋"
+	Description string   `json:"description" bson:"description"`             // 规范的视觉描述：建筑风格、布局、标志性陈设、材质和色调
+}
+
+// Bible 剧本中的全部地点，key 为地点 ID（如 "grandma_house"）
+type Bible map[string]Location
+
+// Visual 返回地点的视觉描述，用于图片提示词
+func (l Location) Visual() string {
+	return strings.TrimSpace(l.Description)
+}
+
+// Normalize 规范化全部地点：ID 与 key 一致、去掉名称两端的空白、去掉重复和与名称相同的别名
+func (b Bible) Normalize() {
+	for id, l := range b {
+		l.ID = id
+		l.Name = strings.TrimSpace(l.Name)
+
+		seen := map[string]bool{l.Name: true}
+		var aliases []string
+		for _, alias := range l.Aliases {
+			alias = strings.TrimSpace(alias)
+			if alias != "" && !seen[alias] {
+				seen[alias] = true
+				aliases = append(aliases, alias)
+			}
+		}
+		l.Aliases = aliases
+		b[id] = l
+	}
+}
+
+// Resolve 返回 ref 对应的地点 ID
+// 依次按地点 ID、名称或别名、去掉空白和标点后的写法匹配，每一步只有唯一的候选地点时才采用；
+// 模型有时在 location_id 中直接写地点名称，用于把它对应回地点 ID
+func (b Bible) Resolve(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", false
+	}
+	if _, ok := b[ref]; ok {
+		return ref, true
+	}
+
+	key := namekey.Compact(ref)
+	rules := []func(candidate string) bool{
+		func(candidate string) bool { return strings.TrimSpace(candidate) == ref },
+		func(candidate string) bool { return namekey.Compact(candidate) == key },
+	}
+	for _, rule := range rules {
+		found, matches := "", 0
+		for id, l := range b {
+			if rule(id) || rule(l.Name) || slices.ContainsFunc(l.Aliases, rule) {
+				found = id
+				matches++
+			}
+		}
+		switch {
+		case matches == 1:
+			return found, true
+		case matches > 1:
+			return "", false
+		}
+	}
+	return "", false
+}
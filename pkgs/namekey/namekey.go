@@ -0,0 +1,18 @@
+// Package namekey 名字的比较键，角色圣经和地点圣经按比较键匹配同一名字的不同写法
+package namekey
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Compact 去掉空白、标点（含下划线）和符号，英文转为小写
+// 用于比较名字的不同写法，如 "小 红帽"、"Alice·Liddell"、"grandma_house" 和 "Grandma House"
+func Compact(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
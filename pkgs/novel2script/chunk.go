@@ -0,0 +1,183 @@
+package novel2script
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/TxtAnime/txt-anime/pkgs/chapter"
+)
+
+// Chunk 长篇小说的一个分段
+type Chunk struct {
+	Index int    // 分段序号，从 0 开始
+	Title string // 分段中第一个章节的标题，没有识别到章节时为空
+	Text  string // 分段原文
+}
+
+// sentenceEnd 句末标点，超长段落按句子切分
+var sentenceEnd = regexp.MustCompile(`[。！？!?…]+[”’"』」）)]*|\.[ \t]+`)
+
+// SplitChunks 将小说切分为若干分段，每段估算 token 数不超过 maxTokens
+// 优先在章节边界（见 chapter.Parse）切分，多个短章节合并为一段；单个章节过长时按段落切分，段落过长时按句子切分
+// 小说不超过 maxTokens 时返回一个分段
+func SplitChunks(text string, maxTokens int) []Chunk {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	chapters := chapter.Parse(text)
+	if maxTokens <= 0 || EstimateTokens(text) <= maxTokens {
+		title := ""
+		if len(chapters) > 0 {
+			title = chapters[0].Title
+		}
+		return []Chunk{{Text: text, Title: title}}
+	}
+
+	// 切成不超过上限的片段，再按顺序合并
+	type piece struct {
+		title string
+		text  string
+	}
+	var pieces []piece
+	for _, ch := range chapters {
+		for _, part := range splitToFit(ch.Text(text), maxTokens) {
+			pieces = append(pieces, piece{title: ch.Title, text: part})
+		}
+	}
+
+	var chunks []Chunk
+	var current []string
+	var title string
+	tokens := 0
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		chunks = append(chunks, Chunk{Index: len(chunks), Title: title, Text: strings.Join(current, "\n\n")})
+		current, title, tokens = nil, "", 0
+	}
+	for _, p := range pieces {
+		n := EstimateTokens(p.text)
+		if tokens > 0 && tokens+n > maxTokens {
+			flush()
+		}
+		if len(current) == 0 {
+			title = p.title
+		}
+		current = append(current, p.text)
+		tokens += n
+	}
+	flush()
+	return chunks
+}
+
+// EstimateTokens 估算文本的 token 数
+// 中日韩文字和全角标点按每字 1 个 token，其余字符按每 4 个 1 个 token
+func EstimateTokens(text string) int {
+	wide, other := 0, 0
+	for _, r := range text {
+		if isWide(r) {
+			wide++
+		} else {
+			other++
+		}
+	}
+	return wide + (other+3)/4
+}
+
+// isWide 是否为中日韩文字或全角符号
+func isWide(r rune) bool {
+	return r >= 0x2E80 || unicode.Is(unicode.Han, r)
+}
+
+// splitToFit 将超过上限的文本依次按段落、句子、字符切分，使每段不超过 maxTokens
+func splitToFit(text string, maxTokens int) []string {
+	if EstimateTokens(text) <= maxTokens {
+		return []string{text}
+	}
+
+	var units []string
+	if paragraphs := splitParagraphs(text); len(paragraphs) > 1 {
+		units = paragraphs
+	} else if sentences := splitSentences(text); len(sentences) > 1 {
+		units = sentences
+	} else {
+		return splitRunes(text, maxTokens)
+	}
+
+	// 递归保证每个单元不超过上限，再把相邻的小单元合并
+	var parts []string
+	var current strings.Builder
+	tokens := 0
+	for _, unit := range units {
+		for _, u := range splitToFit(unit, maxTokens) {
+			n := EstimateTokens(u)
+			if tokens > 0 && tokens+n > maxTokens {
+				parts = append(parts, current.String())
+				current.Reset()
+				tokens = 0
+			}
+			if current.Len() > 0 {
+				current.WriteString("\n")
+			}
+			current.WriteString(u)
+			tokens += n
+		}
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// splitParagraphs 按行切分，去掉空行
+func splitParagraphs(text string) []string {
+	var paragraphs []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paragraphs = append(paragraphs, line)
+		}
+	}
+	return paragraphs
+}
+
+// splitSentences 在句末标点之后切分
+func splitSentences(text string) []string {
+	var sentences []string
+	last := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(text, -1) {
+		if s := strings.TrimSpace(text[last:loc[1]]); s != "" {
+			sentences = append(sentences, s)
+		}
+		last = loc[1]
+	}
+	if s := strings.TrimSpace(text[last:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// splitRunes 没有可用的切分点时，按字符硬切
+func splitRunes(text string, maxTokens int) []string {
+	var parts []string
+	for text != "" {
+		end, wide, other := 0, 0, 0
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			w, o := wide, other
+			if isWide(r) {
+				w++
+			} else {
+				o++
+			}
+			if end > 0 && w+(o+3)/4 > maxTokens {
+				break
+			}
+			wide, other = w, o
+			end += size
+		}
+		parts = append(parts, text[:end])
+		text = text[end:]
+	}
+	return parts
+}
@@ -0,0 +1,62 @@
+package novel2script
+
+// 场景和对话的稳定 ID
+// scene_id 和 line_id 在剧本编辑（插入、删除、调整顺序）后保持不变，
+// 场景图片和音频文件以它们命名（scene_001.png、scene_001_dialogue_002.mp3），因此编辑剧本不会让产物错位
+
+// AssignLineIDs 为没有 line_id 的对话分配 ID
+// 从场景内已有的最大 line_id 开始递增；未编辑过的剧本中 line_id 即为从 1 开始的序号
+func (r *Response) AssignLineIDs() {
+	for i := range r.Script {
+		scene := &r.Script[i]
+		next := scene.MaxLineID()
+		for j := range scene.Dialogue {
+			if scene.Dialogue[j].LineID == 0 {
+				next++
+				scene.Dialogue[j].LineID = next
+			}
+		}
+	}
+}
+
+// NextSceneID 返回新场景可用的 scene_id
+func (r *Response) NextSceneID() int {
+	maxID := 0
+	for _, scene := range r.Script {
+		if scene.SceneID > maxID {
+			maxID = scene.SceneID
+		}
+	}
+	return maxID + 1
+}
+
+// FindScene 返回 scene_id 对应场景的下标，不存在时返回 -1
+func (r *Response) FindScene(sceneID int) int {
+	for i, scene := range r.Script {
+		if scene.SceneID == sceneID {
+			return i
+		}
+	}
+	return -1
+}
+
+// MaxLineID 返回场景内最大的 line_id
+func (s *Scene) MaxLineID() int {
+	maxID := 0
+	for _, d := range s.Dialogue {
+		if d.LineID > maxID {
+			maxID = d.LineID
+		}
+	}
+	return maxID
+}
+
+// FindLine 返回 line_id 对应对话的下标，不存在时返回 -1
+func (s *Scene) FindLine(lineID int) int {
+	for i, d := range s.Dialogue {
+		if d.LineID == lineID {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,253 @@
+package novel2script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// chunkResponse 单个分段的生成结果
+type chunkResponse struct {
+	Script     []Scene         `json:"script"`
+	Characters character.Bible `json:"characters"`
+	Locations  location.Bible  `json:"locations"`
+	Summary    string          `json:"summary"` // 截至本段的剧情梗概，传给下一段
+}
+
+// storyState 逐段生成时向后传递的上下文
+type storyState struct {
+	summary    string          // 前文剧情梗概
+	characters character.Bible // 已设计的角色，key 为规范角色名
+	locations  location.Bible  // 已设计的地点，key 为地点 ID
+}
+
+// Prior 前文信息
+// 一部小说拆分为多个任务依次生成时，后续任务携带前文的剧情梗概和已设计的角色、地点，保证角色和场景的形象前后一致
+type Prior struct {
+	Summary    string          `json:"summary" bson:"summary"`       // 前文剧情梗概
+	Characters character.Bible `json:"characters" bson:"characters"` // 已设计的角色
+	Locations  location.Bible  `json:"locations" bson:"locations"`   // 已设计的地点
+}
+
+// ProcessContinuation 在前文的基础上为小说的后续部分生成剧本
+// 已设计的角色和地点沿用原名（ID）和原描述，返回的剧本 Characters、Locations 包含本部分出现的已有角色、地点和新设计的；
+// 同时返回更新后的前文信息（截至本部分的剧情梗概和全部角色、地点），用于生成下一部分
+func ProcessContinuation(ctx context.Context, novelText string, prior Prior, cfg Config) (*Response, *Prior, error) {
+	state := storyState{
+		summary:    prior.Summary,
+		characters: make(character.Bible, len(prior.Characters)),
+		locations:  knownLocations(prior.Locations, nil),
+	}
+	for name, desc := range prior.Characters {
+		state.characters[name] = desc
+	}
+
+	chunks := SplitChunks(novelText, cfg.maxChunkTokens())
+	response, state, err := processChunks(ctx, newClient(cfg), chunks, state, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, &Prior{Summary: state.summary, Characters: state.characters, Locations: state.locations}, nil
+}
+
+// processChunks 分段生成剧本（map），每段携带前文梗概和已有角色、地点；最后合并为一个剧本（reduce）
+// 分段之间有依赖，按顺序依次调用模型。返回合并后的剧本和处理完所有分段后的上下文
+func processChunks(ctx context.Context, client *openai.Client, chunks []Chunk, state storyState, cfg Config) (*Response, storyState, error) {
+	results := make([]chunkResponse, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return nil, state, err
+		}
+
+		var result chunkResponse
+		prompt := buildChunkPrompt(chunk, len(chunks), state)
+		check := func() error {
+			// 场景可以引用前文已设计的角色和地点；generated 与 result 共用场景，location_id 的规范化对 result 同样生效
+			generated := &Response{
+				Script:     result.Script,
+				Characters: knownCharacters(result.Characters, state.characters),
+				Locations:  knownLocations(result.Locations, state.locations),
+			}
+			generated.canonicalizeLocations(nil)
+			return checkGenerated(generated)
+		}
+		if err := complete(ctx, client, cfg, prompt, &result, check); err != nil {
+			return nil, state, fmt.Errorf("第 %d/%d 段: %w", chunk.Index+1, len(chunks), err)
+		}
+		result.Characters.Normalize()
+		result.Locations.Normalize()
+		results = append(results, result)
+
+		// 已有角色和地点保持原描述，只补充新设计的
+		for name, c := range result.Characters {
+			if _, ok := state.characters[name]; !ok && !c.IsEmpty() {
+				state.characters[name] = c
+			}
+		}
+		for id, l := range result.Locations {
+			if _, ok := state.locations[id]; !ok && l.Visual() != "" {
+				state.locations[id] = l
+			}
+		}
+		if summary := strings.TrimSpace(result.Summary); summary != "" {
+			state.summary = summary
+		}
+	}
+
+	response := mergeChunks(results)
+	renamed := response.Canonicalize(state.characters)
+	addKnownCharacters(response, state.characters)
+	addKnownLocations(response, state.locations)
+	printRenamed(renamed, response.UnresolvedNames())
+	if err := response.Validate(); err != nil {
+		return nil, state, fmt.Errorf("剧本校验失败: %w", err)
+	}
+	return response, state, nil
+}
+
+// addKnownCharacters 已设计的角色统一使用最初的描述，并补充剧本中出场、但本次没有输出描述的已有角色
+func addKnownCharacters(response *Response, known character.Bible) {
+	for name := range response.Characters {
+		if c, ok := known[name]; ok {
+			response.Characters[name] = c
+		}
+	}
+	add := func(name string) {
+		if _, ok := response.Characters[name]; ok {
+			return
+		}
+		if c, ok := known[name]; ok {
+			response.Characters[name] = c
+		}
+	}
+	for _, scene := range response.Script {
+		for _, name := range scene.CharactersPresent {
+			add(name)
+		}
+		for _, d := range scene.Dialogue {
+			add(d.Character)
+		}
+	}
+}
+
+// addKnownLocations 已设计的地点统一使用最初的描述，并补充场景引用、但本次没有输出设定的已有地点
+func addKnownLocations(response *Response, known location.Bible) {
+	for id := range response.Locations {
+		if l, ok := known[id]; ok {
+			response.Locations[id] = l
+		}
+	}
+	for _, scene := range response.Script {
+		if _, ok := response.Locations[scene.LocationID]; ok {
+			continue
+		}
+		if l, ok := known[scene.LocationID]; ok {
+			response.Locations[scene.LocationID] = l
+		}
+	}
+}
+
+// mergeChunks 合并各分段的结果
+// 场景按分段顺序拼接，scene_id 从 1 重新编号，line_id 在场景内重新编号；
+// 同名角色和同一 ID 的地点保留第一次出现时的描述，保证前后画面一致
+func mergeChunks(results []chunkResponse) *Response {
+	response := &Response{Characters: make(character.Bible), Locations: make(location.Bible)}
+	for _, result := range results {
+		for _, scene := range result.Script {
+			scene.SceneID = len(response.Script) + 1
+			for j := range scene.Dialogue {
+				scene.Dialogue[j].LineID = j + 1
+			}
+			response.Script = append(response.Script, scene)
+		}
+		for name, c := range result.Characters {
+			name = strings.TrimSpace(name)
+			if _, ok := response.Characters[name]; !ok && !c.IsEmpty() {
+				response.Characters[name] = c
+			}
+		}
+		for id, l := range result.Locations {
+			id = strings.TrimSpace(id)
+			if _, ok := response.Locations[id]; !ok && l.Visual() != "" {
+				response.Locations[id] = l
+			}
+		}
+	}
+	return response
+}
+
+// buildChunkPrompt 构建分段生成的提示词
+func buildChunkPrompt(chunk Chunk, total int, state storyState) string {
+	var background strings.Builder
+	if state.summary != "" {
+		fmt.Fprintf(&background, "前情提要(此前各段的剧情梗概):\n%s\n\n", state.summary)
+	}
+	if len(state.characters) > 0 {
+		names := make([]string, 0, len(state.characters))
+		for name := range state.characters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		background.WriteString("已设计的角色(角色名和设定):\n")
+		for _, name := range names {
+			c := state.characters[name]
+			c.Name = ""
+			data, _ := json.Marshal(c)
+			fmt.Fprintf(&background, "- %s: %s\n", name, data)
+		}
+		background.WriteString("\n")
+	}
+	if len(state.locations) > 0 {
+		ids := make([]string, 0, len(state.locations))
+		for id := range state.locations {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		background.WriteString("已设计的地点(地点 ID 和设定):\n")
+		for _, id := range ids {
+			l := state.locations[id]
+			l.ID = ""
+			data, _ := json.Marshal(l)
+			fmt.Fprintf(&background, "- %s: %s\n", id, data)
+		}
+		background.WriteString("\n")
+	}
+
+	title := ""
+	if chunk.Title != "" {
+		title = fmt.Sprintf("(从「%s」开始)", chunk.Title)
+	}
+
+	return fmt.Sprintf(`这是一部长篇小说的第 %d/%d 段%s。请将这一段改编成结构化的视觉剧本格式,并设计本段新出场的主要角色的视觉描述。
+
+%s要求:
+%s
+
+分段要求:
+- 只改编本段的内容,前情提要仅用于理解上下文,不要为前文重复生成场景。
+- scene_id 从1开始在本段内编号即可,合并时会统一重新编号。
+- 已设计的角色必须沿用上面给出的角色名(包括 characters_present 和 dialogue 中的 character),不要使用别名或改名。
+- characters 只包含本段新出场的角色(包括出场或说话的次要角色),已设计的角色不要重复输出。
+- 场景发生在已设计的地点时,location_id 必须使用上面给出的地点 ID;locations 只包含本段新出现的地点,已设计的地点不要重复输出。
+- summary: 截至本段结尾的完整剧情梗概(包含前情提要中的内容),300字以内,供后续分段参考。
+
+请以JSON格式返回,包含四个字段:
+- script: 场景数组
+- characters: 新角色名到角色设定对象的映射,没有新角色时为 {};本段 characters_present 和 dialogue 中出现的新角色(旁白除外)都必须在这里给出设定
+- locations: 新地点 ID 到地点设定对象的映射,没有新地点时为 {}
+- summary: 剧情梗概字符串
+
+本段小说内容:
+%s
+
+请直接返回JSON,不要添加其他说明文字。`, chunk.Index+1, total, title, background.String(), scriptRequirements, chunk.Text)
+}
@@ -0,0 +1,155 @@
+package novel2script
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
+)
+
+// 角色名规范化
+// 模型经常用不同的称呼指代同一个角色（"小红帽"、"红帽"、"女孩"），图片提示词和音色匹配都按角色名查找 Characters，
+// 所以生成剧本后把 characters_present 和对话的 character 统一为 Characters 中的规范角色名
+
+// narrator 旁白，不是角色，不参与规范化
+const narrator = "旁白"
+
+// UnresolvedName 无法对应到 Characters 中任何角色的名字
+// 这些名字生成图片时只写角色名，生成语音时使用默认音色；可以通过编辑剧本新增角色或修改名字
+type UnresolvedName struct {
+	Name   string `json:"name" bson:"name"`
+	Scenes []int  `json:"scenes" bson:"scenes"` // 出现的场景 scene_id
+	Lines  int    `json:"lines" bson:"lines"`   // 作为说话人的对话数
+}
+
+// Canonicalize 把场景中的 characters_present 和对话的 character 统一为规范角色名（见 character.Bible.Resolve）
+// known 为剧本之外已设计的角色（如项目中之前的部分），可以为 nil；改名后 characters_present 去重。
+// 返回改名记录（原名到规范角色名），无法对应的名字保持不变，见 UnresolvedNames
+func (r *Response) Canonicalize(known character.Bible) map[string]string {
+	bible := knownCharacters(r.Characters, known)
+
+	renamed := make(map[string]string)
+	resolve := func(name string) string {
+		name = strings.TrimSpace(name)
+		if name == narrator {
+			return name
+		}
+		if canonical, ok := bible.Resolve(name); ok {
+			if canonical != name {
+				renamed[name] = canonical
+			}
+			return canonical
+		}
+		return name
+	}
+
+	for i := range r.Script {
+		scene := &r.Script[i]
+		present := make([]string, 0, len(scene.CharactersPresent))
+		seen := make(map[string]bool, len(scene.CharactersPresent))
+		for _, name := range scene.CharactersPresent {
+			name = resolve(name)
+			if !seen[name] {
+				seen[name] = true
+				present = append(present, name)
+			}
+		}
+		scene.CharactersPresent = present
+
+		for j := range scene.Dialogue {
+			scene.Dialogue[j].Character = resolve(scene.Dialogue[j].Character)
+		}
+	}
+	return renamed
+}
+
+// UnresolvedNames 返回场景中不在 Characters 里的名字，按第一次出现的顺序排列
+func (r *Response) UnresolvedNames() []UnresolvedName {
+	var names []UnresolvedName
+	index := make(map[string]int)
+	add := func(name string, sceneID int, line bool) {
+		name = strings.TrimSpace(name)
+		if name == "" || name == narrator {
+			return
+		}
+		if _, ok := r.Characters[name]; ok {
+			return
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(names)
+			index[name] = i
+			names = append(names, UnresolvedName{Name: name})
+		}
+		u := &names[i]
+		if n := len(u.Scenes); n == 0 || u.Scenes[n-1] != sceneID {
+			u.Scenes = append(u.Scenes, sceneID)
+		}
+		if line {
+			u.Lines++
+		}
+	}
+
+	for _, scene := range r.Script {
+		for _, name := range scene.CharactersPresent {
+			add(name, scene.SceneID, false)
+		}
+		for _, d := range scene.Dialogue {
+			add(d.Character, scene.SceneID, true)
+		}
+	}
+	return names
+}
+
+// canonicalizeLocations 把场景的 location_id 统一为地点 ID（见 location.Bible.Resolve）
+// known 为剧本之外已设计的地点，可以为 nil；无法对应的 location_id 保持不变，由 Validate 报告
+func (r *Response) canonicalizeLocations(known location.Bible) {
+	bible := knownLocations(r.Locations, known)
+	for i := range r.Script {
+		scene := &r.Script[i]
+		if id, ok := bible.Resolve(scene.LocationID); ok {
+			scene.LocationID = id
+		}
+	}
+}
+
+// knownCharacters 返回 characters 与 known 合并后的角色，同一角色以 characters 为准
+func knownCharacters(characters, known character.Bible) character.Bible {
+	merged := make(character.Bible, len(characters)+len(known))
+	for name, c := range known {
+		merged[name] = c
+	}
+	for name, c := range characters {
+		merged[name] = c
+	}
+	return merged
+}
+
+// knownLocations 返回 locations 与 known 合并后的地点，同一 ID 以 known 为准
+func knownLocations(locations, known location.Bible) location.Bible {
+	merged := make(location.Bible, len(locations)+len(known))
+	for id, l := range locations {
+		merged[id] = l
+	}
+	for id, l := range known {
+		merged[id] = l
+	}
+	return merged
+}
+
+// printRenamed 输出角色名规范化的结果
+func printRenamed(renamed map[string]string, unresolved []UnresolvedName) {
+	names := make([]string, 0, len(renamed))
+	for name := range renamed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("🔗 角色名规范化: %s → %s\n", name, renamed[name])
+	}
+	for _, u := range unresolved {
+		fmt.Printf("⚠️  未知角色: %s（场景 %v，%d 句对话）\n", u.Name, u.Scenes, u.Lines)
+	}
+}
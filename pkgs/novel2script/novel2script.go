@@ -3,22 +3,28 @@ package novel2script
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"net/http"
 
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/llmjson"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
 	openai "github.com/sashabaranov/go-openai"
 )
 
 const (
 	DefaultBaseURL = "https://openai.qiniu.com/v1"
 	DefaultModel   = "deepseek-v3"
+
+	// DefaultMaxChunkTokens 默认的分段 token 上限，为模型上下文留出提示词、前情提要和输出的空间
+	DefaultMaxChunkTokens = 12000
 )
 
 // Scene 场景结构
 type Scene struct {
 	SceneID           int            `json:"scene_id"`
 	Location          string         `json:"location"`
+	LocationID        string         `json:"location_id,omitempty"` // 地点 ID，对应 Response.Locations 的 key；旧版剧本没有
 	TimeOfDay         string         `json:"time_of_day"`
 	CharactersPresent []string       `json:"characters_present"`
 	SceneDescription  string         `json:"scene_description"`
@@ -28,6 +34,7 @@ type Scene struct {
 
 // DialogueLine 对话行
 type DialogueLine struct {
+	LineID    int    `json:"line_id,omitempty"` // 场景内稳定的对话 ID，见 AssignLineIDs
 	Character string `json:"character"`
 	Line      string `json:"line"`
 	Emotion   string `json:"emotion,omitempty"` // 情感：neutral, sad, happy, angry, fear, etc.
@@ -35,8 +42,9 @@ type DialogueLine struct {
 
 // Response 响应结构
 type Response struct {
-	Script     []Scene           `json:"script"`
-	Characters map[string]string `json:"characters"`
+	Script     []Scene         `json:"script"`
+	Characters character.Bible `json:"characters"`          // 角色设定，key 为规范角色名；兼容旧版的描述字符串
+	Locations  location.Bible  `json:"locations,omitempty"` // 地点设定，key 为地点 ID；旧版剧本没有
 }
 
 // Config 配置
@@ -44,10 +52,55 @@ type Config struct {
 	BaseURL string
 	APIKey  string
 	Model   string
+
+	// MaxChunkTokens 单次调用中小说内容的 token 上限（估算值），超过时按章节分段生成，见 SplitChunks
+	// 为 0 时使用 DefaultMaxChunkTokens
+	MaxChunkTokens int
+
+	// ResponseFormat 通过 response_format 要求模型输出 JSON（json_object 或 json_schema），
+	// 为空时只靠提示词；服务商不支持时自动降级，见 llmjson.Format
+	ResponseFormat llmjson.Format
+}
+
+// maxChunkTokens 返回分段的 token 上限
+func (c Config) maxChunkTokens() int {
+	if c.MaxChunkTokens > 0 {
+		return c.MaxChunkTokens
+	}
+	return DefaultMaxChunkTokens
 }
 
 // Process 处理小说文本，生成剧本和角色描述
-func Process(novelText string, cfg Config) (*Response, error) {
+// 小说超过 cfg.MaxChunkTokens 时按章节分段，逐段生成场景并携带前情提要和已有角色，最后合并为一个剧本；
+// 生成后场景中的角色别名统一为规范角色名（见 Canonicalize），location_id 统一为地点 ID
+// ctx 被取消时，进行中的模型调用会被中止
+func Process(ctx context.Context, novelText string, cfg Config) (*Response, error) {
+	client := newClient(cfg)
+
+	chunks := SplitChunks(novelText, cfg.maxChunkTokens())
+	if len(chunks) > 1 {
+		response, _, err := processChunks(ctx, client, chunks, storyState{characters: make(character.Bible), locations: make(location.Bible)}, cfg)
+		return response, err
+	}
+
+	var response Response
+	check := func() error {
+		response.canonicalizeLocations(nil)
+		return checkGenerated(&response)
+	}
+	if err := complete(ctx, client, cfg, buildPrompt(novelText), &response, check); err != nil {
+		return nil, err
+	}
+	response.Characters.Normalize()
+	response.Locations.Normalize()
+	printRenamed(response.Canonicalize(nil), response.UnresolvedNames())
+	response.AssignLineIDs()
+
+	return &response, nil
+}
+
+// newClient 创建模型客户端
+func newClient(cfg Config) *openai.Client {
 	config := openai.DefaultConfig(cfg.APIKey)
 	config.BaseURL = cfg.BaseURL
 
@@ -61,10 +114,12 @@ func Process(novelText string, cfg Config) (*Response, error) {
 	}
 	config.HTTPClient = httpClient
 
-	client := openai.NewClientWithConfig(config)
-
-	prompt := buildPrompt(novelText)
+	return openai.NewClientWithConfig(config)
+}
 
+// complete 调用模型并将返回的 JSON 解析到 v
+// 输出不符合 v 的结构或没有通过 check 时，把错误发回给模型修正（见 llmjson.Complete）
+func complete(ctx context.Context, client *openai.Client, cfg Config, prompt string, v any, check func() error) error {
 	req := openai.ChatCompletionRequest{
 		Model: cfg.Model,
 		Messages: []openai.ChatCompletionMessage{
@@ -79,36 +134,21 @@ func Process(novelText string, cfg Config) (*Response, error) {
 		},
 	}
 
-	resp, err := client.CreateChatCompletion(context.Background(), req)
-	if err != nil {
-		return nil, fmt.Errorf("API调用失败: %w", err)
-	}
-
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("API返回空响应")
-	}
-
-	content := resp.Choices[0].Message.Content
-
-	// 尝试提取JSON(如果响应包含代码块)
-	content = extractJSON(content)
-
-	var response Response
-	err = json.Unmarshal([]byte(content), &response)
-	if err != nil {
-		return nil, fmt.Errorf("解析JSON失败: %w\n原始响应: %s", err, content)
-	}
-
-	return &response, nil
+	return llmjson.Complete(ctx, client, req, v, llmjson.Options{
+		Format:   cfg.ResponseFormat,
+		Provider: cfg.BaseURL,
+		Check:    check,
+		OnRepair: func(attempt int, err error) {
+			fmt.Printf("⚠️  剧本校验失败，请模型修正(第 %d 次): %v\n", attempt, err)
+		},
+	})
 }
 
-func buildPrompt(novelText string) string {
-	return fmt.Sprintf(`请将以下小说改编成结构化的视觉剧本格式,并设计主要角色的视觉描述。
-
-要求:
-1. 将小说按照故事情节的起承转合,改编成一系列关键场景(scene),每个场景包含:
+// scriptRequirements 场景和角色描述的改编要求，整篇生成和分段生成共用
+const scriptRequirements = `1. 将小说按照故事情节的起承转合,改编成一系列关键场景(scene),每个场景包含:
    - scene_id: 场景序号(从1开始)
-   - location: 场景地点的简短描述
+   - location: 场景地点的简短描述(可以比地点设定更具体,如 "外婆家的卧室")
+   - location_id: 场景所在地点的 ID,必须是 locations 中的 key;同一地点的所有场景使用同一个 ID
    - time_of_day: 场景发生的时间 (例如: "白天", "夜晚", "黄昏", "清晨")
    - characters_present: 此场景出现的角色名称列表
    - scene_description: 对场景的**视觉描述**。这包括环境、氛围、以及角色的**关键动作和表情**。这是**改编的核心**,需要将小说的描述性文字(包括心理活动)转换成**可被看见**的画面。此字段将用于后续图像生成,必须具体、生动且富有画面感。
@@ -118,50 +158,48 @@ func buildPrompt(novelText string) string {
      * 根据角色的情绪和场景氛围合理选择emotion,常用情感: happy(开心)、sad(悲伤)、angry(生气)、fear(害怕)、amaze(惊讶)
    - narration_vo: (可选) 仅包含那些需要作为**画外音**被朗读出来的旁白或内心独白。如果此场景没有旁白,则为空字符串 ""。
 
-2. 提取并设计所有主要角色的视觉描述:
-   - 必须包含: 年龄、性别、发型、发色、眼睛、身材、典型服装、气质或显著特征。
+2. 提取并设计所有出场角色的设定,每个角色是一个对象,包含:
+   - aliases: 小说中对该角色的其他称呼(如昵称、职务、"她的母亲"之类的固定指代),没有则为 []
+   - age: 年龄,如 "8岁"、"十七八岁"、"中年"
+   - gender: 性别,只能是 "male" 或 "female"
+   - hair: 发型和发色
+   - eyes: 眼睛(颜色、眼型)
+   - build: 身材和身高
+   - outfit: 典型服装(默认造型)
+   - personality: 性格和气质
+   - voice: 声音特点,如 "清脆稚嫩"、"低沉沙哑",用于选择配音
+   - description: 其他显著的视觉特征(如配饰、伤疤、随身物品),没有则为空字符串
    - **重要**: 如果小说中缺乏具体的视觉描述,请你作为"角色设计师",根据角色的性格、背景和行为**合理推断**并**创造**其视觉形象。
-   - 将这些信息整合成一个**单一的、连贯的字符串**,作为视觉提示词(适合图像生成使用)。
+   - 视觉相关字段(hair、eyes、build、outfit、description)会直接用于图像生成,必须具体、可被画出来。
+   - 场景和对话中统一使用角色名(characters 的 key),不要使用别名。characters_present 和对话中出现的每个角色(旁白除外)都必须在 characters 中有设定。
 
-请以JSON格式返回,包含两个字段:
+3. 整理所有场景发生的地点,为每个地点设计统一的视觉设定,每个地点是一个对象,包含:
+   - name: 地点名称,如 "外婆家"
+   - aliases: 小说中对该地点的其他称呼,没有则为 []
+   - description: 地点的**规范视觉描述**(建筑风格、空间布局、标志性陈设、材质和色调)。同一地点的所有场景都使用这段描述生成图片,必须具体、可被画出来,不要包含随场景变化的内容(时间、天气、人物和动作)。
+   - 地点 ID(locations 的 key) 使用简短的英文小写单词加下划线,如 "grandma_house"、"forest_path"。
+   - 同一个地方(如同一间酒馆)只设计一次,在不同场景中反复出现时使用同一个 ID。`
+
+func buildPrompt(novelText string) string {
+	return fmt.Sprintf(`请将以下小说改编成结构化的视觉剧本格式,并设计主要角色的视觉描述。
+
+要求:
+%s
+
+请以JSON格式返回,包含三个字段:
 - script: 场景数组
-- characters: 角色名到视觉描述字符串的映射(对象),格式如: {"角色名": "完整的视觉描述字符串"}
+- characters: 角色名到角色设定对象的映射,格式如: {"角色名": {"aliases": [...], "age": "...", ...}}
+- locations: 地点 ID 到地点设定对象的映射,格式如: {"地点ID": {"name": "...", "aliases": [...], "description": "..."}}
 
 注意:
 - 根据故事情节改编成合适数量的关键场景,不要受限于固定数量。
-- 只设计主要角色(出场较多或重要的角色)。
-- characters的每个值必须是单个字符串,包含完整的视觉描述。
+- 场景中出场或说话的每个角色都要设计设定,次要角色的设定可以简略,但不能全部为空。
 - dialogue的示例: {"character": "小红帽", "line": "外婆，你的耳朵怎么这么大？", "emotion": "fear"}
-- 角色视觉描述示例: {"小红帽": "8岁女孩，天真无邪，金色及肩卷发，蓝色大眼睛，穿着一件标志性的红色天鹅绒兜帽斗篷，内搭棕色连衣裙和白色围裙，提着一个柳条篮子。"}
+- 角色设定示例: {"小红帽": {"aliases": ["小姑娘"], "age": "8岁", "gender": "female", "hair": "金色及肩卷发", "eyes": "蓝色大眼睛", "build": "娇小", "outfit": "标志性的红色天鹅绒兜帽斗篷，内搭棕色连衣裙和白色围裙", "personality": "天真无邪，好奇心强", "voice": "清脆稚嫩", "description": "提着一个柳条篮子"}}
+- 地点设定示例: {"grandma_house": {"name": "外婆家", "aliases": ["林中小屋"], "description": "森林深处的木结构小屋，长满青苔的石砌烟囱，屋内有铺着碎花被子的木床、壁炉和摇椅，暖黄色调"}}
 
 小说内容:
 %s
 
-请直接返回JSON,不要添加其他说明文字。确保characters字段的值是字符串而不是对象。`, novelText)
-}
-
-func extractJSON(content string) string {
-	// 移除可能的markdown代码块标记
-	if len(content) > 7 && content[:3] == "```" {
-		start := 0
-		// 跳过```json或```
-		for i := 3; i < len(content); i++ {
-			if content[i] == '\n' {
-				start = i + 1
-				break
-			}
-		}
-		// 找到结束的```
-		end := len(content)
-		for i := len(content) - 1; i >= 0; i-- {
-			if i >= 2 && content[i-2:i+1] == "```" {
-				end = i - 2
-				break
-			}
-		}
-		if start < end {
-			content = content[start:end]
-		}
-	}
-	return content
+请直接返回JSON,不要添加其他说明文字。`, scriptRequirements, novelText)
 }
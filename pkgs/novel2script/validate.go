@@ -0,0 +1,181 @@
+package novel2script
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseResponse 严格解析剧本 JSON（不允许未知字段）并校验内容
+// 用于校验人工修改后提交的剧本
+func ParseResponse(data []byte) (*Response, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var response Response
+	if err := decoder.Decode(&response); err != nil {
+		return nil, fmt.Errorf("解析剧本 JSON 失败: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("解析剧本 JSON 失败: JSON 之后存在多余内容")
+	}
+	if err := response.Validate(); err != nil {
+		return nil, err
+	}
+	response.Characters.Normalize()
+	response.Locations.Normalize()
+	response.Canonicalize(nil)
+	response.AssignLineIDs()
+	return &response, nil
+}
+
+// Validate 校验剧本内容
+// 要求至少一个场景，scene_id 为正数且不重复，场景描述、对话角色和台词不为空，
+// 场景内已设置的 line_id 不重复，角色设定不为空，已设置的 location_id 在 locations 中且地点描述不为空，
+// characters_present 和对话的 character 是旁白或 characters 中的角色（可以是别名，见 character.Bible.Resolve）
+func (r *Response) Validate() error {
+	if err := r.validateStructure(); err != nil {
+		return err
+	}
+	if errs := r.unknownNames(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// validateStructure 校验除角色名以外的剧本内容，见 Validate
+func (r *Response) validateStructure() error {
+	if len(r.Script) == 0 {
+		return fmt.Errorf("剧本至少需要一个场景")
+	}
+
+	seen := make(map[int]bool, len(r.Script))
+	for i, scene := range r.Script {
+		if scene.SceneID <= 0 {
+			return fmt.Errorf("script[%d].scene_id 必须为正数", i)
+		}
+		if seen[scene.SceneID] {
+			return fmt.Errorf("script[%d].scene_id 重复: %d", i, scene.SceneID)
+		}
+		seen[scene.SceneID] = true
+
+		if strings.TrimSpace(scene.SceneDescription) == "" {
+			return fmt.Errorf("场景 %d 的 scene_description 不能为空", scene.SceneID)
+		}
+		if scene.LocationID != "" {
+			if _, ok := r.Locations[scene.LocationID]; !ok {
+				return fmt.Errorf("场景 %d 的 location_id「%s」不在 locations 中", scene.SceneID, scene.LocationID)
+			}
+		}
+		lineIDs := make(map[int]bool, len(scene.Dialogue))
+		for j, d := range scene.Dialogue {
+			if d.LineID < 0 {
+				return fmt.Errorf("场景 %d 的 dialogue[%d].line_id 不能为负数", scene.SceneID, j)
+			}
+			if d.LineID > 0 {
+				if lineIDs[d.LineID] {
+					return fmt.Errorf("场景 %d 的 dialogue[%d].line_id 重复: %d", scene.SceneID, j, d.LineID)
+				}
+				lineIDs[d.LineID] = true
+			}
+			if strings.TrimSpace(d.Character) == "" {
+				return fmt.Errorf("场景 %d 的 dialogue[%d].character 不能为空", scene.SceneID, j)
+			}
+			if strings.TrimSpace(d.Line) == "" {
+				return fmt.Errorf("场景 %d 的 dialogue[%d].line 不能为空", scene.SceneID, j)
+			}
+		}
+	}
+
+	for name, c := range r.Characters {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("characters 中存在空的角色名")
+		}
+		if c.IsEmpty() {
+			return fmt.Errorf("角色 %s 的设定不能为空", name)
+		}
+	}
+	for id, l := range r.Locations {
+		if strings.TrimSpace(id) == "" {
+			return fmt.Errorf("locations 中存在空的地点 ID")
+		}
+		if l.Visual() == "" {
+			return fmt.Errorf("地点 %s 的 description 不能为空", id)
+		}
+	}
+	return nil
+}
+
+// unknownNames 返回 characters_present 和对话的 character 中既不是旁白、也对应不到 characters 中任何角色的名字
+// 这些角色没有视觉设定和音色，生成图片时只能写角色名，生成语音时只能使用默认音色
+func (r *Response) unknownNames() []error {
+	var errs []error
+	known := func(name string) bool {
+		if strings.TrimSpace(name) == narrator {
+			return true
+		}
+		_, ok := r.Characters.Resolve(name)
+		return ok
+	}
+	for _, scene := range r.Script {
+		for _, name := range scene.CharactersPresent {
+			if !known(name) {
+				errs = append(errs, fmt.Errorf("场景 %d 的 characters_present 中的「%s」不在 characters 中，请在 characters 中补充该角色或改用已有的角色名", scene.SceneID, name))
+			}
+		}
+		for j, d := range scene.Dialogue {
+			if !known(d.Character) {
+				errs = append(errs, fmt.Errorf("场景 %d 的 dialogue[%d].character「%s」不在 characters 中，请在 characters 中补充该角色或改用已有的角色名", scene.SceneID, j, d.Character))
+			}
+		}
+	}
+	return errs
+}
+
+// canonicalName 返回名字对应的规范角色名，对应不到角色时返回去掉首尾空白的原名
+func (r *Response) canonicalName(name string) string {
+	name = strings.TrimSpace(name)
+	if canonical, ok := r.Characters.Resolve(name); ok {
+		return canonical
+	}
+	return name
+}
+
+// maxCheckErrors checkGenerated 最多报告的问题数
+const maxCheckErrors = 20
+
+// checkGenerated 校验模型生成的剧本，不通过时错误会发回给模型修正
+// 在 Validate 的基础上要求 scene_id 从 1 开始连续编号，每个场景都有 location_id，
+// 对话的说话人（旁白除外）必须出现在该场景的 characters_present 中；
+// 角色名的问题和以上问题一并报告，多个问题用 errors.Join 合并
+func checkGenerated(r *Response) error {
+	if err := r.validateStructure(); err != nil {
+		return err
+	}
+
+	errs := r.unknownNames()
+	for i, scene := range r.Script {
+		if scene.SceneID != i+1 {
+			errs = append(errs, fmt.Errorf("script[%d].scene_id 应为 %d（从1开始连续编号），实际为 %d", i, i+1, scene.SceneID))
+		}
+		if strings.TrimSpace(scene.LocationID) == "" {
+			errs = append(errs, fmt.Errorf("场景 %d 缺少 location_id", scene.SceneID))
+		}
+		// 别名和规范角色名视为同一角色
+		present := make(map[string]bool, len(scene.CharactersPresent))
+		for _, name := range scene.CharactersPresent {
+			present[r.canonicalName(name)] = true
+		}
+		for j, d := range scene.Dialogue {
+			if name := r.canonicalName(d.Character); name != narrator && !present[name] {
+				errs = append(errs, fmt.Errorf("场景 %d 的 dialogue[%d].character「%s」不在该场景的 characters_present 中", scene.SceneID, j, d.Character))
+			}
+		}
+		if len(errs) >= maxCheckErrors {
+			break
+		}
+	}
+	return errors.Join(errs[:min(len(errs), maxCheckErrors)]...)
+}
@@ -0,0 +1,178 @@
+package storyboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TxtAnime/txt-anime/pkgs/backoff"
+	openai "github.com/sashabaranov/go-openai"
+	"golang.org/x/time/rate"
+)
+
+// errEmptyResponse 接口返回成功但没有图片数据，通常是服务商的临时故障，可以重试
+var errEmptyResponse = errors.New("API返回空数据")
+
+// Limits 图片生成请求的并发、限流和重试设置
+type Limits struct {
+	Concurrency   int           // 同时进行的请求数上限，0 表示不限制
+	RatePerMinute float64       // 令牌桶每分钟补充的令牌数，即平均每分钟最多发起的请求数，0 表示不限制
+	Burst         int           // 令牌桶容量，默认等于 Concurrency（至少为 1）
+	MaxAttempts   int           // 单张图片最多请求次数（含首次），默认 1，即不重试
+	Backoff       time.Duration // 首次重试前的等待时间，之后每次翻倍，默认 1 秒
+	MaxBackoff    time.Duration // 重试等待时间上限，默认 30 秒
+}
+
+// Limiter 图片生成请求的限流器：限制并发数和请求速率（令牌桶），并按指数退避加随机抖动重试可重试的错误
+// 同一服务商的所有请求应共享一个 Limiter，见 ProviderLimiter
+type Limiter struct {
+	limits Limits
+	slots  chan struct{} // 并发名额，nil 表示不限制
+	bucket *rate.Limiter // 令牌桶，nil 表示不限速
+}
+
+// NewLimiter 创建限流器
+func NewLimiter(limits Limits) *Limiter {
+	if limits.MaxAttempts <= 0 {
+		limits.MaxAttempts = 1
+	}
+	if limits.Backoff <= 0 {
+		limits.Backoff = time.Second
+	}
+	if limits.MaxBackoff <= 0 {
+		limits.MaxBackoff = 30 * time.Second
+	}
+
+	l := &Limiter{limits: limits}
+	if limits.Concurrency > 0 {
+		l.slots = make(chan struct{}, limits.Concurrency)
+	}
+	if limits.RatePerMinute > 0 {
+		burst := limits.Burst
+		if burst <= 0 {
+			burst = max(limits.Concurrency, 1)
+		}
+		l.bucket = rate.NewLimiter(rate.Limit(limits.RatePerMinute/60), burst)
+	}
+	return l
+}
+
+// providerLimiters 各服务商共享的限流器，key 为 ProviderLimiter 的 provider
+var providerLimiters sync.Map
+
+// ProviderLimiter 返回服务商（如 BaseURL）共享的限流器
+// 同一 provider 第一次调用时按 limits 创建，之后的调用返回同一个限流器并忽略 limits
+func ProviderLimiter(provider string, limits Limits) *Limiter {
+	if l, ok := providerLimiters.Load(provider); ok {
+		return l.(*Limiter)
+	}
+	l, _ := providerLimiters.LoadOrStore(provider, NewLimiter(limits))
+	return l.(*Limiter)
+}
+
+// Do 在限流下执行一次请求 fn，fn 返回可重试的错误（见 IsRetryable）时退避后重试
+// 每次请求前等待并发名额和令牌，退避等待期间不占用名额。l 为 nil 时直接执行一次
+func (l *Limiter) Do(ctx context.Context, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := l.acquire(ctx); err != nil {
+			return err
+		}
+		err := fn()
+		l.release()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		if attempt >= l.limits.MaxAttempts {
+			if attempt > 1 {
+				return fmt.Errorf("请求 %d 次后仍失败: %w", attempt, err)
+			}
+			return err
+		}
+
+		delay := l.backoff(attempt)
+		fmt.Printf("⚠️  图片生成请求失败（第 %d 次），%v 后重试: %v\n", attempt, delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// acquire 等待并发名额和令牌
+func (l *Limiter) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if l.bucket != nil {
+		if err := l.bucket.Wait(ctx); err != nil {
+			l.release()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("等待请求令牌失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// release 归还并发名额
+func (l *Limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// backoff 第 attempt 次失败后的等待时间，见 backoff.Jittered
+func (l *Limiter) backoff(attempt int) time.Duration {
+	return backoff.Jittered(l.limits.Backoff, l.limits.MaxBackoff, attempt)
+}
+
+// IsRetryable 判断图片生成请求的错误是否值得重试
+// 限流（429）、请求超时（408）、服务端错误（5xx，501 除外）、网络错误和空响应可以重试；
+// 其他 4xx（参数错误、鉴权失败、内容审核拒绝等）和上下文取消重试也不会成功
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if status := statusCode(err); status != 0 {
+		return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout ||
+			(status >= 500 && status != http.StatusNotImplemented)
+	}
+	if errors.Is(err, errEmptyResponse) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// statusCode 返回错误对应的 HTTP 状态码，不是 HTTP 错误时返回 0
+func statusCode(err error) int {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	var editErr *editError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		return reqErr.HTTPStatusCode
+	case errors.As(err, &editErr):
+		return editErr.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,201 @@
+package storyboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// MaxReferenceImages 单张场景图片最多携带的角色设定图数量
+const MaxReferenceImages = 4
+
+// Reference 生成场景图片时作为参考的角色设定图
+type Reference struct {
+	Name  string // 角色名
+	Image []byte // PNG 图片
+}
+
+// GenerateReferenceSheet 生成角色设定图（三视图和面部特写），之后作为该角色所有场景图片的参考
+func GenerateReferenceSheet(ctx context.Context, name string, c character.Character, cfg Config) ([]byte, error) {
+	return GenerateImageFromPrompt(ctx, BuildReferencePrompt(name, c, cfg.Style), cfg)
+}
+
+// BuildReferencePrompt 构建角色设定图的提示词：纯色背景上的正面、侧面、背面全身图和面部特写
+// 设定图使用与场景图片相同的画风，style 为画风预设名
+func BuildReferencePrompt(name string, c character.Character, style string) string {
+	var sb strings.Builder
+	s := styleOrDefault(style)
+	sb.WriteString(fmt.Sprintf("%s. Character reference sheet of %s. ", s.Prefix, name))
+	sb.WriteString("Full-body turnaround showing front view, side view and back view, plus a face close-up, ")
+	sb.WriteString("standing in a neutral pose on a plain white background. ")
+	if visual := c.Visual(); visual != "" {
+		sb.WriteString(fmt.Sprintf("Character design: %s. ", visual))
+	}
+	sb.WriteString("Consistent proportions, outfit and colors across all views, clean line art, flat even lighting. ")
+	sb.WriteString("NO text, NO labels, NO background scenery.")
+	if s.Negative != "" {
+		sb.WriteString(fmt.Sprintf(" Avoid: %s.", s.Negative))
+	}
+	return sb.String()
+}
+
+// editUnsupported 记录不支持参考图（图片编辑接口）的服务商和模型，key 为 "<BaseURL>|<Model>"
+// 回退到纯文本生成成功后写入，之后的调用直接使用纯文本生成
+var editUnsupported sync.Map
+
+// GenerateImageWithReferences 以角色设定图为参考生成图片
+// 通过图片编辑接口（/images/edits，多张 image[]）发送参考图；服务商不支持该接口或不接受多张图片时
+// 回退到只用提示词生成，并记住该服务商和模型，之后不再尝试。refs 为空时直接使用提示词生成
+func GenerateImageWithReferences(ctx context.Context, prompt string, refs []Reference, cfg Config) ([]byte, error) {
+	key := cfg.BaseURL + "|" + cfg.Model
+	if len(refs) == 0 {
+		return GenerateImageFromPrompt(ctx, prompt, cfg)
+	}
+	if _, ok := editUnsupported.Load(key); ok {
+		return GenerateImageFromPrompt(ctx, prompt, cfg)
+	}
+
+	var imageData []byte
+	err := cfg.Limiter.Do(ctx, func() error {
+		var err error
+		imageData, err = generateImageEdit(ctx, referencePrompt(prompt, refs), refs, cfg)
+		return err
+	})
+	if err == nil {
+		return imageData, nil
+	}
+	if !isEditRejected(err) {
+		return nil, err
+	}
+
+	fmt.Printf("⚠️  模型 %s 不支持参考图，改用纯文本生成: %v\n", cfg.Model, err)
+	imageData, err = GenerateImageFromPrompt(ctx, prompt, cfg)
+	if err != nil {
+		return nil, err
+	}
+	editUnsupported.Store(key, true)
+	return imageData, nil
+}
+
+// referencePrompt 在提示词前说明每张参考图对应的角色
+func referencePrompt(prompt string, refs []Reference) string {
+	var sb strings.Builder
+	sb.WriteString("Use the attached character reference sheets to keep every character's appearance consistent: ")
+	for i, ref := range refs {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(fmt.Sprintf("image %d is %s", i+1, ref.Name))
+	}
+	sb.WriteString(". Match their faces, hairstyles, outfits and colors exactly, but do not copy the reference sheet layout. ")
+	sb.WriteString(prompt)
+	return sb.String()
+}
+
+// editError 图片编辑接口返回的错误
+type editError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *editError) Error() string {
+	return fmt.Sprintf("图片编辑接口返回 %d: %s", e.StatusCode, e.Message)
+}
+
+// isEditRejected 判断错误是否可能是服务商不支持图片编辑接口或多张参考图造成的
+// 按状态码判断；误判时回退后的纯文本请求仍会失败，不会被记住
+func isEditRejected(err error) bool {
+	var editErr *editError
+	if !errors.As(err, &editErr) {
+		return false
+	}
+	switch editErr.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// generateImageEdit 调用 OpenAI 兼容的 /images/edits 接口，以 multipart 表单发送提示词和多张参考图
+func generateImageEdit(ctx context.Context, prompt string, refs []Reference, cfg Config) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	fields := [][2]string{
+		{"model", cfg.Model},
+		{"prompt", prompt},
+		{"n", "1"},
+		{"size", cfg.ImageSize},
+		{"response_format", string(openai.CreateImageResponseFormatB64JSON)},
+	}
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+		if err := writer.WriteField(f[0], f[1]); err != nil {
+			return nil, fmt.Errorf("构建请求失败: %w", err)
+		}
+	}
+	for i, ref := range refs {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="image[]"; filename="reference_%d.png"`, i+1))
+		header.Set("Content-Type", "image/png")
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("构建请求失败: %w", err)
+		}
+		if _, err := part.Write(ref.Image); err != nil {
+			return nil, fmt.Errorf("构建请求失败: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("构建请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(cfg.BaseURL, "/")+"/images/edits", &body)
+	if err != nil {
+		return nil, fmt.Errorf("构建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("API调用失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &editError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+
+	var result openai.ImageResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	if len(result.Data) == 0 {
+		return nil, errEmptyResponse
+	}
+	imageData, err := base64.StdEncoding.DecodeString(result.Data[0].B64JSON)
+	if err != nil {
+		return nil, fmt.Errorf("解码base64失败: %w", err)
+	}
+	return imageData, nil
+}
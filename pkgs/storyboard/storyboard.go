@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/TxtAnime/txt-anime/pkgs/character"
+	"github.com/TxtAnime/txt-anime/pkgs/location"
 	openai "github.com/sashabaranov/go-openai"
 )
 
@@ -20,6 +22,7 @@ const (
 type Scene struct {
 	SceneID           int            `json:"scene_id"`
 	Location          string         `json:"location"`
+	LocationID        string         `json:"location_id,omitempty"` // 地点 ID，对应 ScriptData.Locations 的 key
 	TimeOfDay         string         `json:"time_of_day"`
 	CharactersPresent []string       `json:"characters_present"`
 	Characters        []string       `json:"characters"` // 兼容旧格式
@@ -36,8 +39,9 @@ type DialogueLine struct {
 
 // ScriptData 剧本数据
 type ScriptData struct {
-	Script     []Scene           `json:"script"`
-	Characters map[string]string `json:"characters"`
+	Script     []Scene         `json:"script"`
+	Characters character.Bible `json:"characters"`
+	Locations  location.Bible  `json:"locations,omitempty"`
 }
 
 // Config 配置
@@ -46,21 +50,31 @@ type Config struct {
 	APIKey    string
 	Model     string
 	ImageSize string
+	Style     string // 画风预设名，见 Styles，为空时使用 DefaultStyle
+
+	// Limiter 请求的并发、限流与重试，同一服务商应共享一个（见 ProviderLimiter）；为 nil 时不限制、不重试
+	Limiter *Limiter
 }
 
 // GenerateImage 生成场景图片
-func GenerateImage(scene Scene, characters map[string]string, cfg Config) ([]byte, error) {
+// ctx 被取消时，进行中的图片生成请求会被中止
+func GenerateImage(ctx context.Context, scene Scene, characters character.Bible, locations location.Bible, cfg Config) ([]byte, error) {
+	return GenerateImageFromPrompt(ctx, BuildPrompt(scene, characters, locations, cfg.Style), cfg)
+}
+
+// GenerateImageFromPrompt 使用给定的提示词生成图片
+// 用于在 BuildPrompt 的基础上追加要求或完全替换提示词后重新生成
+func GenerateImageFromPrompt(ctx context.Context, prompt string, cfg Config) ([]byte, error) {
 	config := openai.DefaultConfig(cfg.APIKey)
 	config.BaseURL = cfg.BaseURL
-	config.HTTPClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
-
-	prompt := BuildPrompt(scene, characters)
-
-	b64Data, err := generateImageInternal(config, prompt, cfg.ImageSize, cfg.Model)
+	config.HTTPClient = newHTTPClient()
+
+	var b64Data string
+	err := cfg.Limiter.Do(ctx, func() error {
+		var err error
+		b64Data, err = generateImageInternal(ctx, config, prompt, cfg.ImageSize, cfg.Model)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -74,13 +88,17 @@ func GenerateImage(scene Scene, characters map[string]string, cfg Config) ([]byt
 }
 
 // BuildPrompt 构建提示词 - 纯场景图片，无文字对话
-func BuildPrompt(scene Scene, characters map[string]string) string {
+// 出场角色使用角色设定中的视觉描述（年龄、性别、发型、眼睛、身材、服装等），没有设定的角色只写角色名；
+// 场景有 location_id 时使用地点设定中的视觉描述，同一地点的所有场景画面一致；
+// style 为画风预设名，不存在时使用默认画风
+func BuildPrompt(scene Scene, characters character.Bible, locations location.Bible, style string) string {
 	var sb strings.Builder
+	s := styleOrDefault(style)
 
-	// 基础动漫风格 - 纯场景图片，无文字
-	sb.WriteString("Anime visual novel style, detailed anime art, cinematic composition, ")
+	// 画风 - 纯场景图片，无文字
+	sb.WriteString(s.Prefix + ", ")
 	sb.WriteString("single full scene illustration, NO text, NO dialogue bubbles, NO subtitles, ")
-	sb.WriteString("high quality scene artwork, professional anime background. ")
+	sb.WriteString("high quality scene artwork, detailed background. ")
 
 	// 场景设定
 	sb.WriteString(fmt.Sprintf("Scene: %s", scene.Location))
@@ -91,6 +109,11 @@ func BuildPrompt(scene Scene, characters map[string]string) string {
 	}
 	sb.WriteString(". ")
 
+	// 地点设定（注入地点的"黄金描述"）
+	if l, ok := locations[scene.LocationID]; ok && l.Visual() != "" {
+		sb.WriteString(fmt.Sprintf("Setting: %s (%s). ", l.Name, l.Visual()))
+	}
+
 	// 获取角色列表（兼容两种格式）
 	var charList []string
 	if len(scene.CharactersPresent) > 0 {
@@ -103,8 +126,8 @@ func BuildPrompt(scene Scene, characters map[string]string) string {
 	if len(charList) > 0 {
 		sb.WriteString("Characters: ")
 		for i, charName := range charList {
-			if desc, ok := characters[charName]; ok {
-				sb.WriteString(desc)
+			if c, ok := characters[charName]; ok && c.Visual() != "" {
+				sb.WriteString(fmt.Sprintf("%s (%s)", charName, c.Visual()))
 			} else {
 				sb.WriteString(charName)
 			}
@@ -121,15 +144,26 @@ func BuildPrompt(scene Scene, characters map[string]string) string {
 	}
 
 	// 风格强化 - 强调纯视觉场景，无文字
-	sb.WriteString("High quality anime art, professional illustration, detailed backgrounds, ")
-	sb.WriteString("expressive characters, dramatic lighting, cinematic atmosphere. ")
+	sb.WriteString(s.Suffix + ". ")
 	sb.WriteString("NO text, NO dialogue bubbles, NO subtitles. ")
-	sb.WriteString("Clean visual storytelling, anime movie quality.")
+	sb.WriteString("Clean visual storytelling.")
+	if s.Negative != "" {
+		sb.WriteString(fmt.Sprintf(" Avoid: %s.", s.Negative))
+	}
 
 	return sb.String()
 }
 
-func generateImageInternal(config openai.ClientConfig, prompt string, size string, model string) (string, error) {
+// newHTTPClient 创建图片生成使用的 HTTP 客户端（跳过 TLS 证书验证）
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+func generateImageInternal(ctx context.Context, config openai.ClientConfig, prompt string, size string, model string) (string, error) {
 	client := openai.NewClientWithConfig(config)
 
 	req := openai.ImageRequest{
@@ -140,13 +174,13 @@ func generateImageInternal(config openai.ClientConfig, prompt string, size strin
 		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
 	}
 
-	resp, err := client.CreateImage(context.Background(), req)
+	resp, err := client.CreateImage(ctx, req)
 	if err != nil {
 		return "", fmt.Errorf("API调用失败: %w", err)
 	}
 
 	if len(resp.Data) == 0 {
-		return "", fmt.Errorf("API返回空数据")
+		return "", errEmptyResponse
 	}
 
 	return resp.Data[0].B64JSON, nil
@@ -0,0 +1,74 @@
+package storyboard
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultStyle 默认画风，未指定画风时使用
+const DefaultStyle = "anime"
+
+// Style 画风预设
+// 预设定义在 styles.json 中，新增或调整画风只需修改数据文件
+type Style struct {
+	Name     string `json:"name"`     // 预设名，如 "manga"
+	Label    string `json:"label"`    // 显示名称，如 "黑白漫画"
+	Prefix   string `json:"prefix"`   // 放在提示词开头的画风描述
+	Suffix   string `json:"suffix"`   // 放在提示词末尾的画质和风格强化
+	Negative string `json:"negative"` // 需要避免的元素，图片接口没有负面提示词参数，以 "Avoid: ..." 写入提示词
+}
+
+//go:embed styles.json
+var stylesJSON []byte
+
+// styles 全部画风预设，保持 styles.json 中的顺序
+var styles = mustLoadStyles(stylesJSON)
+
+// mustLoadStyles 解析画风预设，数据文件有误时 panic
+func mustLoadStyles(data []byte) []Style {
+	var list []Style
+	if err := json.Unmarshal(data, &list); err != nil {
+		panic(fmt.Sprintf("解析画风预设失败: %v", err))
+	}
+	seen := make(map[string]bool, len(list))
+	for _, s := range list {
+		if s.Name == "" || seen[s.Name] {
+			panic(fmt.Sprintf("画风预设名为空或重复: %q", s.Name))
+		}
+		seen[s.Name] = true
+	}
+	if !seen[DefaultStyle] {
+		panic(fmt.Sprintf("缺少默认画风预设 %s", DefaultStyle))
+	}
+	return list
+}
+
+// Styles 返回全部画风预设
+func Styles() []Style {
+	return append([]Style(nil), styles...)
+}
+
+// LookupStyle 按预设名查找画风，name 为空时返回默认画风
+func LookupStyle(name string) (Style, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = DefaultStyle
+	}
+	for _, s := range styles {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Style{}, false
+}
+
+// styleOrDefault 返回预设名对应的画风，找不到时使用默认画风
+func styleOrDefault(name string) Style {
+	if s, ok := LookupStyle(name); ok {
+		return s
+	}
+	s, _ := LookupStyle(DefaultStyle)
+	return s
+}